@@ -110,10 +110,12 @@ if [ $? -ne 0 ]; then
 fi
 echo -e "${GREEN}      ✓ Frontend build completed${NC}"
 
-# Copy config.json to dist/public (ensure it exists for Go server)
+# Copy config.json to dist/data (the Go server never serves it as a static file)
 if [ -f "client/public/config.json" ]; then
-    cp client/public/config.json dist/public/config.json
-    echo -e "${GREEN}      ✓ config.json copied to dist/public${NC}"
+    mkdir -p dist/data
+    cp client/public/config.json dist/data/config.json
+    rm -f dist/public/config.json
+    echo -e "${GREEN}      ✓ config.json copied to dist/data${NC}"
 fi
 
 # Step 4: Obfuscation (optional)
@@ -167,7 +169,7 @@ if [ "$USE_GO" = true ]; then
     LDFLAGS="-s -w -X main.Version=${VERSION} -X main.BuildTime=${BUILD_TIME}"
     
     # Build Go binary
-    CGO_ENABLED=0 go build -ldflags="${LDFLAGS}" -o ../dist/server main.go
+    CGO_ENABLED=0 go build -ldflags="${LDFLAGS}" -o ../dist/server .
     
     if [ $? -ne 0 ]; then
         echo -e "${RED}Go build failed!${NC}"
@@ -182,7 +184,7 @@ if [ "$USE_GO" = true ]; then
     cat > dist/run.sh << 'EOF'
 #!/bin/bash
 cd "$(dirname "$0")"
-./server --static ./public "$@"
+./server --static ./public --config ./data "$@"
 EOF
     chmod +x dist/run.sh
     
@@ -246,6 +248,9 @@ echo ""
 echo "Server options (Go only):"
 echo "  --port PORT       Set server port (default: 5000)"
 echo "  --host HOST       Set server host (default: 0.0.0.0)"
+echo "  --config DIR      Data directory for config.json (default: ./data)"
+echo "  --migrate-dry-run Show pending config migrations and exit"
+echo "  --config-key-file FILE  Encrypt the config at rest with this key"
 echo "  --gzip=false      Disable gzip compression"
 echo "  --cache=false     Disable cache headers"
 echo "  --logging=false   Disable request logging"
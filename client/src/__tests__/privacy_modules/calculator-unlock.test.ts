@@ -1,5 +1,5 @@
 import { describe, it, expect, vi, beforeEach, afterEach } from 'vitest';
-import { createSequenceChecker } from '@/privacy_modules/calculator/unlock-logic';
+import { createSequenceChecker, createRemoteSequenceChecker } from '@/privacy_modules/calculator/unlock-logic';
 
 describe('Calculator Sequence Unlock', () => {
   beforeEach(() => {
@@ -143,4 +143,45 @@ describe('Calculator Sequence Unlock', () => {
       expect(checker.getSequence()).toBe('');
     });
   });
+
+  describe('createRemoteSequenceChecker', () => {
+    it('should send the displayed number with = to the server', async () => {
+      const verify = vi.fn().mockResolvedValue(true);
+      const checker = createRemoteSequenceChecker(verify);
+
+      expect(await checker.submit('1234')).toBe(true);
+      expect(verify).toHaveBeenCalledWith('1234=');
+    });
+
+    it('should not send short numbers or results', async () => {
+      const verify = vi.fn().mockResolvedValue(true);
+      const checker = createRemoteSequenceChecker(verify);
+
+      expect(await checker.submit('123')).toBe(false);
+      expect(await checker.submit('1234.5')).toBe(false);
+      expect(await checker.submit('-1234')).toBe(false);
+      expect(verify).not.toHaveBeenCalled();
+    });
+
+    it('should report the server result', async () => {
+      const verify = vi.fn().mockResolvedValue(false);
+      const checker = createRemoteSequenceChecker(verify);
+
+      expect(await checker.submit('9999')).toBe(false);
+      expect(verify).toHaveBeenCalledTimes(1);
+    });
+
+    it('should ignore submits while one is in flight', async () => {
+      let resolve: (valid: boolean) => void = () => {};
+      const verify = vi.fn(() => new Promise<boolean>((r) => { resolve = r; }));
+      const checker = createRemoteSequenceChecker(verify);
+
+      const first = checker.submit('1234');
+      expect(await checker.submit('1234')).toBe(false);
+      resolve(true);
+
+      expect(await first).toBe(true);
+      expect(verify).toHaveBeenCalledTimes(1);
+    });
+  });
 });
@@ -1,5 +1,5 @@
 import { describe, it, expect, vi, beforeEach, afterEach } from 'vitest';
-import { createPhraseChecker } from '@/privacy_modules/notepad/unlock-logic';
+import { createPhraseChecker, createRemotePhraseChecker, lineAtCursor } from '@/privacy_modules/notepad/unlock-logic';
 
 describe('Notepad Phrase Unlock', () => {
   beforeEach(() => {
@@ -214,4 +214,63 @@ describe('Notepad Phrase Unlock', () => {
       expect(onUnlock).not.toHaveBeenCalled();
     });
   });
+
+  describe('lineAtCursor', () => {
+    it('should return the trimmed line containing the cursor', () => {
+      const text = 'shopping list\n  secret \nmilk';
+
+      expect(lineAtCursor(text, 0)).toBe('shopping list');
+      expect(lineAtCursor(text, 17)).toBe('secret');
+      expect(lineAtCursor(text, 23)).toBe('secret');
+      expect(lineAtCursor(text, text.length)).toBe('milk');
+    });
+  });
+
+  describe('createRemotePhraseChecker', () => {
+    it('should send the line under the cursor to the server', async () => {
+      const onUnlock = vi.fn();
+      const verify = vi.fn().mockResolvedValue(true);
+      const { submit } = createRemotePhraseChecker(verify, onUnlock);
+
+      submit('shopping list\n  secret \nmilk', 18);
+      await vi.waitFor(() => expect(onUnlock).toHaveBeenCalledTimes(1));
+      expect(verify).toHaveBeenCalledWith('secret');
+    });
+
+    it('should not send short or empty lines', () => {
+      const verify = vi.fn().mockResolvedValue(true);
+      const { submit } = createRemotePhraseChecker(verify, vi.fn());
+
+      submit('ok', 1);
+      submit('ok\n\n', 3);
+
+      expect(verify).not.toHaveBeenCalled();
+    });
+
+    it('should not unlock when the server rejects the attempt', async () => {
+      const onUnlock = vi.fn();
+      const verify = vi.fn().mockRejectedValue(new Error('locked'));
+      const { submit } = createRemotePhraseChecker(verify, onUnlock);
+
+      submit('secret', 0);
+      await vi.waitFor(() => expect(verify).toHaveBeenCalledTimes(1));
+      await Promise.resolve();
+
+      expect(onUnlock).not.toHaveBeenCalled();
+    });
+
+    it('should not unlock after cleanup', async () => {
+      const onUnlock = vi.fn();
+      let resolve: (valid: boolean) => void = () => {};
+      const verify = vi.fn(() => new Promise<boolean>((r) => { resolve = r; }));
+      const { submit, cleanup } = createRemotePhraseChecker(verify, onUnlock);
+
+      submit('secret', 0);
+      cleanup();
+      resolve(true);
+      await Promise.resolve();
+
+      expect(onUnlock).not.toHaveBeenCalled();
+    });
+  });
 });
@@ -0,0 +1,74 @@
+import { memo, useState } from "react";
+import { Button } from "@/components/ui/button";
+import { Input } from "@/components/ui/input";
+import { KeyRound } from "lucide-react";
+import { useI18n } from "@/lib/i18n";
+
+const CODE_LENGTH = 6;
+
+export interface CodeOverlayProps {
+  onCodeComplete: (code: string) => void;
+  onClose: () => void;
+  codeError: boolean;
+}
+
+export const CodeOverlay = memo(function CodeOverlay({
+  onCodeComplete,
+  onClose,
+  codeError
+}: CodeOverlayProps) {
+  const { t } = useI18n();
+  const [code, setCode] = useState('');
+  
+  const handleChange = (value: string) => {
+    const digits = value.replace(/\D/g, '').slice(0, CODE_LENGTH);
+    setCode(digits);
+    if (digits.length === CODE_LENGTH) {
+      onCodeComplete(digits);
+      setCode('');
+    }
+  };
+  
+  return (
+    <div 
+      className="fixed inset-0 z-50 flex items-center justify-center bg-background/95 backdrop-blur-sm"
+      onClick={onClose}
+      data-testid="code-overlay"
+    >
+      <div 
+        className="flex flex-col items-center gap-6 p-6"
+        onClick={(e) => e.stopPropagation()}
+      >
+        <div className="flex items-center gap-2 text-muted-foreground">
+          <KeyRound className="w-5 h-5" />
+          <span className="text-sm font-medium">{t.game2048.enterCode}</span>
+        </div>
+        
+        <Input
+          value={code}
+          onChange={(e) => handleChange(e.target.value)}
+          inputMode="numeric"
+          autoComplete="one-time-code"
+          autoFocus
+          maxLength={CODE_LENGTH}
+          className={`w-48 text-center text-2xl tracking-[0.5em] font-mono ${codeError ? 'animate-shake ring-2 ring-destructive' : ''}`}
+          data-testid="input-unlock-code"
+        />
+        
+        <p className="text-xs text-muted-foreground text-center max-w-[200px]">
+          {t.game2048.codeHint}
+        </p>
+        
+        <Button
+          variant="ghost"
+          size="sm"
+          onClick={onClose}
+          className="text-muted-foreground"
+          data-testid="button-cancel-code"
+        >
+          {t.game2048.cancel}
+        </Button>
+      </div>
+    </div>
+  );
+});
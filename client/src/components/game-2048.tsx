@@ -1,11 +1,13 @@
 import { useEffect, useRef, memo, useMemo } from "react";
 import { RefreshCw, Trophy } from "lucide-react";
 import { useI18n } from "@/lib/i18n";
+import type { GestureType } from "@/config";
 import { GESTURE } from "@/lib/constants";
 import { useGame2048 } from "@/hooks/use-game-2048";
 import { useSecretGesture } from "@/hooks/use-secret-gesture";
 import { usePWABanner } from "@/hooks/use-pwa-banner";
 import { PatternOverlay } from "@/components/pattern-overlay";
+import { CodeOverlay } from "@/components/code-overlay";
 import { PWAInstallBanner } from "@/components/pwa-install-banner";
 
 const TILE_COLORS: Record<number, { bg: string; text: string }> = {
@@ -82,13 +84,15 @@ const GameOverlay = memo(function GameOverlay({ gameOver, won, onNewGame, onKeep
 
 interface Game2048Props {
   onSecretGesture?: () => void;
-  gestureType?: 'patternUnlock' | 'severalFingers';
+  gestureType?: GestureType;
   secretPattern?: string;
   unlockFingers?: number;
   onActivity?: () => void;
+  verifyPattern?: (pattern: string) => Promise<boolean>;
+  verifyCode?: (code: string) => Promise<boolean>;
 }
 
-export function Game2048({ onSecretGesture, gestureType = 'patternUnlock', secretPattern = '', unlockFingers = 4, onActivity }: Game2048Props) {
+export function Game2048({ onSecretGesture, gestureType = 'patternUnlock', secretPattern = '', unlockFingers = 4, onActivity, verifyPattern, verifyCode }: Game2048Props) {
   const { t } = useI18n();
   const {
     grid,
@@ -107,7 +111,11 @@ export function Game2048({ onSecretGesture, gestureType = 'patternUnlock', secre
     handleSecretTap,
     handlePatternComplete,
     handleClosePatternOverlay,
-  } = useSecretGesture({ onSecretGesture, gestureType, secretPattern, unlockFingers });
+    showCodeOverlay,
+    codeError,
+    handleCodeComplete,
+    handleCloseCodeOverlay,
+  } = useSecretGesture({ onSecretGesture, gestureType, secretPattern, unlockFingers, verifyPattern, verifyCode });
   
   const pwa = usePWABanner();
   
@@ -247,6 +255,14 @@ export function Game2048({ onSecretGesture, gestureType = 'patternUnlock', secre
         />
       )}
       
+      {showCodeOverlay && (
+        <CodeOverlay
+          onCodeComplete={handleCodeComplete}
+          onClose={handleCloseCodeOverlay}
+          codeError={codeError}
+        />
+      )}
+      
       {pwa.shouldShow && (
         <PWAInstallBanner
           onInstall={pwa.handleInstall}
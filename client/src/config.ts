@@ -2,7 +2,7 @@
  * Application Configuration
  */
 
-export type GestureType = 'patternUnlock' | 'severalFingers';
+export type GestureType = 'patternUnlock' | 'severalFingers' | 'totp';
 
 export const CONFIG = {
   // === PRIVACY MODE ===
@@ -31,6 +31,7 @@ export const CONFIG = {
   // Type of gesture required to unlock the camera when privacy mode is active
   // 'patternUnlock': Requires drawing a specific pattern on the screen (recommended)
   // 'severalFingers': Requires touching the screen with multiple fingers simultaneously (3-9 fingers)
+  // 'totp': 5 taps open a 6-digit authenticator code entry (needs the backend and an enrolled secret)
   UNLOCK_GESTURE: 'severalFingers' as GestureType,
 
   // Secret pattern for 'patternUnlock' mode - sequence of grid positions separated by dashes
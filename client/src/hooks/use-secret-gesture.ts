@@ -1,11 +1,16 @@
 import { useState, useCallback, useRef, useEffect } from "react";
 import { GESTURE, TIMING } from "@/lib/constants";
+import type { GestureType } from "@/config";
+import { UnlockLockedError } from "@/lib/unlock-client";
 
 export interface UseSecretGestureOptions {
   onSecretGesture?: () => void;
-  gestureType?: 'patternUnlock' | 'severalFingers';
+  gestureType?: GestureType;
   secretPattern?: string;
   unlockFingers?: number;
+  verifyPattern?: (pattern: string) => Promise<boolean>;
+  /** Checks an authenticator code; 'totp' needs the backend to unlock. */
+  verifyCode?: (code: string) => Promise<boolean>;
 }
 
 export interface UseSecretGestureReturn {
@@ -14,20 +19,40 @@ export interface UseSecretGestureReturn {
   handleSecretTap: (isFromTouch?: boolean) => void;
   handlePatternComplete: (pattern: number[]) => void;
   handleClosePatternOverlay: () => void;
+  showCodeOverlay: boolean;
+  codeError: boolean;
+  handleCodeComplete: (code: string) => void;
+  handleCloseCodeOverlay: () => void;
 }
 
 export function patternToString(pattern: number[]): string {
   return pattern.join('-');
 }
 
+/**
+ * How long an attempt's error indicator stays up. While the server has
+ * locked out unlock attempts it stays up until the lockout ends, so the
+ * user does not keep trying against a limiter that ignores every attempt.
+ */
+function errorDisplayMs(error: unknown): number {
+  if (error instanceof UnlockLockedError) {
+    return error.retryAfterSeconds * 1000;
+  }
+  return TIMING.TAP_TIMEOUT_MS;
+}
+
 export function useSecretGesture({
   onSecretGesture,
   gestureType = 'patternUnlock',
   secretPattern = '',
   unlockFingers = 4,
+  verifyPattern,
+  verifyCode,
 }: UseSecretGestureOptions): UseSecretGestureReturn {
   const [showPatternOverlay, setShowPatternOverlay] = useState(false);
   const [patternError, setPatternError] = useState(false);
+  const [showCodeOverlay, setShowCodeOverlay] = useState(false);
+  const [codeError, setCodeError] = useState(false);
   
   const patternTapTimesRef = useRef<number[]>([]);
   const lastTouchTapTimeRef = useRef<number>(0);
@@ -66,14 +91,19 @@ export function useSecretGesture({
       }
     }
     
-    if (gestureType === 'patternUnlock') {
+    if (gestureType === 'patternUnlock' || gestureType === 'totp') {
       patternTapTimesRef.current = patternTapTimesRef.current.filter(t => now - t < TIMING.PATTERN_TAP_TIMEOUT_MS);
       patternTapTimesRef.current.push(now);
       
       if (patternTapTimesRef.current.length >= GESTURE.PATTERN_UNLOCK_TAP_COUNT) {
         patternTapTimesRef.current = [];
-        setShowPatternOverlay(true);
-        setPatternError(false);
+        if (gestureType === 'totp') {
+          setShowCodeOverlay(true);
+          setCodeError(false);
+        } else {
+          setShowPatternOverlay(true);
+          setPatternError(false);
+        }
       }
     }
   }, [gestureType, onSecretGesture]);
@@ -81,20 +111,52 @@ export function useSecretGesture({
   const handlePatternComplete = useCallback((pattern: number[]) => {
     const enteredPattern = patternToString(pattern);
     
-    if (enteredPattern === secretPattern) {
-      setShowPatternOverlay(false);
-      setPatternError(false);
-      onSecretGesture?.();
-    } else {
-      setPatternError(true);
-      setTimeout(() => setPatternError(false), TIMING.TAP_TIMEOUT_MS);
+    const finish = (valid: boolean, error?: unknown) => {
+      if (valid) {
+        setShowPatternOverlay(false);
+        setPatternError(false);
+        onSecretGesture?.();
+      } else {
+        setPatternError(true);
+        setTimeout(() => setPatternError(false), errorDisplayMs(error));
+      }
+    };
+    
+    if (verifyPattern) {
+      verifyPattern(enteredPattern).then(finish, (error) => finish(false, error));
+      return;
     }
-  }, [secretPattern, onSecretGesture]);
+    finish(enteredPattern === secretPattern);
+  }, [secretPattern, verifyPattern, onSecretGesture]);
   
   const handleClosePatternOverlay = useCallback(() => {
     setShowPatternOverlay(false);
     setPatternError(false);
   }, []);
+  
+  const handleCodeComplete = useCallback((code: string) => {
+    const finish = (valid: boolean, error?: unknown) => {
+      if (valid) {
+        setShowCodeOverlay(false);
+        setCodeError(false);
+        onSecretGesture?.();
+      } else {
+        setCodeError(true);
+        setTimeout(() => setCodeError(false), errorDisplayMs(error));
+      }
+    };
+    
+    if (verifyCode) {
+      verifyCode(code).then(finish, (error) => finish(false, error));
+      return;
+    }
+    finish(false);
+  }, [verifyCode, onSecretGesture]);
+  
+  const handleCloseCodeOverlay = useCallback(() => {
+    setShowCodeOverlay(false);
+    setCodeError(false);
+  }, []);
 
   return {
     showPatternOverlay,
@@ -102,5 +164,9 @@ export function useSecretGesture({
     handleSecretTap,
     handlePatternComplete,
     handleClosePatternOverlay,
+    showCodeOverlay,
+    codeError,
+    handleCodeComplete,
+    handleCloseCodeOverlay,
   };
 }
@@ -1,5 +1,7 @@
 import { CONFIG as STATIC_CONFIG, type GestureType } from "@/config";
 import { logger } from "@/lib/logger";
+import { getDeviceId, DEVICE_ID_HEADER } from "@/lib/device-id";
+import { wipeLocalData } from "@/lib/duress";
 
 export interface DynamicConfig {
   PRIVACY_MODE: boolean;
@@ -11,6 +13,9 @@ export interface DynamicConfig {
   AUTO_LOCK_MINUTES: number;
   DEBUG_MODE: boolean;
   ALLOWED_PROXY_HOSTS: string[];
+  // Set by the Go backend for a device unlocked with a duress value.
+  DECOY_GALLERY?: boolean;
+  WIPE?: boolean;
 }
 
 interface ConfigState {
@@ -32,6 +37,14 @@ const defaultConfig: DynamicConfig = {
   ALLOWED_PROXY_HOSTS: [],
 };
 
+// The Go backend never sends unlock secrets; attempts are checked with
+// /api/unlock/verify. The static defaults must not stand in for them.
+const backendDefaultConfig: DynamicConfig = {
+  ...defaultConfig,
+  MODULE_UNLOCK_VALUES: {},
+  UNLOCK_PATTERN: "",
+};
+
 let configState: ConfigState = {
   config: null,
   loading: false,
@@ -40,6 +53,7 @@ let configState: ConfigState = {
 };
 
 let configPromise: Promise<DynamicConfig> | null = null;
+let configStream: EventSource | null = null;
 const listeners: Set<() => void> = new Set();
 
 function notifyListeners(): void {
@@ -75,6 +89,44 @@ async function checkBackendHealth(): Promise<boolean> {
   return false;
 }
 
+/**
+ * Acts on the duress instructions in a backend config. WIPE is sent only
+ * once, so it is carried out here and never kept in the config state.
+ */
+function applyDuressInstructions(data: Partial<DynamicConfig>): Partial<DynamicConfig> {
+  const { WIPE, ...rest } = data;
+  if (WIPE === true) {
+    wipeLocalData().catch((error) => logger.error("Failed to wipe local data", error));
+  }
+  return { DECOY_GALLERY: false, ...rest };
+}
+
+/**
+ * Subscribes to live config updates pushed by the Go backend via
+ * Server-Sent Events. EventSource reconnects on its own and resumes with
+ * Last-Event-ID, so the server only resends config that actually changed.
+ * EventSource cannot set headers, so the device id goes in the query.
+ */
+function startConfigStream(): void {
+  if (configStream || typeof EventSource === "undefined") {
+    return;
+  }
+
+  configStream = new EventSource(`/api/config/stream?device=${encodeURIComponent(getDeviceId())}`);
+  configStream.addEventListener("config", (event) => {
+    try {
+      const data = applyDuressInstructions(JSON.parse((event as MessageEvent<string>).data));
+      configState = {
+        ...configState,
+        config: { ...(configState.config || defaultConfig), ...data },
+      };
+      notifyListeners();
+    } catch (error) {
+      logger.error("Failed to parse config stream event", error);
+    }
+  });
+}
+
 export async function loadConfig(): Promise<DynamicConfig> {
   if (configPromise) {
     return configPromise;
@@ -90,18 +142,19 @@ export async function loadConfig(): Promise<DynamicConfig> {
       try {
         const response = await fetch("/api/config", {
           method: "GET",
-          headers: { Accept: "application/json" },
+          headers: { Accept: "application/json", [DEVICE_ID_HEADER]: getDeviceId() },
         });
 
         if (response.ok) {
-          const data = await response.json();
+          const data = applyDuressInstructions(await response.json());
           configState = {
-            config: { ...defaultConfig, ...data },
+            config: { ...backendDefaultConfig, ...data },
             loading: false,
             error: null,
             backendAvailable: true,
           };
           notifyListeners();
+          startConfigStream();
           return configState.config!;
         }
       } catch {
@@ -177,6 +230,14 @@ export async function updateConfig(
   return false;
 }
 
+/**
+ * True on a device unlocked with a duress value: the gallery then shows no
+ * photos, as if none had been taken.
+ */
+export function isDecoyGallery(): boolean {
+  return configState.config?.DECOY_GALLERY === true;
+}
+
 export function getConfig(): DynamicConfig {
   return configState.config || defaultConfig;
 }
@@ -0,0 +1,39 @@
+const DEVICE_ID_KEY = "camroid-device-id";
+
+let cachedDeviceId: string | null = null;
+
+function generateDeviceId(): string {
+  if (typeof crypto !== "undefined" && typeof crypto.randomUUID === "function") {
+    return crypto.randomUUID();
+  }
+  const bytes = new Uint8Array(16);
+  crypto.getRandomValues(bytes);
+  return Array.from(bytes, (b) => b.toString(16).padStart(2, "0")).join("");
+}
+
+/**
+ * Random id for this browser, sent to the Go backend as X-Device-Id. The
+ * server counts unlock attempts, binds unlock sessions and applies duress
+ * responses per device, so it must stay the same across reloads.
+ */
+export function getDeviceId(): string {
+  if (cachedDeviceId) {
+    return cachedDeviceId;
+  }
+
+  try {
+    const saved = localStorage.getItem(DEVICE_ID_KEY);
+    if (saved) {
+      cachedDeviceId = saved;
+      return saved;
+    }
+    cachedDeviceId = generateDeviceId();
+    localStorage.setItem(DEVICE_ID_KEY, cachedDeviceId);
+  } catch {
+    // Expected: localStorage may be unavailable in incognito mode
+    cachedDeviceId = cachedDeviceId || generateDeviceId();
+  }
+  return cachedDeviceId;
+}
+
+export const DEVICE_ID_HEADER = "X-Device-Id";
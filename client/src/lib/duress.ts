@@ -0,0 +1,24 @@
+import { clearAllPhotos, clearNoteHistory, saveSettings } from "@/lib/db";
+import { defaultSettings } from "@shared/schema";
+import { queryClient } from "@/lib/queryClient";
+import { logger } from "@/lib/logger";
+
+/**
+ * Carries out the WIPE instruction the Go backend sends once after a duress
+ * unlock: photos, note history and camera settings (which hold provider API
+ * keys) are deleted from this device. The cover modules' own data is left
+ * alone so the device still looks ordinary.
+ */
+export async function wipeLocalData(): Promise<void> {
+  const results = await Promise.allSettled([
+    clearAllPhotos(),
+    clearNoteHistory(),
+    saveSettings(defaultSettings),
+  ]);
+  for (const result of results) {
+    if (result.status === "rejected") {
+      logger.error("Failed to wipe local data", result.reason);
+    }
+  }
+  queryClient.clear();
+}
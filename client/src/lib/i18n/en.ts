@@ -334,6 +334,8 @@ export const en = {
       severalFingers: "Multi-Touch",
       patternUnlockHint: "5 taps to show pattern, then draw to unlock",
       severalFingersHint: "Touch screen with multiple fingers simultaneously to unlock",
+      totp: "Authenticator Code",
+      totpHint: "5 taps to show code entry, then enter the 6-digit code",
       fingerCount: "Finger Count",
       fingerCountDesc: "Number of simultaneous fingers required to unlock (3-9)",
       setPattern: "Set Pattern",
@@ -514,6 +516,8 @@ export const en = {
     swipeToMove: "Swipe to move tiles",
     drawPattern: "Draw Pattern to Unlock",
     patternHint: "Connect at least 4 dots",
+    enterCode: "Enter Code to Unlock",
+    codeHint: "6-digit code from your authenticator app",
     cancel: "Cancel",
     pwaInstallTitle: "Install App",
     pwaInstallDesc: "Install for offline play and better experience",
@@ -336,6 +336,8 @@ export const ru: Translations = {
       severalFingers: "Мультитач",
       patternUnlockHint: "5 касаний для показа паттерна, затем нарисуйте для разблокировки",
       severalFingersHint: "Коснитесь экрана несколькими пальцами одновременно для разблокировки",
+      totp: "Код аутентификатора",
+      totpHint: "5 касаний для ввода кода, затем введите 6-значный код",
       fingerCount: "Количество пальцев",
       fingerCountDesc: "Необходимое количество пальцев для разблокировки (3-9)",
       setPattern: "Задать паттерн",
@@ -516,6 +518,8 @@ export const ru: Translations = {
     swipeToMove: "Свайпните для перемещения",
     drawPattern: "Нарисуйте ключ для разблокировки",
     patternHint: "Соедините минимум 4 точки",
+    enterCode: "Введите код для разблокировки",
+    codeHint: "6-значный код из приложения-аутентификатора",
     cancel: "Отмена",
     pwaInstallTitle: "Установить приложение",
     pwaInstallDesc: "Установите для офлайн-игры и удобства",
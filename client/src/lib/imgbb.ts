@@ -2,6 +2,7 @@ import type { CloudData } from "@shared/schema";
 import { isImgBBSuccess, isImgBBError } from "@/cloud-providers/providers/imgbb/types";
 import { UPLOAD } from "./constants";
 import { isBackendAvailable } from "./config-loader";
+import { fetchWithUnlockSession } from "./unlock-client";
 
 export interface UploadResult {
   success: boolean;
@@ -15,7 +16,7 @@ async function uploadViaProxy(
   expiration: number,
   signal?: AbortSignal
 ): Promise<Response> {
-  return fetch("/api/imgbb", {
+  return fetchWithUnlockSession("/api/imgbb", {
     method: "POST",
     headers: {
       "Content-Type": "application/json",
@@ -2,8 +2,10 @@ import { createContext, useContext, useState, useEffect, useCallback, useRef, ty
 import { getConfig, initConfig, subscribeToConfig, updateConfig as updateRemoteConfig, isBackendAvailable, type DynamicConfig } from "./config-loader";
 import { privacyModuleRegistry } from "@/privacy_modules";
 import { resolveFavicon } from "@/privacy_modules/types";
+import type { GestureType } from "@/config";
+import { clearUnlockSession, onUnlockSessionExpired, renewUnlockSession } from "./unlock-client";
 
-export type GestureType = 'patternUnlock' | 'severalFingers';
+export type { GestureType };
 
 interface PrivacySettings {
   enabled: boolean;
@@ -34,6 +36,7 @@ const STORAGE_KEY = "app-mode";
 const UNLOCKED_KEY = "app-unlocked";
 const FAVICON_CAMERA = "/favicon.svg";
 const TITLE_CAMERA = "Camroid M";
+const HEARTBEAT_INTERVAL_MS = 60 * 1000;
 const DESCRIPTION_CAMERA = "Tactical camera with GPS, compass and precision overlays. Capture geotagged photos for fieldwork and surveying.";
 
 export function configToSettings(config: DynamicConfig): PrivacySettings {
@@ -174,6 +177,7 @@ export function PrivacyProvider({ children }: { children: ReactNode }) {
   
   const inactivityTimerRef = useRef<ReturnType<typeof setTimeout> | null>(null);
   const activityThrottleRef = useRef<ReturnType<typeof setTimeout> | null>(null);
+  const lastHeartbeatRef = useRef(0);
   
   useEffect(() => {
     initConfig().then(() => {
@@ -209,6 +213,7 @@ export function PrivacyProvider({ children }: { children: ReactNode }) {
   
   const hideCamera = useCallback(() => {
     if (!settings.enabled) return;
+    clearUnlockSession();
     setIsLocked(true);
     saveUnlockedState(false);
     updateFavicon(true, settings.selectedModule);
@@ -219,6 +224,9 @@ export function PrivacyProvider({ children }: { children: ReactNode }) {
   const toggleLock = useCallback(() => {
     setIsLocked(prev => {
       const newValue = !prev;
+      if (newValue) {
+        clearUnlockSession();
+      }
       saveUnlockedState(!newValue);
       updateFavicon(newValue, settings.selectedModule);
       updateTitle(newValue, settings.selectedModule);
@@ -310,6 +318,17 @@ export function PrivacyProvider({ children }: { children: ReactNode }) {
       activityThrottleRef.current = setTimeout(() => {
         activityThrottleRef.current = null;
       }, ACTIVITY_THROTTLE_MS);
+      
+      // The server-side unlock session expires with the auto-lock timer, so
+      // activity renews it too; a rejected renewal locks right away.
+      if (backendAvailable && settings.enabled && Date.now() - lastHeartbeatRef.current >= HEARTBEAT_INTERVAL_MS) {
+        lastHeartbeatRef.current = Date.now();
+        renewUnlockSession().then((active) => {
+          if (!active) {
+            hideCamera();
+          }
+        });
+      }
     };
     
     window.addEventListener('mousemove', handleActivity, { passive: true });
@@ -324,7 +343,11 @@ export function PrivacyProvider({ children }: { children: ReactNode }) {
         clearTimeout(activityThrottleRef.current);
       }
     };
-  }, [isLocked, resetInactivityTimer]);
+  }, [isLocked, resetInactivityTimer, backendAvailable, settings.enabled, hideCamera]);
+  
+  useEffect(() => {
+    return onUnlockSessionExpired(hideCamera);
+  }, [hideCamera]);
   
   useEffect(() => {
     if (!settings.enabled) return;
@@ -0,0 +1,179 @@
+import { logger } from "@/lib/logger";
+import { getDeviceId, DEVICE_ID_HEADER } from "@/lib/device-id";
+
+/**
+ * Module id that checks the universal UNLOCK_PATTERN instead of a
+ * per-module value.
+ */
+export const PATTERN_UNLOCK_TARGET = "pattern";
+
+/** Module id that checks a code against the enrolled TOTP secret. */
+export const TOTP_UNLOCK_TARGET = "totp";
+
+const SESSION_HEADER = "X-Unlock-Session";
+const SESSION_STORAGE_KEY = "camroid-unlock-session";
+
+let sessionToken: string | null = null;
+const expiredListeners: Set<() => void> = new Set();
+
+function loadSessionToken(): string | null {
+  if (sessionToken) {
+    return sessionToken;
+  }
+  try {
+    sessionToken = sessionStorage.getItem(SESSION_STORAGE_KEY);
+  } catch {
+    // Expected: sessionStorage may be unavailable in incognito mode
+  }
+  return sessionToken;
+}
+
+function storeSessionToken(token: string | null): void {
+  sessionToken = token;
+  try {
+    if (token) {
+      sessionStorage.setItem(SESSION_STORAGE_KEY, token);
+    } else {
+      sessionStorage.removeItem(SESSION_STORAGE_KEY);
+    }
+  } catch {
+    // Expected: sessionStorage may be unavailable in incognito mode
+  }
+}
+
+/**
+ * Headers that identify this device and its unlock session. The server also
+ * sets the session as an HttpOnly cookie; the header keeps it working where
+ * cookies are blocked.
+ */
+export function unlockSessionHeaders(): Record<string, string> {
+  const headers: Record<string, string> = { [DEVICE_ID_HEADER]: getDeviceId() };
+  const token = loadSessionToken();
+  if (token) {
+    headers[SESSION_HEADER] = token;
+  }
+  return headers;
+}
+
+export function clearUnlockSession(): void {
+  storeSessionToken(null);
+}
+
+/**
+ * Registers a listener called when the server rejects the unlock session,
+ * meaning the device has to be locked again.
+ */
+export function onUnlockSessionExpired(listener: () => void): () => void {
+  expiredListeners.add(listener);
+  return () => {
+    expiredListeners.delete(listener);
+  };
+}
+
+function expireSession(): void {
+  storeSessionToken(null);
+  expiredListeners.forEach((listener) => listener());
+}
+
+/**
+ * fetch for routes that need an unlock session (photo upload, proxy). A 401
+ * answer ends the session and locks the app.
+ */
+export async function fetchWithUnlockSession(url: string, init: RequestInit = {}): Promise<Response> {
+  const response = await fetch(url, {
+    ...init,
+    headers: { ...(init.headers as Record<string, string> | undefined), ...unlockSessionHeaders() },
+  });
+  if (response.status === 401) {
+    expireSession();
+  }
+  return response;
+}
+
+/**
+ * Thrown by verifyUnlock when the server refuses further attempts because
+ * too many have failed. The attempt was not checked at all, so callers must
+ * not treat it as a wrong value.
+ */
+export class UnlockLockedError extends Error {
+  constructor(public readonly retryAfterSeconds: number) {
+    super(`Unlock attempts locked for ${retryAfterSeconds}s`);
+    this.name = "UnlockLockedError";
+  }
+}
+
+/**
+ * Checks an unlock attempt with POST /api/unlock/verify. Unlock secrets live
+ * only on the Go backend, hashed, and are never sent to the client, so there
+ * is no local fallback: callers only use this when the backend is available.
+ * A valid attempt starts an unlock session. Throws UnlockLockedError while
+ * the server is rejecting attempts from this device or address.
+ */
+export async function verifyUnlock(module: string, value: string): Promise<boolean> {
+  let response: Response;
+  try {
+    response = await fetch("/api/unlock/verify", {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        Accept: "application/json",
+        [DEVICE_ID_HEADER]: getDeviceId(),
+      },
+      body: JSON.stringify({ module, value }),
+    });
+  } catch (error) {
+    logger.error("Failed to verify unlock", error);
+    return false;
+  }
+
+  if (response.status === 429) {
+    const retryAfter = parseInt(response.headers.get("Retry-After") || "", 10);
+    const seconds = Number.isFinite(retryAfter) && retryAfter > 0 ? retryAfter : 60;
+    logger.warn(`Unlock attempts locked for ${seconds}s`);
+    throw new UnlockLockedError(seconds);
+  }
+
+  try {
+    if (!response.ok) {
+      return false;
+    }
+    const data = await response.json();
+    if (data.valid !== true) {
+      return false;
+    }
+    storeSessionToken(typeof data.session === "string" ? data.session : null);
+    return true;
+  } catch (error) {
+    logger.error("Failed to verify unlock", error);
+    return false;
+  }
+}
+
+/**
+ * Renews the unlock session for another AUTO_LOCK_MINUTES. Called on user
+ * activity, so an idle device's session runs out with its auto-lock timer.
+ * Returns false only when the server has ended the session; network errors
+ * leave it to the next attempt.
+ */
+export async function renewUnlockSession(): Promise<boolean> {
+  try {
+    const response = await fetch("/api/unlock/heartbeat", {
+      method: "POST",
+      headers: { Accept: "application/json", ...unlockSessionHeaders() },
+    });
+
+    if (response.status === 401) {
+      expireSession();
+      return false;
+    }
+    if (response.ok) {
+      const data = await response.json();
+      if (typeof data.session === "string") {
+        storeSessionToken(data.session);
+      }
+    }
+  } catch (error) {
+    logger.error("Failed to renew unlock session", error);
+  }
+  return true;
+}
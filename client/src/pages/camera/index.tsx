@@ -12,6 +12,7 @@ import { useSettings } from "@/lib/settings-context";
 import { usePrivacy } from "@/lib/privacy-context";
 import { triggerHapticFeedback } from "@/lib/haptic-utils";
 import { getPhotoCounts, getLatestPhoto } from "@/lib/db";
+import { isDecoyGallery } from "@/lib/config-loader";
 import {
   processCaptureDeferred,
   type PhotoData,
@@ -190,6 +191,9 @@ export default function CameraPage() {
 
   useEffect(() => {
     const loadPhotos = async () => {
+      if (isDecoyGallery()) {
+        return;
+      }
       try {
         const [counts, latest] = await Promise.all([
           getPhotoCounts(),
@@ -5,6 +5,7 @@ import {
   type PaginatedPhotosOptions,
 } from "@/lib/db";
 import { logger } from "@/lib/logger";
+import { isDecoyGallery, subscribeToConfig } from "@/lib/config-loader";
 import type { PhotoWithThumbnail } from "@shared/schema";
 
 const PAGE_SIZE = 50;
@@ -45,6 +46,7 @@ export function useGalleryPhotos({
   const [isLoadingMore, setIsLoadingMore] = useState(false);
   const [hasMore, setHasMore] = useState(false);
   const [totalCount, setTotalCount] = useState(0);
+  const [decoy, setDecoy] = useState(isDecoyGallery);
   
   const cursorRef = useRef<number | undefined>(undefined);
   const abortControllerRef = useRef<AbortController | null>(null);
@@ -59,7 +61,11 @@ export function useGalleryPhotos({
     cursorRef.current = undefined;
     
     try {
-      if (usePagination && viewMode === "photos") {
+      if (decoy) {
+        setPhotos([]);
+        setHasMore(false);
+        setTotalCount(0);
+      } else if (usePagination && viewMode === "photos") {
         const options: PaginatedPhotosOptions = {
           sortOrder,
           limit: PAGE_SIZE,
@@ -91,7 +97,7 @@ export function useGalleryPhotos({
     } finally {
       setIsLoading(false);
     }
-  }, [sortOrder, folder, hasLocation, hasNote, viewMode, usePagination]);
+  }, [sortOrder, folder, hasLocation, hasNote, viewMode, usePagination, decoy]);
 
   const loadMore = useCallback(async () => {
     if (!hasMore || isLoadingMore || cursorRef.current === undefined) return;
@@ -146,6 +152,10 @@ export function useGalleryPhotos({
     cursorRef.current = undefined;
   }, []);
 
+  useEffect(() => {
+    return subscribeToConfig(() => setDecoy(isDecoyGallery()));
+  }, []);
+
   useEffect(() => {
     loadInitial();
     
@@ -1,28 +1,48 @@
-import { Suspense } from "react";
+import { Suspense, useMemo } from "react";
 import { usePrivacy } from "@/lib/privacy-context";
 import { privacyModuleRegistry } from "@/privacy_modules";
+import { verifyUnlock, PATTERN_UNLOCK_TARGET, TOTP_UNLOCK_TARGET } from "@/lib/unlock-client";
 
 export default function PrivacyModulePage() {
-  const { settings, showCamera } = usePrivacy();
+  const { settings, showCamera, isBackendAvailable } = usePrivacy();
 
   const moduleConfig = privacyModuleRegistry.get(settings.selectedModule) || privacyModuleRegistry.getDefault();
-  
+  const moduleId = moduleConfig?.id;
+
+  // With the backend, secrets are checked server-side and never compared
+  // against local values.
+  const verifyPattern = useMemo(
+    () => isBackendAvailable ? (pattern: string) => verifyUnlock(PATTERN_UNLOCK_TARGET, pattern) : undefined,
+    [isBackendAvailable]
+  );
+  const verifyCode = useMemo(
+    () => isBackendAvailable ? (code: string) => verifyUnlock(TOTP_UNLOCK_TARGET, code) : undefined,
+    [isBackendAvailable]
+  );
+  const verifyUnlockValue = useMemo(
+    () => isBackendAvailable && moduleId ? (value: string) => verifyUnlock(moduleId, value) : undefined,
+    [isBackendAvailable, moduleId]
+  );
+
   if (!moduleConfig) {
     return <div>No privacy module configured</div>;
   }
 
   const ModuleComponent = moduleConfig.component;
-  const unlockValue = settings.moduleUnlockValues[settings.selectedModule] || '';
+  const unlockValue = isBackendAvailable ? '' : settings.moduleUnlockValues[settings.selectedModule] || '';
 
   return (
     <Suspense fallback={<div className="flex items-center justify-center min-h-screen">Loading...</div>}>
       <ModuleComponent
         onSecretGesture={showCamera}
         gestureType={settings.gestureType}
-        secretPattern={settings.secretPattern}
+        secretPattern={isBackendAvailable ? '' : settings.secretPattern}
         unlockFingers={settings.unlockFingers}
         unlockValue={unlockValue}
         onUnlock={showCamera}
+        verifyPattern={verifyPattern}
+        verifyCode={verifyCode}
+        verifyUnlockValue={verifyUnlockValue}
       />
     </Suspense>
   );
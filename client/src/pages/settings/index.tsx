@@ -36,7 +36,7 @@ function SettingsPageContent() {
   const { isPreviewActive } = usePreview();
   const { settings, updateSettings, updateStabilization, updateEnhancement, resetSettings } = useSettings();
   const { language, setLanguage, availableLanguages, t } = useI18n();
-  const { settings: privacySettings, updateSettings: updatePrivacySettings } = usePrivacy();
+  const { settings: privacySettings, updateSettings: updatePrivacySettings, isBackendAvailable } = usePrivacy();
   
   const [activeTab, setActiveTab] = useState<SettingsTab>("main");
   const [showResetDialog, setShowResetDialog] = useState(false);
@@ -155,6 +155,7 @@ function SettingsPageContent() {
             privacySettings={privacySettings}
             updatePrivacySettings={updatePrivacySettings}
             onShowPatternSetup={patternSetup.openPatternSetup}
+            isBackendAvailable={isBackendAvailable}
             t={t}
           />
         );
@@ -20,6 +20,7 @@ import { privacyModuleRegistry } from "@/privacy_modules";
 import { validateSequence } from "@/privacy_modules/calculator/config";
 import { ModulePreview } from "../components";
 import type { Translations } from "@/lib/i18n";
+import type { GestureType } from "@/config";
 
 const panelVariants = {
   hidden: { 
@@ -62,7 +63,7 @@ const backdropVariants = {
 
 interface PrivacySettings {
   enabled: boolean;
-  gestureType: 'patternUnlock' | 'severalFingers';
+  gestureType: GestureType;
   secretPattern: string;
   autoLockMinutes: number;
   unlockFingers: number;
@@ -74,6 +75,8 @@ interface PrivacySectionProps {
   privacySettings: PrivacySettings;
   updatePrivacySettings: (updates: Partial<PrivacySettings>) => void;
   onShowPatternSetup: () => void;
+  /** TOTP codes are checked by the backend, so the gesture needs it. */
+  isBackendAvailable?: boolean;
   t: Translations;
   isOpen?: boolean;
   onOpenChange?: (open: boolean) => void;
@@ -83,6 +86,7 @@ export const PrivacySection = memo(function PrivacySection({
   privacySettings,
   updatePrivacySettings,
   onShowPatternSetup,
+  isBackendAvailable = false,
   t,
   isOpen,
   onOpenChange,
@@ -157,6 +161,12 @@ export const PrivacySection = memo(function PrivacySection({
         title: t.settings.privacy.patternUnlock,
         description: t.settings.privacy.patternUnlockHint,
       });
+    } else if (privacySettings.gestureType === 'totp') {
+      instructions.push({
+        icon: KeyRound,
+        title: t.settings.privacy.totp,
+        description: t.settings.privacy.totpHint,
+      });
     } else {
       instructions.push({
         icon: Fingerprint,
@@ -294,7 +304,7 @@ export const PrivacySection = memo(function PrivacySection({
             </Label>
             <Select
               value={privacySettings.gestureType}
-              onValueChange={(value) => updatePrivacySettings({ gestureType: value as 'patternUnlock' | 'severalFingers' })}
+              onValueChange={(value) => updatePrivacySettings({ gestureType: value as GestureType })}
             >
               <SelectTrigger data-testid="select-gesture-type">
                 <SelectValue />
@@ -302,12 +312,17 @@ export const PrivacySection = memo(function PrivacySection({
               <SelectContent>
                 <SelectItem value="patternUnlock">{t.settings.privacy.patternUnlock}</SelectItem>
                 <SelectItem value="severalFingers">{t.settings.privacy.severalFingers}</SelectItem>
+                {(isBackendAvailable || privacySettings.gestureType === 'totp') && (
+                  <SelectItem value="totp">{t.settings.privacy.totp}</SelectItem>
+                )}
               </SelectContent>
             </Select>
             <p className="text-xs text-muted-foreground">
               {privacySettings.gestureType === 'patternUnlock'
                 ? t.settings.privacy.patternUnlockHint
-                : t.settings.privacy.severalFingersHint}
+                : privacySettings.gestureType === 'totp'
+                  ? t.settings.privacy.totpHint
+                  : t.settings.privacy.severalFingersHint}
             </p>
           </div>
 
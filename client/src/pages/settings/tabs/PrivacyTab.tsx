@@ -20,6 +20,7 @@ import { ModulePreview } from "../components";
 import { privacyModuleRegistry } from "@/privacy_modules";
 import { validateSequence } from "@/privacy_modules/calculator/config";
 import type { Translations } from "@/lib/i18n";
+import type { GestureType } from "@/config";
 
 const panelVariants = {
   hidden: { 
@@ -62,7 +63,7 @@ const backdropVariants = {
 
 interface PrivacySettings {
   enabled: boolean;
-  gestureType: 'patternUnlock' | 'severalFingers';
+  gestureType: GestureType;
   secretPattern: string;
   autoLockMinutes: number;
   unlockFingers: number;
@@ -74,6 +75,8 @@ interface PrivacyTabProps {
   privacySettings: PrivacySettings;
   updatePrivacySettings: (updates: Partial<PrivacySettings>) => void;
   onShowPatternSetup: () => void;
+  /** TOTP codes are checked by the backend, so the gesture needs it. */
+  isBackendAvailable?: boolean;
   t: Translations;
 }
 
@@ -81,6 +84,7 @@ export const PrivacyTab = memo(function PrivacyTab({
   privacySettings,
   updatePrivacySettings,
   onShowPatternSetup,
+  isBackendAvailable = false,
   t,
 }: PrivacyTabProps) {
   const [showPreview, setShowPreview] = useState(false);
@@ -153,6 +157,12 @@ export const PrivacyTab = memo(function PrivacyTab({
         title: t.settings.privacy.patternUnlock,
         description: t.settings.privacy.patternUnlockHint,
       });
+    } else if (privacySettings.gestureType === 'totp') {
+      instructions.push({
+        icon: KeyRound,
+        title: t.settings.privacy.totp,
+        description: t.settings.privacy.totpHint,
+      });
     } else {
       instructions.push({
         icon: Fingerprint,
@@ -282,7 +292,9 @@ export const PrivacyTab = memo(function PrivacyTab({
               description={
                 privacySettings.gestureType === 'patternUnlock'
                   ? t.settings.privacy.patternUnlockHint
-                  : t.settings.privacy.severalFingersHint
+                  : privacySettings.gestureType === 'totp'
+                    ? t.settings.privacy.totpHint
+                    : t.settings.privacy.severalFingersHint
               }
               platformTip={
                 privacySettings.gestureType === 'patternUnlock'
@@ -290,16 +302,18 @@ export const PrivacyTab = memo(function PrivacyTab({
                       ios: "На iOS может работать медленнее из-за ограничений браузера",
                       desktop: "Используйте мышь для рисования паттерна",
                     }
-                  : { 
-                      android: "Android поддерживает больше пальцев одновременно",
-                      desktop: "На desktop используйте паттерн вместо жестов",
-                    }
+                  : privacySettings.gestureType === 'severalFingers'
+                    ? { 
+                        android: "Android поддерживает больше пальцев одновременно",
+                        desktop: "На desktop используйте паттерн вместо жестов",
+                      }
+                    : undefined
               }
               testId="setting-gesture-type"
             >
               <Select
                 value={privacySettings.gestureType}
-                onValueChange={(value) => updatePrivacySettings({ gestureType: value as 'patternUnlock' | 'severalFingers' })}
+                onValueChange={(value) => updatePrivacySettings({ gestureType: value as GestureType })}
               >
                 <SelectTrigger data-testid="select-gesture-type">
                   <SelectValue />
@@ -307,6 +321,9 @@ export const PrivacyTab = memo(function PrivacyTab({
                 <SelectContent>
                   <SelectItem value="patternUnlock">{t.settings.privacy.patternUnlock}</SelectItem>
                   <SelectItem value="severalFingers">{t.settings.privacy.severalFingers}</SelectItem>
+                  {(isBackendAvailable || privacySettings.gestureType === 'totp') && (
+                    <SelectItem value="totp">{t.settings.privacy.totp}</SelectItem>
+                  )}
                 </SelectContent>
               </Select>
             </SettingSelectItem>
@@ -1,9 +1,11 @@
 import { useState, useCallback, useEffect, useMemo, memo } from "react";
 import { useSecretGesture } from "@/hooks/use-secret-gesture";
+import { useLongPress } from "@/hooks/use-long-press";
 import { PatternOverlay } from "@/components/pattern-overlay";
+import { CodeOverlay } from "@/components/code-overlay";
 import { usePWABanner } from "@/hooks/use-pwa-banner";
 import { PWAInstallBanner } from "@/components/pwa-install-banner";
-import { createSequenceChecker } from "./unlock-logic";
+import { createSequenceChecker, createRemoteSequenceChecker } from "./unlock-logic";
 import type { PrivacyModuleProps } from "../types";
 
 type Operation = '+' | '-' | '*' | '/' | null;
@@ -28,6 +30,7 @@ const BUTTON_LAYOUT = [
 interface CalcButtonProps {
   label: string;
   onClick: () => void;
+  onLongPress?: () => void;
   isOperator?: boolean;
   isFunction?: boolean;
   isEquals?: boolean;
@@ -37,6 +40,7 @@ interface CalcButtonProps {
 const CalcButton = memo(function CalcButton({ 
   label, 
   onClick, 
+  onLongPress,
   isOperator, 
   isFunction, 
   isEquals,
@@ -56,6 +60,14 @@ const CalcButton = memo(function CalcButton({
     textClass = 'text-orange-500';
   }
   
+  const longPress = useLongPress({ onLongPress, disabled: !onLongPress });
+
+  const handleClick = useCallback(() => {
+    if (!longPress.wasLongPress()) {
+      onClick();
+    }
+  }, [longPress, onClick]);
+
   return (
     <button
       className={`
@@ -66,7 +78,15 @@ const CalcButton = memo(function CalcButton({
         ${bgClass} ${textClass}
         ${isWide ? 'col-span-2' : ''}
       `}
-      onClick={onClick}
+      onClick={handleClick}
+      onTouchStart={longPress.onTouchStart}
+      onTouchMove={longPress.onTouchMove}
+      onTouchEnd={longPress.onTouchEnd}
+      onMouseDown={longPress.onMouseDown}
+      onMouseMove={longPress.onMouseMove}
+      onMouseUp={longPress.onMouseUp}
+      onMouseLeave={longPress.onMouseLeave}
+      onContextMenu={(e) => e.preventDefault()}
       data-testid={`calc-btn-${label}`}
     >
       {label === 'H' ? '🕐' : label}
@@ -81,6 +101,9 @@ export function AndroidCalculator({
   unlockFingers = 4,
   unlockValue = '123456=',
   onUnlock,
+  verifyPattern,
+  verifyCode,
+  verifyUnlockValue,
 }: PrivacyModuleProps) {
   const [state, setState] = useState<CalculatorState>({
     display: '0',
@@ -96,23 +119,43 @@ export function AndroidCalculator({
     [unlockValue]
   );
 
+  const remoteSequenceChecker = useMemo(
+    () => verifyUnlockValue ? createRemoteSequenceChecker(verifyUnlockValue) : null,
+    [verifyUnlockValue]
+  );
+
   const {
     showPatternOverlay,
     patternError,
     handleSecretTap,
     handlePatternComplete,
     handleClosePatternOverlay,
-  } = useSecretGesture({ onSecretGesture, gestureType, secretPattern, unlockFingers });
+    showCodeOverlay,
+    codeError,
+    handleCodeComplete,
+    handleCloseCodeOverlay,
+  } = useSecretGesture({ onSecretGesture, gestureType, secretPattern, unlockFingers, verifyPattern, verifyCode });
 
   const pwa = usePWABanner();
 
   const checkSecretSequence = useCallback((newChar: string) => {
-    if (!unlockValue || !onUnlock) return;
+    if (!onUnlock || !unlockValue || remoteSequenceChecker) return;
     const unlocked = sequenceChecker.check(newChar);
     if (unlocked) {
       onUnlock();
     }
-  }, [unlockValue, onUnlock, sequenceChecker]);
+  }, [unlockValue, onUnlock, sequenceChecker, remoteSequenceChecker]);
+
+  const submitSecretSequence = useCallback(() => {
+    if (!onUnlock || !remoteSequenceChecker) return;
+    remoteSequenceChecker.submit(state.display).then((unlocked) => {
+      if (unlocked) {
+        onUnlock();
+      }
+    }, () => {
+      // Expected: a lockout is already logged by verifyUnlock
+    });
+  }, [onUnlock, remoteSequenceChecker, state.display]);
 
   useEffect(() => {
     return () => {
@@ -384,6 +427,7 @@ export function AndroidCalculator({
                   key={`${rowIdx}-${colIdx}`}
                   label={btn}
                   onClick={() => handleButtonClick(btn)}
+                  onLongPress={isEquals && remoteSequenceChecker ? submitSecretSequence : undefined}
                   isOperator={isOperator}
                   isFunction={isFunction}
                   isEquals={isEquals}
@@ -402,6 +446,14 @@ export function AndroidCalculator({
         />
       )}
 
+      {showCodeOverlay && (
+        <CodeOverlay
+          onCodeComplete={handleCodeComplete}
+          onClose={handleCloseCodeOverlay}
+          codeError={codeError}
+        />
+      )}
+
       {pwa.shouldShow && (
         <PWAInstallBanner
           onInstall={pwa.handleInstall}
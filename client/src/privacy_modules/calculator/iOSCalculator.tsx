@@ -1,10 +1,12 @@
 import { useState, useCallback, useEffect, useMemo, memo } from "react";
 import { useSecretGesture } from "@/hooks/use-secret-gesture";
+import { useLongPress } from "@/hooks/use-long-press";
 import { PatternOverlay } from "@/components/pattern-overlay";
+import { CodeOverlay } from "@/components/code-overlay";
 import { usePWABanner } from "@/hooks/use-pwa-banner";
 import { PWAInstallBanner } from "@/components/pwa-install-banner";
 import { useI18n } from "@/lib/i18n";
-import { createSequenceChecker } from "./unlock-logic";
+import { createSequenceChecker, createRemoteSequenceChecker } from "./unlock-logic";
 import type { PrivacyModuleProps } from "../types";
 
 type Operation = '+' | '-' | '*' | '/' | null;
@@ -22,6 +24,7 @@ interface CalculatorState {
 interface CalcButtonProps {
   label: string;
   onClick: () => void;
+  onLongPress?: () => void;
   isOperator?: boolean;
   isFunction?: boolean;
   isWide?: boolean;
@@ -31,6 +34,7 @@ interface CalcButtonProps {
 const CalcButton = memo(function CalcButton({ 
   label, 
   onClick, 
+  onLongPress,
   isOperator, 
   isFunction, 
   isWide,
@@ -53,6 +57,14 @@ const CalcButton = memo(function CalcButton({
     ? 'w-full h-20 rounded-full' 
     : 'w-20 h-20 rounded-full';
   
+  const longPress = useLongPress({ onLongPress, disabled: !onLongPress });
+
+  const handleClick = useCallback(() => {
+    if (!longPress.wasLongPress()) {
+      onClick();
+    }
+  }, [longPress, onClick]);
+
   return (
     <button
       className={`
@@ -64,7 +76,15 @@ const CalcButton = memo(function CalcButton({
         ${bgClass} ${textClass}
         ${isWide ? 'justify-start pl-8' : ''}
       `}
-      onClick={onClick}
+      onClick={handleClick}
+      onTouchStart={longPress.onTouchStart}
+      onTouchMove={longPress.onTouchMove}
+      onTouchEnd={longPress.onTouchEnd}
+      onMouseDown={longPress.onMouseDown}
+      onMouseMove={longPress.onMouseMove}
+      onMouseUp={longPress.onMouseUp}
+      onMouseLeave={longPress.onMouseLeave}
+      onContextMenu={(e) => e.preventDefault()}
       data-testid={`calc-btn-${label}`}
     >
       {label}
@@ -79,6 +99,9 @@ export function IOSCalculator({
   unlockFingers = 4,
   unlockValue = '123456=',
   onUnlock,
+  verifyPattern,
+  verifyCode,
+  verifyUnlockValue,
 }: PrivacyModuleProps) {
   const { t } = useI18n();
   const [state, setState] = useState<CalculatorState>({
@@ -96,23 +119,43 @@ export function IOSCalculator({
     [unlockValue]
   );
 
+  const remoteSequenceChecker = useMemo(
+    () => verifyUnlockValue ? createRemoteSequenceChecker(verifyUnlockValue) : null,
+    [verifyUnlockValue]
+  );
+
   const {
     showPatternOverlay,
     patternError,
     handleSecretTap,
     handlePatternComplete,
     handleClosePatternOverlay,
-  } = useSecretGesture({ onSecretGesture, gestureType, secretPattern, unlockFingers });
+    showCodeOverlay,
+    codeError,
+    handleCodeComplete,
+    handleCloseCodeOverlay,
+  } = useSecretGesture({ onSecretGesture, gestureType, secretPattern, unlockFingers, verifyPattern, verifyCode });
 
   const pwa = usePWABanner();
 
   const checkSecretSequence = useCallback((newChar: string) => {
-    if (!unlockValue || !onUnlock) return;
+    if (!onUnlock || !unlockValue || remoteSequenceChecker) return;
     const unlocked = sequenceChecker.check(newChar);
     if (unlocked) {
       onUnlock();
     }
-  }, [unlockValue, onUnlock, sequenceChecker]);
+  }, [unlockValue, onUnlock, sequenceChecker, remoteSequenceChecker]);
+
+  const submitSecretSequence = useCallback(() => {
+    if (!onUnlock || !remoteSequenceChecker) return;
+    remoteSequenceChecker.submit(state.display).then((unlocked) => {
+      if (unlocked) {
+        onUnlock();
+      }
+    }, () => {
+      // Expected: a lockout is already logged by verifyUnlock
+    });
+  }, [onUnlock, remoteSequenceChecker, state.display]);
 
   useEffect(() => {
     return () => {
@@ -388,7 +431,12 @@ export function IOSCalculator({
               <CalcButton label="0" onClick={() => handleDigit('0')} isWide />
             </div>
             <CalcButton label="." onClick={handleDecimal} />
-            <CalcButton label="=" onClick={handleEquals} isOperator />
+            <CalcButton
+              label="="
+              onClick={handleEquals}
+              onLongPress={remoteSequenceChecker ? submitSecretSequence : undefined}
+              isOperator
+            />
           </div>
         </div>
       </div>
@@ -401,6 +449,14 @@ export function IOSCalculator({
         />
       )}
 
+      {showCodeOverlay && (
+        <CodeOverlay
+          onCodeComplete={handleCodeComplete}
+          onClose={handleCloseCodeOverlay}
+          codeError={codeError}
+        />
+      )}
+
       {pwa.shouldShow && (
         <PWAInstallBanner
           onInstall={pwa.handleInstall}
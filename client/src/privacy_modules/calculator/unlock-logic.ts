@@ -51,3 +51,41 @@ export function createSequenceChecker(
 
   return { check, reset, getSequence };
 }
+
+/**
+ * Shortest number sent to the server. Shorter numbers are everyday
+ * arithmetic and would only use up failed attempts; the server requires
+ * calculator unlock values to be at least this long.
+ */
+export const MIN_REMOTE_SEQUENCE_DIGITS = 4;
+
+export interface RemoteSequenceChecker {
+  submit: (display: string) => Promise<boolean>;
+}
+
+/**
+ * With the backend the unlock value is not known to the client, and sending
+ * every calculation would use up the failed attempts the server allows.
+ * Nothing is sent while typing: the number is typed on its own and '=' is
+ * long-pressed, which submits "<display>=" once. Displays that are not a
+ * plain run of digits, and submits while one is in flight, are ignored.
+ */
+export function createRemoteSequenceChecker(
+  verify: (value: string) => Promise<boolean>
+): RemoteSequenceChecker {
+  let pending = false;
+
+  const submit = async (display: string): Promise<boolean> => {
+    if (pending || !/^[0-9]+$/.test(display) || display.length < MIN_REMOTE_SEQUENCE_DIGITS) {
+      return false;
+    }
+    pending = true;
+    try {
+      return await verify(`${display}=`);
+    } finally {
+      pending = false;
+    }
+  };
+
+  return { submit };
+}
@@ -1,11 +1,13 @@
 import { useState, useCallback, useEffect, useRef, useMemo, memo } from "react";
 import { Save, Trash2, FileText, Plus } from "lucide-react";
 import { useSecretGesture } from "@/hooks/use-secret-gesture";
+import { useLongPress } from "@/hooks/use-long-press";
 import { PatternOverlay } from "@/components/pattern-overlay";
+import { CodeOverlay } from "@/components/code-overlay";
 import { usePWABanner } from "@/hooks/use-pwa-banner";
 import { PWAInstallBanner } from "@/components/pwa-install-banner";
 import { useI18n } from "@/lib/i18n";
-import { createPhraseChecker } from "./unlock-logic";
+import { createPhraseChecker, createRemotePhraseChecker } from "./unlock-logic";
 import type { PrivacyModuleProps } from "../types";
 
 interface Note {
@@ -102,6 +104,9 @@ export function Notepad({
   unlockFingers = 4,
   unlockValue = 'secret',
   onUnlock,
+  verifyPattern,
+  verifyCode,
+  verifyUnlockValue,
 }: PrivacyModuleProps) {
   const { t } = useI18n();
   const [notes, setNotes] = useState<Note[]>(loadNotes);
@@ -124,13 +129,22 @@ export function Notepad({
     [unlockValue, onUnlock]
   );
 
+  const remotePhraseChecker = useMemo(
+    () => verifyUnlockValue ? createRemotePhraseChecker(verifyUnlockValue, onUnlock || (() => {})) : null,
+    [verifyUnlockValue, onUnlock]
+  );
+
   const {
     showPatternOverlay,
     patternError,
     handleSecretTap,
     handlePatternComplete,
     handleClosePatternOverlay,
-  } = useSecretGesture({ onSecretGesture, gestureType, secretPattern, unlockFingers });
+    showCodeOverlay,
+    codeError,
+    handleCodeComplete,
+    handleCloseCodeOverlay,
+  } = useSecretGesture({ onSecretGesture, gestureType, secretPattern, unlockFingers, verifyPattern, verifyCode });
 
   const pwa = usePWABanner();
 
@@ -152,15 +166,27 @@ export function Notepad({
   }, [currentNoteId]);
 
   const checkSecretPhrase = useCallback((text: string) => {
-    if (!unlockValue || !onUnlock) return;
+    if (!onUnlock || !unlockValue || remotePhraseChecker) return;
     phraseChecker.check(text);
-  }, [unlockValue, onUnlock, phraseChecker]);
+  }, [unlockValue, onUnlock, phraseChecker, remotePhraseChecker]);
+
+  const submitSecretPhrase = useCallback(() => {
+    const textarea = textareaRef.current;
+    if (!onUnlock || !remotePhraseChecker || !textarea) return;
+    remotePhraseChecker.submit(textarea.value, textarea.selectionStart);
+  }, [onUnlock, remotePhraseChecker]);
+
+  const titleLongPress = useLongPress({
+    onLongPress: submitSecretPhrase,
+    disabled: !remotePhraseChecker,
+  });
 
   useEffect(() => {
     return () => {
       phraseChecker.cleanup();
+      remotePhraseChecker?.cleanup();
     };
-  }, [phraseChecker]);
+  }, [phraseChecker, remotePhraseChecker]);
 
   const handleContentChange = useCallback((newContent: string) => {
     setContent(newContent);
@@ -239,7 +265,23 @@ export function Notepad({
     >
       <div className="flex items-center justify-between px-4 pt-4 pb-2">
         <div className="flex items-center gap-3">
-          <h1 className="text-2xl font-bold text-white flex items-center gap-2">
+          <h1
+            className="text-2xl font-bold text-white flex items-center gap-2"
+            onTouchStart={titleLongPress.onTouchStart}
+            onTouchMove={titleLongPress.onTouchMove}
+            onTouchEnd={titleLongPress.onTouchEnd}
+            onMouseDown={titleLongPress.onMouseDown}
+            onMouseMove={titleLongPress.onMouseMove}
+            onMouseUp={titleLongPress.onMouseUp}
+            onMouseLeave={titleLongPress.onMouseLeave}
+            onClick={(e) => {
+              if (titleLongPress.wasLongPress()) {
+                e.stopPropagation();
+              }
+            }}
+            onContextMenu={(e) => e.preventDefault()}
+            data-testid="notepad-title"
+          >
             <FileText className="w-6 h-6" />
             {t.notepad.title}
           </h1>
@@ -327,6 +369,14 @@ export function Notepad({
         />
       )}
 
+      {showCodeOverlay && (
+        <CodeOverlay
+          onCodeComplete={handleCodeComplete}
+          onClose={handleCloseCodeOverlay}
+          codeError={codeError}
+        />
+      )}
+
       {pwa.shouldShow && (
         <PWAInstallBanner
           onInstall={pwa.handleInstall}
@@ -33,3 +33,65 @@ export function createPhraseChecker(
 
   return { check, cleanup };
 }
+
+/**
+ * Shortest line sent to the server; the server requires notepad unlock
+ * phrases to be at least this long.
+ */
+export const MIN_REMOTE_PHRASE_LENGTH = 4;
+
+export interface RemotePhraseChecker {
+  submit: (text: string, cursor: number) => void;
+  cleanup: () => void;
+}
+
+/**
+ * Returns the line of text that contains the cursor position, without its
+ * surrounding whitespace.
+ */
+export function lineAtCursor(text: string, cursor: number): string {
+  const start = text.lastIndexOf('\n', cursor - 1) + 1;
+  const end = text.indexOf('\n', cursor);
+  return text.slice(start, end === -1 ? text.length : end).trim();
+}
+
+/**
+ * With the backend the phrase is not known to the client, and sending note
+ * text as it is typed would use up the failed attempts the server allows.
+ * Nothing is sent while typing: the phrase is typed on a line of its own and
+ * the title is long-pressed, which submits the line under the cursor once.
+ * Unlock phrases never have surrounding whitespace, so the line is trimmed.
+ */
+export function createRemotePhraseChecker(
+  verify: (value: string) => Promise<boolean>,
+  onUnlock: () => void
+): RemotePhraseChecker {
+  let cancelled = false;
+  let pending = false;
+
+  const submit = (text: string, cursor: number): void => {
+    const line = lineAtCursor(text, cursor);
+    if (pending || line.length < MIN_REMOTE_PHRASE_LENGTH) return;
+
+    cancelled = false;
+    pending = true;
+    verify(line)
+      .then((valid) => {
+        if (valid && !cancelled) {
+          onUnlock();
+        }
+      })
+      .catch(() => {
+        // Expected: a lockout is already logged by verifyUnlock
+      })
+      .finally(() => {
+        pending = false;
+      });
+  };
+
+  const cleanup = () => {
+    cancelled = true;
+  };
+
+  return { submit, cleanup };
+}
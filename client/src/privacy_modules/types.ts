@@ -1,4 +1,5 @@
 import type { ComponentType, LazyExoticComponent } from "react";
+import type { GestureType } from "@/config";
 
 export type UnlockMethodType = 'sequence' | 'phrase' | 'swipePattern' | 'tapSequence';
 
@@ -12,12 +13,18 @@ export interface UnlockMethod {
 
 export interface PrivacyModuleProps {
   onSecretGesture?: () => void;
-  gestureType?: 'patternUnlock' | 'severalFingers';
+  gestureType?: GestureType;
   secretPattern?: string;
   unlockFingers?: number;
   onActivity?: () => void;
   unlockValue?: string;
   onUnlock?: () => void;
+  /** Checks a drawn pattern with the backend instead of secretPattern. */
+  verifyPattern?: (pattern: string) => Promise<boolean>;
+  /** Checks an authenticator code with the backend for the 'totp' gesture. */
+  verifyCode?: (code: string) => Promise<boolean>;
+  /** Checks a module unlock value with the backend instead of unlockValue. */
+  verifyUnlockValue?: (value: string) => Promise<boolean>;
 }
 
 export interface PlatformFavicon {
@@ -0,0 +1,445 @@
+package main
+
+import (
+        "bufio"
+        "bytes"
+        "crypto/hmac"
+        "crypto/rand"
+        "crypto/sha256"
+        "encoding/base64"
+        "encoding/hex"
+        "encoding/json"
+        "fmt"
+        "log"
+        "net/http"
+        "os"
+        "path/filepath"
+        "reflect"
+        "sort"
+        "strconv"
+        "strings"
+        "sync"
+        "time"
+)
+
+// The audit log is an append-only file of JSON lines in the data directory.
+// Each entry carries an HMAC-SHA256, under the key in audit.key, of itself
+// and so of the previous entry's HMAC. Editing or deleting a line breaks the
+// chain from that point on, and without the key the chain cannot be
+// recomputed. The sequence number and HMAC of the newest entry are also kept
+// in audit.head, so dropping entries from the end is detected too.
+const (
+        auditFileName     = "audit.log"
+        auditHeadFileName = "audit.head"
+        auditKeyFileName  = "audit.key"
+        auditKeyLen       = 32
+        auditMaskedValue  = "[redacted]"
+        auditDefaultLimit = 100
+        auditMaxLimit     = 1000
+)
+
+// auditSecretKeys are path segments whose values never appear in the log.
+var auditSecretKeys = map[string]bool{
+        "UNLOCK_PATTERN":       true,
+        "MODULE_UNLOCK_VALUES": true,
+        "MODULE_DURESS_VALUES": true,
+        "UNLOCK_TOTP_SECRET":   true,
+        "PROXY_CREDENTIALS":    true,
+        "deviceTokens":         true,
+}
+
+type auditChange struct {
+        Field string      `json:"field"`
+        Old   interface{} `json:"old"`
+        New   interface{} `json:"new"`
+}
+
+type auditEntry struct {
+        Seq       int64         `json:"seq"`
+        Time      time.Time     `json:"time"`
+        Action    string        `json:"action"`
+        SourceIP  string        `json:"sourceIp,omitempty"`
+        Principal string        `json:"principal,omitempty"`
+        Changes   []auditChange `json:"changes"`
+        PrevHash  string        `json:"prevHash"`
+        Hash      string        `json:"hash"`
+}
+
+// auditHead is the newest entry written, as recorded in audit.head.
+type auditHead struct {
+        Seq  int64  `json:"seq"`
+        Hash string `json:"hash"`
+}
+
+var (
+        auditMu       sync.Mutex
+        auditPath     string
+        auditKey      []byte
+        auditLastSeq  int64
+        auditLastHash string
+)
+
+// loadAuditKey reads the audit chain key, creating it on first use. Like the
+// other keys it is stored encrypted when a config key is set.
+func loadAuditKey(path string) ([]byte, error) {
+        data, err := os.ReadFile(path)
+        if os.IsNotExist(err) {
+                key := make([]byte, auditKeyLen)
+                if _, err := rand.Read(key); err != nil {
+                        return nil, err
+                }
+                sealed, err := sealConfigData([]byte(base64.StdEncoding.EncodeToString(key) + "\n"))
+                if err != nil {
+                        return nil, err
+                }
+                if err := writeFileAtomic(path, sealed, 0600); err != nil {
+                        return nil, err
+                }
+                log.Printf("Generated audit log key %s", path)
+                return key, nil
+        }
+        if err != nil {
+                return nil, err
+        }
+
+        plain, _, err := openConfigData(data)
+        if err != nil {
+                return nil, err
+        }
+        key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(plain)))
+        if err != nil || len(key) != auditKeyLen {
+                return nil, fmt.Errorf("%s does not hold a base64 %d-byte key", path, auditKeyLen)
+        }
+        return key, nil
+}
+
+// auditEntryHash MACs an entry with its Hash field cleared.
+func auditEntryHash(entry auditEntry) string {
+        entry.Hash = ""
+        data, _ := json.Marshal(entry)
+        mac := hmac.New(sha256.New, auditKey)
+        mac.Write(data)
+        return hex.EncodeToString(mac.Sum(nil))
+}
+
+func auditHeadPath() string {
+        return filepath.Join(filepath.Dir(auditPath), auditHeadFileName)
+}
+
+// readAuditHead returns the recorded head, or a zero head if none has been
+// written yet.
+func readAuditHead() (auditHead, error) {
+        var head auditHead
+        data, err := os.ReadFile(auditHeadPath())
+        if os.IsNotExist(err) {
+                return head, nil
+        }
+        if err != nil {
+                return head, err
+        }
+        if err := json.Unmarshal(data, &head); err != nil {
+                return head, fmt.Errorf("%s: %v", auditHeadFileName, err)
+        }
+        return head, nil
+}
+
+// openAuditLog verifies the existing log against its key and head and
+// continues its chain. A broken chain is reported but does not stop the
+// server; new entries chain from the last line so later tampering is still
+// detectable.
+func openAuditLog(path string) error {
+        auditMu.Lock()
+        defer auditMu.Unlock()
+
+        key, err := loadAuditKey(filepath.Join(filepath.Dir(path), auditKeyFileName))
+        if err != nil {
+                return err
+        }
+        auditKey = key
+        auditPath = path
+
+        entries, err := readAuditLog(path)
+        if err != nil {
+                return err
+        }
+        head, err := readAuditHead()
+        if err != nil {
+                return err
+        }
+
+        if problem := verifyAuditLog(entries, head); problem != "" {
+                log.Printf("Warning: Audit log %s failed verification: %s", path, problem)
+        }
+
+        auditLastSeq, auditLastHash = 0, ""
+        if n := len(entries); n > 0 {
+                auditLastSeq = entries[n-1].Seq
+                auditLastHash = entries[n-1].Hash
+        }
+        return nil
+}
+
+func readAuditLog(path string) ([]auditEntry, error) {
+        f, err := os.Open(path)
+        if os.IsNotExist(err) {
+                return nil, nil
+        }
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+
+        var entries []auditEntry
+        scanner := bufio.NewScanner(f)
+        scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+        for line := 1; scanner.Scan(); line++ {
+                if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+                        continue
+                }
+                data, _, err := openConfigData(scanner.Bytes())
+                if err != nil {
+                        return entries, fmt.Errorf("line %d: %v", line, err)
+                }
+                var entry auditEntry
+                if err := json.Unmarshal(data, &entry); err != nil {
+                        return entries, fmt.Errorf("line %d: %v", line, err)
+                }
+                entries = append(entries, entry)
+        }
+        return entries, scanner.Err()
+}
+
+// verifyAuditChain returns a description of the first broken link, or "".
+func verifyAuditChain(entries []auditEntry) string {
+        prev := ""
+        var prevSeq int64
+        for _, entry := range entries {
+                if entry.PrevHash != prev {
+                        return fmt.Sprintf("entry %d does not follow entry %d", entry.Seq, prevSeq)
+                }
+                if entry.Seq != prevSeq+1 {
+                        return fmt.Sprintf("entry %d follows entry %d", entry.Seq, prevSeq)
+                }
+                if auditEntryHash(entry) != entry.Hash {
+                        return fmt.Sprintf("entry %d has been modified", entry.Seq)
+                }
+                prev, prevSeq = entry.Hash, entry.Seq
+        }
+        return ""
+}
+
+// verifyAuditLog checks the chain and that it still reaches head. Entries
+// after the head are accepted: the server may have stopped between writing
+// an entry and recording it as the head.
+func verifyAuditLog(entries []auditEntry, head auditHead) string {
+        if problem := verifyAuditChain(entries); problem != "" {
+                return problem
+        }
+        if head.Seq == 0 {
+                if len(entries) > 0 {
+                        return "the head record is missing"
+                }
+                return ""
+        }
+        if int64(len(entries)) < head.Seq {
+                return fmt.Sprintf("the log ends at entry %d but entry %d was written", len(entries), head.Seq)
+        }
+        if entries[head.Seq-1].Hash != head.Hash {
+                return fmt.Sprintf("entry %d does not match the recorded head", head.Seq)
+        }
+        return ""
+}
+
+// appendAudit chains and durably appends one entry.
+func appendAudit(entry auditEntry) error {
+        auditMu.Lock()
+        defer auditMu.Unlock()
+
+        if auditPath == "" {
+                return nil
+        }
+
+        entry.Seq = auditLastSeq + 1
+        entry.Time = time.Now().UTC()
+        entry.PrevHash = auditLastHash
+        entry.Hash = auditEntryHash(entry)
+
+        data, err := json.Marshal(entry)
+        if err != nil {
+                return err
+        }
+        line := append(data, '\n')
+        if configCipher != nil {
+                // Entries reveal config values, so they are sealed like the config.
+                if line, err = configCipher.seal(data); err != nil {
+                        return err
+                }
+        }
+
+        f, err := os.OpenFile(auditPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+        if err != nil {
+                return err
+        }
+        defer f.Close()
+
+        if _, err := f.Write(line); err != nil {
+                return err
+        }
+        if err := f.Sync(); err != nil {
+                return err
+        }
+
+        auditLastSeq = entry.Seq
+        auditLastHash = entry.Hash
+
+        head, _ := json.Marshal(auditHead{Seq: entry.Seq, Hash: entry.Hash})
+        return writeFileAtomic(auditHeadPath(), append(head, '\n'), 0600)
+}
+
+// flattenConfig turns a config into dotted field paths. Objects are expanded;
+// arrays are compared as a whole.
+func flattenConfig(prefix string, v interface{}, out map[string]interface{}) {
+        obj, ok := v.(map[string]interface{})
+        if !ok || (prefix != "" && len(obj) == 0) {
+                out[prefix] = v
+                return
+        }
+        for key, value := range obj {
+                path := key
+                if prefix != "" {
+                        path = prefix + "." + key
+                }
+                flattenConfig(path, value, out)
+        }
+}
+
+func isSecretAuditPath(path string) bool {
+        for _, segment := range strings.Split(path, ".") {
+                if auditSecretKeys[segment] {
+                        return true
+                }
+        }
+        return false
+}
+
+// configChanges diffs two configs field by field. Secret values are masked,
+// but a change to them is still recorded.
+func configChanges(before, after AppConfig) []auditChange {
+        flat := func(cfg AppConfig) map[string]interface{} {
+                data, _ := json.Marshal(cfg)
+                var doc interface{}
+                json.Unmarshal(data, &doc)
+                out := map[string]interface{}{}
+                flattenConfig("", doc, out)
+                return out
+        }
+        old, current := flat(before), flat(after)
+
+        paths := map[string]bool{}
+        for path := range old {
+                paths[path] = true
+        }
+        for path := range current {
+                paths[path] = true
+        }
+
+        changes := []auditChange{}
+        for path := range paths {
+                oldValue, hadOld := old[path]
+                newValue, hasNew := current[path]
+                if hadOld && hasNew && reflect.DeepEqual(oldValue, newValue) {
+                        continue
+                }
+                if isSecretAuditPath(path) {
+                        if hadOld {
+                                oldValue = auditMaskedValue
+                        }
+                        if hasNew {
+                                newValue = auditMaskedValue
+                        }
+                }
+                changes = append(changes, auditChange{Field: path, Old: oldValue, New: newValue})
+        }
+        sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+        return changes
+}
+
+// auditConfigChange records a config change made by r, or by the server
+// itself when r is nil. Failures are logged; the change has already been
+// applied.
+func auditConfigChange(r *http.Request, action string, before, after AppConfig) {
+        entry := auditEntry{Action: action, Changes: configChanges(before, after)}
+        if r != nil {
+                entry.SourceIP = clientIP(r)
+                entry.Principal = requestPrincipal(r)
+        }
+
+        if err := appendAudit(entry); err != nil {
+                log.Printf("Warning: Could not write audit log entry for %s: %v", action, err)
+        }
+}
+
+// handleAudit returns audit entries, oldest first, optionally filtered by
+// ?since= and ?until= (RFC 3339) and capped by ?limit=. The chain is
+// verified on every request.
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+        query := r.URL.Query()
+
+        var since, until time.Time
+        for name, dst := range map[string]*time.Time{"since": &since, "until": &until} {
+                if value := query.Get(name); value != "" {
+                        t, err := time.Parse(time.RFC3339, value)
+                        if err != nil {
+                                writeJSONError(w, http.StatusBadRequest, name+" must be an RFC 3339 timestamp")
+                                return
+                        }
+                        *dst = t
+                }
+        }
+
+        limit := auditDefaultLimit
+        if value := query.Get("limit"); value != "" {
+                n, err := strconv.Atoi(value)
+                if err != nil || n < 1 || n > auditMaxLimit {
+                        writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", auditMaxLimit))
+                        return
+                }
+                limit = n
+        }
+
+        auditMu.Lock()
+        entries, err := readAuditLog(auditPath)
+        head := auditHead{Seq: auditLastSeq, Hash: auditLastHash}
+        auditMu.Unlock()
+
+        chain := map[string]interface{}{"valid": true, "entries": len(entries)}
+        if err != nil {
+                chain["valid"] = false
+                chain["error"] = err.Error()
+        } else if problem := verifyAuditLog(entries, head); problem != "" {
+                chain["valid"] = false
+                chain["error"] = problem
+        }
+
+        matched := []auditEntry{}
+        for _, entry := range entries {
+                if !since.IsZero() && entry.Time.Before(since) {
+                        continue
+                }
+                if !until.IsZero() && entry.Time.After(until) {
+                        continue
+                }
+                matched = append(matched, entry)
+        }
+
+        truncated := len(matched) > limit
+        if truncated {
+                matched = matched[len(matched)-limit:]
+        }
+
+        writeJSON(w, http.StatusOK, map[string]interface{}{
+                "chain":     chain,
+                "entries":   matched,
+                "truncated": truncated,
+        })
+}
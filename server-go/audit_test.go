@@ -0,0 +1,143 @@
+package main
+
+import (
+        "bytes"
+        "crypto/sha256"
+        "encoding/hex"
+        "encoding/json"
+        "os"
+        "path/filepath"
+        "strings"
+        "testing"
+)
+
+func writeAuditLines(t *testing.T, path string, entries []auditEntry) {
+        t.Helper()
+        var buf bytes.Buffer
+        for _, entry := range entries {
+                data, err := json.Marshal(entry)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                buf.Write(append(data, '\n'))
+        }
+        if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+                t.Fatal(err)
+        }
+}
+
+func TestAuditLogTampering(t *testing.T) {
+        tests := []struct {
+                name    string
+                tamper  func(entries []auditEntry, head *auditHead) []auditEntry
+                problem string // substring of the expected problem, "" if valid
+        }{
+                {"untouched", func(e []auditEntry, _ *auditHead) []auditEntry { return e }, ""},
+                {"edited entry", func(e []auditEntry, _ *auditHead) []auditEntry {
+                        e[1].Action = "config.other"
+                        return e
+                }, "entry 2 has been modified"},
+                {"deleted entry", func(e []auditEntry, _ *auditHead) []auditEntry {
+                        return append(e[:1:1], e[2:]...)
+                }, "does not follow"},
+                {"truncated tail", func(e []auditEntry, _ *auditHead) []auditEntry {
+                        return e[:2]
+                }, "the log ends at entry 2 but entry 3 was written"},
+                {"truncated log", func(e []auditEntry, _ *auditHead) []auditEntry {
+                        return nil
+                }, "the log ends at entry 0"},
+                {"head removed", func(e []auditEntry, head *auditHead) []auditEntry {
+                        *head = auditHead{}
+                        return e
+                }, "head record is missing"},
+                {"chain recomputed without the key", func(e []auditEntry, head *auditHead) []auditEntry {
+                        e = e[:2]
+                        e[1].Action = "config.other"
+                        prev := ""
+                        for i := range e {
+                                e[i].PrevHash = prev
+                                e[i].Hash = ""
+                                data, _ := json.Marshal(e[i])
+                                sum := sha256.Sum256(data)
+                                e[i].Hash = hex.EncodeToString(sum[:])
+                                prev = e[i].Hash
+                        }
+                        *head = auditHead{Seq: 2, Hash: prev}
+                        return e
+                }, "entry 1 has been modified"},
+                {"entry written after head", func(e []auditEntry, head *auditHead) []auditEntry {
+                        *head = auditHead{Seq: e[1].Seq, Hash: e[1].Hash}
+                        return e
+                }, ""},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        path := filepath.Join(t.TempDir(), auditFileName)
+                        if err := openAuditLog(path); err != nil {
+                                t.Fatal(err)
+                        }
+                        t.Cleanup(func() { auditPath = "" })
+                        for _, action := range []string{"config.update", "config.rollback", "config.update"} {
+                                if err := appendAudit(auditEntry{Action: action, Changes: []auditChange{}}); err != nil {
+                                        t.Fatal(err)
+                                }
+                        }
+
+                        entries, err := readAuditLog(path)
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        head, err := readAuditHead()
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        if head.Seq != 3 || head.Hash != entries[2].Hash {
+                                t.Fatalf("head = %+v, want entry 3", head)
+                        }
+
+                        entries = tt.tamper(entries, &head)
+                        writeAuditLines(t, path, entries)
+                        if entries, err = readAuditLog(path); err != nil {
+                                t.Fatal(err)
+                        }
+
+                        problem := verifyAuditLog(entries, head)
+                        if tt.problem == "" && problem != "" {
+                                t.Errorf("verifyAuditLog() = %q, want valid", problem)
+                        }
+                        if tt.problem != "" && !strings.Contains(problem, tt.problem) {
+                                t.Errorf("verifyAuditLog() = %q, want %q", problem, tt.problem)
+                        }
+                })
+        }
+}
+
+func TestAuditLogKeyPersists(t *testing.T) {
+        path := filepath.Join(t.TempDir(), auditFileName)
+        if err := openAuditLog(path); err != nil {
+                t.Fatal(err)
+        }
+        t.Cleanup(func() { auditPath = "" })
+        if err := appendAudit(auditEntry{Action: "config.update", Changes: []auditChange{}}); err != nil {
+                t.Fatal(err)
+        }
+
+        // Reopening reads the same key and continues the chain.
+        if err := openAuditLog(path); err != nil {
+                t.Fatal(err)
+        }
+        if err := appendAudit(auditEntry{Action: "config.update", Changes: []auditChange{}}); err != nil {
+                t.Fatal(err)
+        }
+        entries, err := readAuditLog(path)
+        if err != nil {
+                t.Fatal(err)
+        }
+        head, err := readAuditHead()
+        if err != nil {
+                t.Fatal(err)
+        }
+        if problem := verifyAuditLog(entries, head); problem != "" || len(entries) != 2 {
+                t.Errorf("after reopening: %d entries, problem %q", len(entries), problem)
+        }
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+        "context"
+        "crypto/sha256"
+        "crypto/subtle"
+        "encoding/hex"
+        "fmt"
+        "net/http"
+        "strings"
+)
+
+// adminAuth guards every config mutation. Only the SHA-256 digest of the
+// admin token is kept in memory; candidates are hashed and compared in
+// constant time.
+type adminAuth struct {
+        enabled   bool
+        tokenHash [sha256.Size]byte
+}
+
+var admin adminAuth
+
+// adminPrincipal identifies requests authenticated with the admin token in
+// the audit log.
+const adminPrincipal = "admin"
+
+type principalKey struct{}
+
+// requestPrincipal returns who authenticated r, or "" for anonymous requests.
+func requestPrincipal(r *http.Request) string {
+        principal, _ := r.Context().Value(principalKey{}).(string)
+        return principal
+}
+
+func newAdminAuth(token, tokenHash string) (adminAuth, error) {
+        if tokenHash != "" {
+                raw, err := hex.DecodeString(strings.TrimSpace(tokenHash))
+                if err != nil || len(raw) != sha256.Size {
+                        return adminAuth{}, fmt.Errorf("admin token hash must be a hex-encoded SHA-256 digest")
+                }
+                a := adminAuth{enabled: true}
+                copy(a.tokenHash[:], raw)
+                return a, nil
+        }
+
+        if token == "" {
+                return adminAuth{}, nil
+        }
+
+        return adminAuth{enabled: true, tokenHash: sha256.Sum256([]byte(token))}, nil
+}
+
+// credentialFromRequest accepts either "Authorization: Bearer <token>" or
+// HTTP Basic auth, in which case the password is the token.
+func credentialFromRequest(r *http.Request) (string, bool) {
+        authHeader := r.Header.Get("Authorization")
+        if authHeader == "" {
+                return "", false
+        }
+
+        if len(authHeader) > 7 && strings.EqualFold(authHeader[:7], "Bearer ") {
+                token := strings.TrimSpace(authHeader[7:])
+                return token, token != ""
+        }
+
+        if _, password, ok := r.BasicAuth(); ok && password != "" {
+                return password, true
+        }
+
+        return "", false
+}
+
+func (a adminAuth) verify(candidate string) bool {
+        if !a.enabled {
+                return false
+        }
+        sum := sha256.Sum256([]byte(candidate))
+        return subtle.ConstantTimeCompare(sum[:], a.tokenHash[:]) == 1
+}
+
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+                if !admin.enabled {
+                        writeJSONError(w, http.StatusForbidden, "Admin access is not configured on this server")
+                        return
+                }
+
+                token, ok := credentialFromRequest(r)
+                if !ok {
+                        w.Header().Set("WWW-Authenticate", `Bearer realm="camroid-admin"`)
+                        writeJSONError(w, http.StatusUnauthorized, "Admin credentials required")
+                        return
+                }
+
+                if !admin.verify(token) {
+                        writeJSONError(w, http.StatusForbidden, "Invalid admin credentials")
+                        return
+                }
+
+                next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, adminPrincipal)))
+        }
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+        "bufio"
+        "crypto/ed25519"
+        "crypto/rand"
+        "crypto/sha256"
+        "encoding/base64"
+        "encoding/hex"
+        "encoding/json"
+        "fmt"
+        "log"
+        "net/http"
+        "os"
+        "strings"
+        "time"
+)
+
+// Config bundles carry the persisted config fields (unlock secrets stay
+// hashed) signed with the server's Ed25519 key. Another server applies a
+// bundle only if it was signed by its own key or by one listed in
+// trusted_keys in its data directory, and only if it is newer than the last
+// bundle it applied from that key.
+const (
+        bundleFormat          = "camroid-config-bundle"
+        signingKeyFileName    = "signing.key"
+        trustedKeysFileName   = "trusted_keys"
+        bundleImportsFileName = "bundle_imports.json"
+        maxBundleBytes        = 1 << 20
+)
+
+// bundleLocalFields are sealed under this server's secrets.key, which no
+// other server has. They are left out of exports, and an import keeps the
+// importing server's own values.
+var bundleLocalFields = []string{"PROXY_CREDENTIALS", "UNLOCK_TOTP_SECRET"}
+
+type configBundle struct {
+        Format        string                     `json:"format"`
+        ConfigVersion int                        `json:"configVersion"`
+        CreatedAt     time.Time                  `json:"createdAt"`
+        KeyID         string                     `json:"keyId"`
+        PublicKey     string                     `json:"publicKey"`
+        Config        map[string]json.RawMessage `json:"config"`
+        Signature     string                     `json:"signature,omitempty"`
+}
+
+var (
+        bundleSigningKey  ed25519.PrivateKey
+        trustedKeysPath   string
+        bundleImportsPath string
+)
+
+func publicKeyID(pub ed25519.PublicKey) string {
+        sum := sha256.Sum256(pub)
+        return hex.EncodeToString(sum[:8])
+}
+
+// bundleSigningPayload is the bundle without its signature, as signed.
+func bundleSigningPayload(b configBundle) ([]byte, error) {
+        b.Signature = ""
+        return json.Marshal(b)
+}
+
+// loadSigningKey reads the server's bundle signing key, creating it on first
+// use. The seed is stored like the config, encrypted when a key is set.
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+        data, err := os.ReadFile(path)
+        if os.IsNotExist(err) {
+                _, priv, err := ed25519.GenerateKey(rand.Reader)
+                if err != nil {
+                        return nil, err
+                }
+                sealed, err := sealConfigData([]byte(base64.StdEncoding.EncodeToString(priv.Seed()) + "\n"))
+                if err != nil {
+                        return nil, err
+                }
+                if err := writeFileAtomic(path, sealed, 0600); err != nil {
+                        return nil, err
+                }
+                log.Printf("Generated config signing key %s", path)
+                return priv, nil
+        }
+        if err != nil {
+                return nil, err
+        }
+
+        plain, _, err := openConfigData(data)
+        if err != nil {
+                return nil, err
+        }
+        seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(plain)))
+        if err != nil || len(seed) != ed25519.SeedSize {
+                return nil, fmt.Errorf("%s does not hold a base64 Ed25519 seed", path)
+        }
+        return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// readBundleImports returns the createdAt of the newest bundle applied from
+// each signing key, by key id.
+func readBundleImports() (map[string]time.Time, error) {
+        imports := map[string]time.Time{}
+        data, err := os.ReadFile(bundleImportsPath)
+        if os.IsNotExist(err) {
+                return imports, nil
+        }
+        if err != nil {
+                return nil, err
+        }
+        if err := json.Unmarshal(data, &imports); err != nil {
+                return nil, fmt.Errorf("%s: %v", bundleImportsFileName, err)
+        }
+        return imports, nil
+}
+
+func recordBundleImport(imports map[string]time.Time, keyID string, createdAt time.Time) error {
+        imports[keyID] = createdAt
+        return writeBundleImports(imports)
+}
+
+func writeBundleImports(imports map[string]time.Time) error {
+        data, err := json.MarshalIndent(imports, "", "  ")
+        if err != nil {
+                return err
+        }
+        return writeFileAtomic(bundleImportsPath, append(data, '\n'), 0600)
+}
+
+// trustedBundleKeys returns the keys accepted for import: this server's own
+// key plus one base64 public key per line of trusted_keys. Text after the
+// key and lines starting with # are ignored.
+func trustedBundleKeys() (map[string]ed25519.PublicKey, error) {
+        keys := map[string]ed25519.PublicKey{}
+        if bundleSigningKey != nil {
+                pub := bundleSigningKey.Public().(ed25519.PublicKey)
+                keys[base64.StdEncoding.EncodeToString(pub)] = pub
+        }
+
+        f, err := os.Open(trustedKeysPath)
+        if os.IsNotExist(err) {
+                return keys, nil
+        }
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+
+        scanner := bufio.NewScanner(f)
+        for line := 1; scanner.Scan(); line++ {
+                text := strings.TrimSpace(scanner.Text())
+                if text == "" || strings.HasPrefix(text, "#") {
+                        continue
+                }
+                encoded := strings.Fields(text)[0]
+                pub, err := base64.StdEncoding.DecodeString(encoded)
+                if err != nil || len(pub) != ed25519.PublicKeySize {
+                        log.Printf("Warning: %s line %d is not a base64 Ed25519 public key", trustedKeysPath, line)
+                        continue
+                }
+                keys[encoded] = ed25519.PublicKey(pub)
+        }
+        return keys, scanner.Err()
+}
+
+func handleConfigExport(w http.ResponseWriter, r *http.Request) {
+        if bundleSigningKey == nil {
+                writeJSONError(w, http.StatusServiceUnavailable, "No signing key is available")
+                return
+        }
+
+        appConfigLock.RLock()
+        fields, err := configFileFields(appConfig, appConfigLayers)
+        appConfigLock.RUnlock()
+        if err != nil {
+                writeJSONError(w, http.StatusInternalServerError, "Failed to read config")
+                return
+        }
+        for _, key := range bundleLocalFields {
+                delete(fields, key)
+        }
+
+        pub := bundleSigningKey.Public().(ed25519.PublicKey)
+        bundle := configBundle{
+                Format:        bundleFormat,
+                ConfigVersion: currentConfigVersion,
+                CreatedAt:     time.Now().UTC(),
+                KeyID:         publicKeyID(pub),
+                PublicKey:     base64.StdEncoding.EncodeToString(pub),
+                Config:        fields,
+        }
+
+        payload, err := bundleSigningPayload(bundle)
+        if err != nil {
+                writeJSONError(w, http.StatusInternalServerError, "Failed to sign config")
+                return
+        }
+        bundle.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(bundleSigningKey, payload))
+
+        w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="camroid-config-%s.json"`, bundle.CreatedAt.Format("20060102T150405Z")))
+        writeJSON(w, http.StatusOK, bundle)
+}
+
+// handleConfigImport verifies a bundle and applies its config. Fields set by
+// the environment on this server still take precedence. A bundle older than
+// the last one applied from the same key, or for an older CONFIG_VERSION, is
+// refused so a captured bundle cannot roll the config back.
+func handleConfigImport(w http.ResponseWriter, r *http.Request) {
+        var bundle configBundle
+        dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBundleBytes))
+        dec.DisallowUnknownFields()
+        if err := dec.Decode(&bundle); err != nil {
+                writeJSONError(w, http.StatusBadRequest, "Invalid bundle: "+strings.TrimPrefix(err.Error(), "json: "))
+                return
+        }
+        if bundle.Format != bundleFormat || bundle.Config == nil || bundle.Signature == "" {
+                writeJSONError(w, http.StatusBadRequest, "Not a signed config bundle")
+                return
+        }
+
+        trusted, err := trustedBundleKeys()
+        if err != nil {
+                log.Printf("Warning: Could not read %s: %v", trustedKeysPath, err)
+                writeJSONError(w, http.StatusInternalServerError, "Failed to read trusted keys")
+                return
+        }
+        pub, ok := trusted[bundle.PublicKey]
+        if !ok {
+                writeJSONError(w, http.StatusForbidden, "Bundle is not signed by a trusted key")
+                return
+        }
+
+        signature, err := base64.StdEncoding.DecodeString(bundle.Signature)
+        payload, payloadErr := bundleSigningPayload(bundle)
+        if err != nil || payloadErr != nil || !ed25519.Verify(pub, payload, signature) {
+                writeJSONError(w, http.StatusForbidden, "Bundle signature is invalid")
+                return
+        }
+
+        if bundle.ConfigVersion > currentConfigVersion {
+                writeJSONError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Bundle config version %d is newer than this server supports (%d)", bundle.ConfigVersion, currentConfigVersion))
+                return
+        }
+        if bundle.ConfigVersion < currentConfigVersion {
+                writeJSONError(w, http.StatusUnprocessableEntity, fmt.Sprintf("Bundle config version %d is older than this server's (%d); export it again from an up-to-date server", bundle.ConfigVersion, currentConfigVersion))
+                return
+        }
+        if version, err := configFileVersion(bundle.Config); err != nil || version != bundle.ConfigVersion {
+                writeJSONError(w, http.StatusBadRequest, "Bundle CONFIG_VERSION does not match its configVersion")
+                return
+        }
+        if bundle.CreatedAt.IsZero() {
+                writeJSONError(w, http.StatusBadRequest, "Bundle has no creation time")
+                return
+        }
+
+        for _, key := range bundleLocalFields {
+                delete(bundle.Config, key)
+        }
+        data, err := json.Marshal(bundle.Config)
+        if err != nil {
+                writeJSONError(w, http.StatusBadRequest, "Invalid bundle config")
+                return
+        }
+
+        current, release, ok := beginConfigUpdate(w, r)
+        if !ok {
+                return
+        }
+        defer release()
+
+        keyID := publicKeyID(pub)
+        imports, err := readBundleImports()
+        if err != nil {
+                log.Printf("Warning: Could not read %s: %v", bundleImportsPath, err)
+                writeJSONError(w, http.StatusInternalServerError, "Failed to read bundle import history")
+                return
+        }
+        if last, ok := imports[keyID]; ok && !bundle.CreatedAt.After(last) {
+                writeJSONError(w, http.StatusConflict, fmt.Sprintf("A bundle from key %s created at %s has already been applied; only newer bundles are accepted", keyID, last.Format(time.RFC3339)))
+                return
+        }
+
+        cfg, layers, _, err := parseAppConfig("json", data)
+        if err != nil {
+                writeJSONError(w, http.StatusUnprocessableEntity, "Bundle config is not a valid config document: "+err.Error())
+                return
+        }
+        cfg.ProxyCredentials = current.ProxyCredentials
+        cfg.UnlockTOTPSecret = current.UnlockTOTPSecret
+
+        if errs := validateAppConfig(&cfg); len(errs) > 0 {
+                writeValidationErrors(w, errs)
+                return
+        }
+
+        // The import is recorded before the config is committed: if the
+        // record could not be written after the commit, the same bundle
+        // could be replayed later.
+        previousImport, hadImport := imports[keyID]
+        if err := recordBundleImport(imports, keyID, bundle.CreatedAt); err != nil {
+                log.Printf("Failed to record bundle import: %v", err)
+                writeJSONError(w, http.StatusInternalServerError, "Failed to record bundle import")
+                return
+        }
+
+        previousLayers := currentConfigLayers()
+        setConfigLayers(layers)
+
+        if err := commitAppConfig(r, "config.import", cfg); err != nil {
+                setConfigLayers(previousLayers)
+                if hadImport {
+                        imports[keyID] = previousImport
+                } else {
+                        delete(imports, keyID)
+                }
+                if err := writeBundleImports(imports); err != nil {
+                        log.Printf("Warning: Could not roll back bundle import record: %v", err)
+                }
+                log.Printf("Failed to save config: %v", err)
+                writeJSONError(w, http.StatusInternalServerError, "Failed to save config")
+                return
+        }
+
+        log.Printf("Config imported from bundle signed by key %s", keyID)
+        handleAdminConfigGet(w, r)
+}
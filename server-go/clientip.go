@@ -0,0 +1,104 @@
+package main
+
+import (
+        "fmt"
+        "net"
+        "net/http"
+        "strings"
+)
+
+// trustedProxies lists the reverse proxies (TRUSTED_PROXIES / -trusted-proxies)
+// whose X-Forwarded-For header is believed. Without it the client address is
+// the TCP peer, which on render or netlify is the platform's proxy for every
+// visitor.
+var trustedProxies []*net.IPNet
+
+// parseTrustedProxies reads a comma-separated list of IP addresses and CIDR
+// ranges.
+func parseTrustedProxies(value string) ([]*net.IPNet, error) {
+        var nets []*net.IPNet
+        for _, item := range strings.Split(value, ",") {
+                item = strings.TrimSpace(item)
+                if item == "" {
+                        continue
+                }
+                if !strings.Contains(item, "/") {
+                        ip := net.ParseIP(item)
+                        if ip == nil {
+                                return nil, fmt.Errorf("invalid address %q", item)
+                        }
+                        bits := 8 * net.IPv6len
+                        if ip4 := ip.To4(); ip4 != nil {
+                                ip, bits = ip4, 8*net.IPv4len
+                        }
+                        nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+                        continue
+                }
+                _, ipNet, err := net.ParseCIDR(item)
+                if err != nil {
+                        return nil, fmt.Errorf("invalid range %q", item)
+                }
+                nets = append(nets, ipNet)
+        }
+        return nets, nil
+}
+
+func isTrustedProxy(ip net.IP) bool {
+        for _, n := range trustedProxies {
+                if n.Contains(ip) {
+                        return true
+                }
+        }
+        return false
+}
+
+// fromTrustedProxy reports whether r arrived directly from a trusted proxy.
+func fromTrustedProxy(r *http.Request) bool {
+        ip := net.ParseIP(peerIP(r))
+        return ip != nil && isTrustedProxy(ip)
+}
+
+func peerIP(r *http.Request) string {
+        if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+                return host
+        }
+        return r.RemoteAddr
+}
+
+// clientIP returns the address of the client that sent r. When the peer is
+// a trusted proxy, X-Forwarded-For is read from the right, skipping further
+// trusted proxies; the first other address is the client. Entries left of it
+// were supplied by the client and are ignored.
+func clientIP(r *http.Request) string {
+        ip := peerIP(r)
+        if !fromTrustedProxy(r) {
+                return ip
+        }
+
+        hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+        for i := len(hops) - 1; i >= 0; i-- {
+                hop := net.ParseIP(strings.TrimSpace(hops[i]))
+                if hop == nil {
+                        break
+                }
+                ip = hop.String()
+                if !isTrustedProxy(hop) {
+                        break
+                }
+        }
+        return ip
+}
+
+// requestIsHTTPS reports whether the client reached the server over https,
+// either directly or through a trusted proxy that says so in
+// X-Forwarded-Proto.
+func requestIsHTTPS(r *http.Request) bool {
+        if r.TLS != nil {
+                return true
+        }
+        if !fromTrustedProxy(r) {
+                return false
+        }
+        protos := strings.Split(strings.Join(r.Header.Values("X-Forwarded-Proto"), ","), ",")
+        return strings.EqualFold(strings.TrimSpace(protos[len(protos)-1]), "https")
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+        "crypto/tls"
+        "net/http/httptest"
+        "testing"
+)
+
+func TestClientIP(t *testing.T) {
+        proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.10")
+        if err != nil {
+                t.Fatal(err)
+        }
+        previous := trustedProxies
+        trustedProxies = proxies
+        t.Cleanup(func() { trustedProxies = previous })
+
+        tests := []struct {
+                name      string
+                peer      string
+                forwarded []string
+                want      string
+        }{
+                {"direct client", "198.51.100.1:1234", nil, "198.51.100.1"},
+                {"untrusted peer cannot forward", "198.51.100.1:1234", []string{"203.0.113.5"}, "198.51.100.1"},
+                {"trusted proxy", "10.1.2.3:1234", []string{"203.0.113.5"}, "203.0.113.5"},
+                {"single trusted address", "192.0.2.10:1234", []string{"203.0.113.5"}, "203.0.113.5"},
+                {"spoofed left entries ignored", "10.1.2.3:1234", []string{"1.2.3.4, 203.0.113.5"}, "203.0.113.5"},
+                {"proxy chain", "10.1.2.3:1234", []string{"203.0.113.5, 10.9.9.9"}, "203.0.113.5"},
+                {"repeated headers", "10.1.2.3:1234", []string{"1.2.3.4", "203.0.113.5"}, "203.0.113.5"},
+                {"only proxies", "10.1.2.3:1234", []string{"10.9.9.9"}, "10.9.9.9"},
+                {"garbage stops the walk", "10.1.2.3:1234", []string{"203.0.113.5, junk"}, "10.1.2.3"},
+                {"no header", "10.1.2.3:1234", nil, "10.1.2.3"},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        r := httptest.NewRequest("GET", "/", nil)
+                        r.RemoteAddr = tt.peer
+                        for _, v := range tt.forwarded {
+                                r.Header.Add("X-Forwarded-For", v)
+                        }
+                        if got := clientIP(r); got != tt.want {
+                                t.Errorf("clientIP = %q, want %q", got, tt.want)
+                        }
+                })
+        }
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+        tests := []struct {
+                value   string
+                want    int
+                wantErr bool
+        }{
+                {"", 0, false},
+                {"10.0.0.1", 1, false},
+                {"10.0.0.0/8, ::1, fd00::/8", 3, false},
+                {"10.0.0.0/33", 0, true},
+                {"proxy.example.com", 0, true},
+        }
+        for _, tt := range tests {
+                got, err := parseTrustedProxies(tt.value)
+                if (err != nil) != tt.wantErr {
+                        t.Errorf("parseTrustedProxies(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+                        continue
+                }
+                if len(got) != tt.want {
+                        t.Errorf("parseTrustedProxies(%q) = %d ranges, want %d", tt.value, len(got), tt.want)
+                }
+        }
+}
+
+func TestRequestIsHTTPS(t *testing.T) {
+        proxies, err := parseTrustedProxies("10.0.0.0/8")
+        if err != nil {
+                t.Fatal(err)
+        }
+        previous := trustedProxies
+        trustedProxies = proxies
+        t.Cleanup(func() { trustedProxies = previous })
+
+        tests := []struct {
+                name  string
+                peer  string
+                tls   bool
+                proto string
+                want  bool
+        }{
+                {"direct tls", "198.51.100.1:1234", true, "", true},
+                {"direct plain", "198.51.100.1:1234", false, "", false},
+                {"untrusted peer claims https", "198.51.100.1:1234", false, "https", false},
+                {"trusted proxy https", "10.1.2.3:1234", false, "https", true},
+                {"trusted proxy http", "10.1.2.3:1234", false, "http", false},
+                {"last hop decides", "10.1.2.3:1234", false, "https, http", false},
+                {"trusted proxy without header", "10.1.2.3:1234", false, "", false},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        r := httptest.NewRequest("GET", "/", nil)
+                        r.RemoteAddr = tt.peer
+                        if tt.tls {
+                                r.TLS = &tls.ConnectionState{}
+                        }
+                        if tt.proto != "" {
+                                r.Header.Set("X-Forwarded-Proto", tt.proto)
+                        }
+                        if got := requestIsHTTPS(r); got != tt.want {
+                                t.Errorf("requestIsHTTPS = %v, want %v", got, tt.want)
+                        }
+                })
+        }
+}
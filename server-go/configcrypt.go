@@ -0,0 +1,363 @@
+package main
+
+import (
+        "bytes"
+        "crypto/aes"
+        "crypto/cipher"
+        "crypto/rand"
+        "encoding/base64"
+        "encoding/hex"
+        "errors"
+        "flag"
+        "fmt"
+        "os"
+        "os/exec"
+        "path/filepath"
+        "strconv"
+        "strings"
+        "sync"
+
+        "golang.org/x/crypto/scrypt"
+)
+
+// Config files, revisions, migration backups and audit entries can be
+// encrypted at rest with AES-256-GCM. Encrypted data is stored as
+//
+//	aes256gcm$<kdf>$<salt>$<nonce>$<ciphertext>
+//
+// where kdf is "key" for a raw 32-byte key, or "scrypt:N:r:p" when the key
+// is derived from a passphrase with the given salt.
+const (
+        encryptedConfigPrefix = "aes256gcm$"
+        configKeyLen          = 32
+        kdfRawKey             = "key"
+)
+
+var errConfigKeyRequired = errors.New("config is encrypted but no key is configured (set CONFIG_KEY, CONFIG_KEY_FILE or CONFIG_PASSPHRASE)")
+
+// configEncryption holds the at-rest key. With a passphrase, keys are
+// derived per salt and cached; new data is sealed under the salt of the
+// first key derived.
+type configEncryption struct {
+        key        []byte
+        passphrase []byte
+
+        mu      sync.Mutex
+        salt    []byte
+        derived map[string][]byte
+}
+
+// configCipher is nil when encryption at rest is disabled.
+var configCipher *configEncryption
+
+// decodeConfigKey accepts a 32-byte key as base64 or hex text, or raw bytes.
+func decodeConfigKey(text []byte) ([]byte, error) {
+        if len(text) == configKeyLen {
+                return text, nil
+        }
+
+        s := strings.TrimSpace(string(text))
+        for _, decode := range []func(string) ([]byte, error){
+                hex.DecodeString,
+                base64.StdEncoding.DecodeString,
+                base64.RawStdEncoding.DecodeString,
+                base64.URLEncoding.DecodeString,
+                base64.RawURLEncoding.DecodeString,
+        } {
+                if key, err := decode(s); err == nil && len(key) == configKeyLen {
+                        return key, nil
+                }
+        }
+        return nil, fmt.Errorf("key must be %d bytes, given as hex or base64", configKeyLen)
+}
+
+// loadConfigEncryption reads the at-rest key from CONFIG_KEY, the key file
+// or CONFIG_PASSPHRASE. Exactly one may be set; with none, encryption is
+// disabled. The environment variables are cleared once read.
+func loadConfigEncryption(keyFile string) (*configEncryption, error) {
+        envKey := os.Getenv("CONFIG_KEY")
+        passphrase := os.Getenv("CONFIG_PASSPHRASE")
+        os.Unsetenv("CONFIG_KEY")
+        os.Unsetenv("CONFIG_PASSPHRASE")
+
+        set := 0
+        for _, v := range []string{envKey, keyFile, passphrase} {
+                if v != "" {
+                        set++
+                }
+        }
+        if set == 0 {
+                return nil, nil
+        }
+        if set > 1 {
+                return nil, fmt.Errorf("set only one of CONFIG_KEY, CONFIG_KEY_FILE and CONFIG_PASSPHRASE")
+        }
+
+        switch {
+        case envKey != "":
+                key, err := decodeConfigKey([]byte(envKey))
+                if err != nil {
+                        return nil, fmt.Errorf("CONFIG_KEY: %v", err)
+                }
+                return &configEncryption{key: key}, nil
+
+        case keyFile != "":
+                data, err := os.ReadFile(keyFile)
+                if err != nil {
+                        return nil, err
+                }
+                key, err := decodeConfigKey(data)
+                if err != nil {
+                        return nil, fmt.Errorf("%s: %v", keyFile, err)
+                }
+                return &configEncryption{key: key}, nil
+
+        default:
+                return &configEncryption{passphrase: []byte(passphrase), derived: map[string][]byte{}}, nil
+        }
+}
+
+func isEncryptedConfig(data []byte) bool {
+        return bytes.HasPrefix(data, []byte(encryptedConfigPrefix))
+}
+
+func (c *configEncryption) deriveKey(salt []byte, n, r, p int) ([]byte, error) {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+
+        id := fmt.Sprintf("%d:%d:%d:%x", n, r, p, salt)
+        if key, ok := c.derived[id]; ok {
+                return key, nil
+        }
+        key, err := scrypt.Key(c.passphrase, salt, n, r, p, configKeyLen)
+        if err != nil {
+                return nil, err
+        }
+        c.derived[id] = key
+        if c.salt == nil && n == scryptN && r == scryptR && p == scryptP {
+                c.salt = salt
+        }
+        return key, nil
+}
+
+// sealingKey returns the key and kdf/salt header fields for new data.
+func (c *configEncryption) sealingKey() ([]byte, string, []byte, error) {
+        if c.key != nil {
+                return c.key, kdfRawKey, nil, nil
+        }
+
+        c.mu.Lock()
+        salt := c.salt
+        c.mu.Unlock()
+        if salt == nil {
+                salt = make([]byte, scryptSaltLen)
+                if _, err := rand.Read(salt); err != nil {
+                        return nil, "", nil, err
+                }
+        }
+
+        key, err := c.deriveKey(salt, scryptN, scryptR, scryptP)
+        return key, fmt.Sprintf("scrypt:%d:%d:%d", scryptN, scryptR, scryptP), salt, err
+}
+
+func (c *configEncryption) seal(plain []byte) ([]byte, error) {
+        key, kdf, salt, err := c.sealingKey()
+        if err != nil {
+                return nil, err
+        }
+
+        block, err := aes.NewCipher(key)
+        if err != nil {
+                return nil, err
+        }
+        gcm, err := cipher.NewGCM(block)
+        if err != nil {
+                return nil, err
+        }
+
+        nonce := make([]byte, gcm.NonceSize())
+        if _, err := rand.Read(nonce); err != nil {
+                return nil, err
+        }
+
+        // The header is authenticated so the kdf and salt cannot be swapped.
+        header := fmt.Sprintf("%s%s$%s$%s$", encryptedConfigPrefix, kdf, b64.EncodeToString(salt), b64.EncodeToString(nonce))
+        sealed := gcm.Seal(nil, nonce, plain, []byte(header))
+        return []byte(header + b64.EncodeToString(sealed) + "\n"), nil
+}
+
+func (c *configEncryption) open(data []byte) ([]byte, error) {
+        parts := strings.Split(strings.TrimSpace(strings.TrimPrefix(string(data), encryptedConfigPrefix)), "$")
+        if len(parts) != 4 {
+                return nil, fmt.Errorf("malformed encrypted config")
+        }
+        kdf := parts[0]
+        salt, err1 := b64.DecodeString(parts[1])
+        nonce, err2 := b64.DecodeString(parts[2])
+        sealed, err3 := b64.DecodeString(parts[3])
+        if err1 != nil || err2 != nil || err3 != nil {
+                return nil, fmt.Errorf("malformed encrypted config")
+        }
+
+        var key []byte
+        switch {
+        case kdf == kdfRawKey:
+                if c.key == nil {
+                        return nil, fmt.Errorf("config was encrypted with a key, but a passphrase is configured")
+                }
+                key = c.key
+        case strings.HasPrefix(kdf, "scrypt:"):
+                if c.passphrase == nil {
+                        return nil, fmt.Errorf("config was encrypted with a passphrase, but a key is configured")
+                }
+                params := strings.Split(strings.TrimPrefix(kdf, "scrypt:"), ":")
+                if len(params) != 3 {
+                        return nil, fmt.Errorf("malformed encrypted config")
+                }
+                n, err1 := strconv.Atoi(params[0])
+                r, err2 := strconv.Atoi(params[1])
+                p, err3 := strconv.Atoi(params[2])
+                if err1 != nil || err2 != nil || err3 != nil {
+                        return nil, fmt.Errorf("malformed encrypted config")
+                }
+                if key, err1 = c.deriveKey(salt, n, r, p); err1 != nil {
+                        return nil, err1
+                }
+        default:
+                return nil, fmt.Errorf("unknown key derivation %q", kdf)
+        }
+
+        block, err := aes.NewCipher(key)
+        if err != nil {
+                return nil, err
+        }
+        gcm, err := cipher.NewGCM(block)
+        if err != nil {
+                return nil, err
+        }
+        if len(nonce) != gcm.NonceSize() {
+                return nil, fmt.Errorf("malformed encrypted config")
+        }
+
+        header := encryptedConfigPrefix + strings.Join(parts[:3], "$") + "$"
+        plain, err := gcm.Open(nil, nonce, sealed, []byte(header))
+        if err != nil {
+                return nil, fmt.Errorf("wrong key or corrupted config")
+        }
+        return plain, nil
+}
+
+// sealConfigData encrypts data for writing when encryption is enabled.
+func sealConfigData(data []byte) ([]byte, error) {
+        if configCipher == nil {
+                return data, nil
+        }
+        return configCipher.seal(data)
+}
+
+// openConfigData decrypts data read from disk. Plaintext is passed through
+// and reported as such so it can be re-encrypted; encrypted data without a
+// usable key is an error, never a fallback to defaults.
+func openConfigData(data []byte) ([]byte, bool, error) {
+        if !isEncryptedConfig(data) {
+                return data, false, nil
+        }
+        if configCipher == nil {
+                return nil, true, errConfigKeyRequired
+        }
+        plain, err := configCipher.open(data)
+        return plain, true, err
+}
+
+// runEditConfig implements "server edit-config": decrypt the config file
+// into a private temp file, open $EDITOR, validate the result and write it
+// back encrypted. A running server picks the change up through hot reload.
+func runEditConfig(args []string) int {
+        fs := flag.NewFlagSet("edit-config", flag.ExitOnError)
+        dataDir := fs.String("config", getEnv("CONFIG_DIR", "./data"), "Data directory holding the config file")
+        keyFile := fs.String("config-key-file", getEnv("CONFIG_KEY_FILE", ""), "File holding the config encryption key")
+        fs.Parse(args)
+
+        var err error
+        if configCipher, err = loadConfigEncryption(*keyFile); err != nil {
+                fmt.Fprintf(os.Stderr, "Invalid config encryption key: %v\n", err)
+                return 1
+        }
+        if configCipher == nil {
+                fmt.Fprintln(os.Stderr, "No encryption key configured; edit the config file directly")
+                return 1
+        }
+
+        path := findConfigFile(*dataDir)
+        data, err := os.ReadFile(path)
+        if err != nil && !os.IsNotExist(err) {
+                fmt.Fprintf(os.Stderr, "Could not read %s: %v\n", path, err)
+                return 1
+        }
+        plain, _, err := openConfigData(data)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Could not decrypt %s: %v\n", path, err)
+                return 1
+        }
+
+        tmp, err := os.CreateTemp("", "camroid-config-*"+filepath.Ext(path))
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Could not create temp file: %v\n", err)
+                return 1
+        }
+        defer os.Remove(tmp.Name())
+        _, err = tmp.Write(plain)
+        if closeErr := tmp.Close(); err == nil {
+                err = closeErr
+        }
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Could not write temp file: %v\n", err)
+                return 1
+        }
+
+        editor := os.Getenv("EDITOR")
+        if editor == "" {
+                editor = "vi"
+        }
+        cmd := exec.Command("sh", "-c", editor+` "$0"`, tmp.Name())
+        cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+        if err := cmd.Run(); err != nil {
+                fmt.Fprintf(os.Stderr, "Editor failed: %v\n", err)
+                return 1
+        }
+
+        edited, err := os.ReadFile(tmp.Name())
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Could not read temp file: %v\n", err)
+                return 1
+        }
+        if bytes.Equal(edited, plain) {
+                fmt.Println("No changes")
+                return 0
+        }
+
+        cfg, _, _, err := parseAppConfig(configFormat(path), edited)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Not saved: %v\n", err)
+                return 1
+        }
+        if errs := validateAppConfig(&cfg); len(errs) > 0 {
+                for _, e := range errs {
+                        fmt.Fprintf(os.Stderr, "  %s: %s\n", e.Field, e.Message)
+                }
+                fmt.Fprintln(os.Stderr, "Not saved: config is invalid")
+                return 1
+        }
+
+        sealed, err := sealConfigData(edited)
+        if err == nil {
+                err = writeFileAtomic(path, sealed, 0600)
+        }
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Could not save %s: %v\n", path, err)
+                return 1
+        }
+
+        fmt.Printf("Saved %s\n", path)
+        return 0
+}
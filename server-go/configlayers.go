@@ -0,0 +1,452 @@
+package main
+
+import (
+        "bytes"
+        "encoding/json"
+        "fmt"
+        "log"
+        "net/http"
+        "os"
+        "path/filepath"
+        "reflect"
+        "sort"
+        "strconv"
+        "strings"
+
+        "github.com/BurntSushi/toml"
+        "gopkg.in/yaml.v3"
+)
+
+// The live config is built from three layers, each replacing whole top-level
+// fields of the one below it:
+//
+//	default  built-in values from defaultAppConfig
+//	file     config.json, config.yaml/.yml or config.toml in the data directory
+//	env      CAMROID_<FIELD> environment variables
+//
+// API writes only ever change the file layer. Fields supplied by the
+// environment are read-only through the API.
+const (
+        layerDefault = "default"
+        layerFile    = "file"
+        layerEnv     = "env"
+
+        configEnvPrefix = "CAMROID_"
+)
+
+// configFileNames are probed in order inside the data directory.
+var configFileNames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// configLayers records what each layer contributed to the live config.
+type configLayers struct {
+        file    map[string]json.RawMessage
+        sources map[string]string
+        envVars map[string]string
+}
+
+// appConfigLayers describes appConfig and is guarded by appConfigLock.
+var appConfigLayers configLayers
+
+func defaultAppConfig() AppConfig {
+        return AppConfig{
+                PrivacyMode:          false,
+                SelectedModule:       "game-2048",
+                ModuleUnlockValues:   map[string]string{"calculator": "123456=", "notepad": "secret", "game-2048": ""},
+                ModuleDuressValues:   map[string]string{},
+                UnlockGesture:        "severalFingers",
+                UnlockPattern:        "0-4-8-5",
+                UnlockFingers:        4,
+                AutoLockMinutes:      5,
+                DebugMode:            false,
+                AllowedProxyHosts:    []ProxyHostPolicy{{Host: "api.imgbb.com"}, {Host: "api.imgur.com"}, {Host: "api.cloudinary.com"}},
+                ProxyAllowedNetworks: []string{},
+                ProxyCredentialRules: []CredentialRule{},
+                UnlockMaxAttempts:    5,
+                UnlockBackoffSeconds: 1,
+                UnlockLockoutMinutes: 15,
+                DuressResponse:       DuressResponseConfig{},
+                OriginValidation:     defaultOriginValidation(),
+                ConfigVersion:        currentConfigVersion,
+        }
+}
+
+func defaultOriginValidation() OriginValidationConfig {
+        return OriginValidationConfig{
+                Mode:            "disabled",
+                AllowedHosts:    []string{},
+                AllowedPatterns: []string{},
+                AllowedSchemes:  []string{"https", "http"},
+        }
+}
+
+// findConfigFile returns the config file in dataDir, or the default JSON
+// path if none exists yet.
+func findConfigFile(dataDir string) string {
+        var found []string
+        for _, name := range configFileNames {
+                path := filepath.Join(dataDir, name)
+                if _, err := os.Stat(path); err == nil {
+                        found = append(found, path)
+                }
+        }
+
+        if len(found) == 0 {
+                return filepath.Join(dataDir, configFileName)
+        }
+        if len(found) > 1 {
+                log.Printf("Warning: Several config files in %s; using %s", dataDir, filepath.Base(found[0]))
+        }
+        return found[0]
+}
+
+// configFormat picks the file format from the extension of path.
+func configFormat(path string) string {
+        switch strings.ToLower(filepath.Ext(path)) {
+        case ".yaml", ".yml":
+                return "yaml"
+        case ".toml":
+                return "toml"
+        default:
+                return "json"
+        }
+}
+
+// decodeConfigFile converts a config file in the given format into JSON
+// fields so every format goes through the same decoding and validation.
+func decodeConfigFile(format string, data []byte) (map[string]json.RawMessage, error) {
+        var doc map[string]interface{}
+
+        switch format {
+        case "yaml":
+                if err := yaml.Unmarshal(data, &doc); err != nil {
+                        return nil, err
+                }
+        case "toml":
+                if err := toml.Unmarshal(data, &doc); err != nil {
+                        return nil, err
+                }
+        default:
+                fields := map[string]json.RawMessage{}
+                if err := json.Unmarshal(data, &fields); err != nil {
+                        return nil, err
+                }
+                return fields, nil
+        }
+
+        fields := map[string]json.RawMessage{}
+        for key, value := range doc {
+                raw, err := json.Marshal(value)
+                if err != nil {
+                        return nil, fmt.Errorf("%s: %v", key, err)
+                }
+                fields[key] = raw
+        }
+        return fields, nil
+}
+
+// encodeConfigFile renders config fields in the given format.
+func encodeConfigFile(format string, fields map[string]json.RawMessage) ([]byte, error) {
+        if format == "json" {
+                return json.MarshalIndent(fields, "", "  ")
+        }
+
+        doc := map[string]interface{}{}
+        for key, raw := range fields {
+                dec := json.NewDecoder(bytes.NewReader(raw))
+                dec.UseNumber()
+                var value interface{}
+                if err := dec.Decode(&value); err != nil {
+                        return nil, err
+                }
+                doc[key] = plainJSONNumbers(value)
+        }
+
+        var buf bytes.Buffer
+        switch format {
+        case "yaml":
+                enc := yaml.NewEncoder(&buf)
+                enc.SetIndent(2)
+                if err := enc.Encode(doc); err != nil {
+                        return nil, err
+                }
+        case "toml":
+                if err := toml.NewEncoder(&buf).Encode(doc); err != nil {
+                        return nil, err
+                }
+        }
+        return buf.Bytes(), nil
+}
+
+// plainJSONNumbers turns json.Number values into int64 or float64 so YAML and
+// TOML write integers without a decimal point.
+func plainJSONNumbers(v interface{}) interface{} {
+        switch value := v.(type) {
+        case json.Number:
+                if n, err := value.Int64(); err == nil {
+                        return n
+                }
+                f, _ := value.Float64()
+                return f
+        case map[string]interface{}:
+                for key, item := range value {
+                        value[key] = plainJSONNumbers(item)
+                }
+        case []interface{}:
+                for i, item := range value {
+                        value[i] = plainJSONNumbers(item)
+                }
+        }
+        return v
+}
+
+// envConfigLayer reads CAMROID_<FIELD> variables. Booleans and integers use
+// Go syntax, lists are comma-separated and objects are JSON. PROFILES,
+// UNLOCK_TOTP_SECRET and PROXY_CREDENTIALS are managed through the admin
+// API only and CONFIG_VERSION by the server.
+func envConfigLayer() (map[string]json.RawMessage, map[string]string, error) {
+        fields := map[string]json.RawMessage{}
+        vars := map[string]string{}
+
+        t := reflect.TypeOf(AppConfig{})
+        for i := 0; i < t.NumField(); i++ {
+                field := t.Field(i)
+                name := strings.Split(field.Tag.Get("json"), ",")[0]
+                if name == "" || name == "-" || name == "PROFILES" || name == "CONFIG_VERSION" || name == "UNLOCK_TOTP_SECRET" || name == "PROXY_CREDENTIALS" {
+                        continue
+                }
+
+                envVar := configEnvPrefix + name
+                value, ok := os.LookupEnv(envVar)
+                if !ok {
+                        continue
+                }
+
+                var raw json.RawMessage
+                switch field.Type.Kind() {
+                case reflect.Bool:
+                        b, err := strconv.ParseBool(strings.TrimSpace(value))
+                        if err != nil {
+                                return nil, nil, fmt.Errorf("%s: expected true or false", envVar)
+                        }
+                        raw, _ = json.Marshal(b)
+                case reflect.Int:
+                        n, err := strconv.Atoi(strings.TrimSpace(value))
+                        if err != nil {
+                                return nil, nil, fmt.Errorf("%s: expected an integer", envVar)
+                        }
+                        raw, _ = json.Marshal(n)
+                case reflect.String:
+                        raw, _ = json.Marshal(value)
+                case reflect.Slice:
+                        // A JSON array allows entries that are objects,
+                        // such as ALLOWED_PROXY_HOSTS policies.
+                        if strings.HasPrefix(strings.TrimSpace(value), "[") {
+                                if !json.Valid([]byte(value)) {
+                                        return nil, nil, fmt.Errorf("%s: invalid JSON array", envVar)
+                                }
+                                raw = json.RawMessage(value)
+                                break
+                        }
+                        list := []string{}
+                        for _, item := range strings.Split(value, ",") {
+                                if item = strings.TrimSpace(item); item != "" {
+                                        list = append(list, item)
+                                }
+                        }
+                        raw, _ = json.Marshal(list)
+                default:
+                        if !json.Valid([]byte(value)) {
+                                return nil, nil, fmt.Errorf("%s: expected a JSON object", envVar)
+                        }
+                        raw = json.RawMessage(value)
+                }
+
+                fields[name] = raw
+                vars[name] = envVar
+        }
+
+        return fields, vars, nil
+}
+
+// hashFileLayerSecrets hashes plaintext unlock secrets in the file layer in
+// place. It reports whether the file needs rewriting.
+func hashFileLayerSecrets(file map[string]json.RawMessage) (bool, error) {
+        secretFields := []string{"UNLOCK_PATTERN", "MODULE_UNLOCK_VALUES", "MODULE_DURESS_VALUES", "PROFILES"}
+
+        subset := map[string]json.RawMessage{}
+        for _, key := range secretFields {
+                if raw, ok := file[key]; ok {
+                        subset[key] = raw
+                }
+        }
+        if len(subset) == 0 {
+                return false, nil
+        }
+
+        data, _ := json.Marshal(subset)
+        var cfg AppConfig
+        if err := json.Unmarshal(data, &cfg); err != nil {
+                return false, err
+        }
+
+        migrated, err := hashPlaintextSecrets(&cfg)
+        if err != nil || !migrated {
+                return false, err
+        }
+
+        data, err = json.Marshal(cfg)
+        if err != nil {
+                return false, err
+        }
+        var hashed map[string]json.RawMessage
+        if err := json.Unmarshal(data, &hashed); err != nil {
+                return false, err
+        }
+        for key := range subset {
+                file[key] = hashed[key]
+        }
+        return true, nil
+}
+
+// buildAppConfig layers a decoded config file and the environment over the
+// defaults. It reports whether plaintext secrets in the file were hashed.
+func buildAppConfig(file map[string]json.RawMessage) (AppConfig, configLayers, bool, error) {
+        var cfg AppConfig
+        layers := configLayers{file: file, sources: map[string]string{}}
+        if layers.file == nil {
+                layers.file = map[string]json.RawMessage{}
+        }
+
+        migrated, err := hashFileLayerSecrets(layers.file)
+        if err != nil {
+                return cfg, layers, false, err
+        }
+
+        env, envVars, err := envConfigLayer()
+        if err != nil {
+                return cfg, layers, false, err
+        }
+        layers.envVars = envVars
+
+        data, err := json.Marshal(defaultAppConfig())
+        if err != nil {
+                return cfg, layers, false, err
+        }
+        merged := map[string]json.RawMessage{}
+        if err := json.Unmarshal(data, &merged); err != nil {
+                return cfg, layers, false, err
+        }
+        for key := range merged {
+                layers.sources[key] = layerDefault
+        }
+        for key, raw := range layers.file {
+                merged[key] = raw
+                layers.sources[key] = layerFile
+        }
+        for key, raw := range env {
+                merged[key] = raw
+                layers.sources[key] = layerEnv
+        }
+
+        if data, err = json.Marshal(merged); err != nil {
+                return cfg, layers, false, err
+        }
+        if err := json.Unmarshal(data, &cfg); err != nil {
+                return cfg, layers, false, err
+        }
+
+        // Only the file layer is ever written back, so plaintext defaults and
+        // environment values are hashed on every load. The hashes are cached
+        // to keep the config, and its ETag, the same from one load to the
+        // next.
+        if _, err := hashPlaintextSecretsWith(&cfg, hashLayerSecret); err != nil {
+                return cfg, layers, false, err
+        }
+
+        return cfg, layers, migrated, nil
+}
+
+func setConfigLayers(layers configLayers) {
+        appConfigLock.Lock()
+        appConfigLayers = layers
+        appConfigLock.Unlock()
+}
+
+func currentConfigLayers() configLayers {
+        appConfigLock.RLock()
+        defer appConfigLock.RUnlock()
+        return appConfigLayers
+}
+
+// configFileFields returns the fields of cfg to persist. Fields pinned by the
+// environment keep whatever the file had, so env values never leak into it.
+// Callers hold appConfigLock.
+func configFileFields(cfg AppConfig, layers configLayers) (map[string]json.RawMessage, error) {
+        data, err := json.Marshal(cfg)
+        if err != nil {
+                return nil, err
+        }
+        fields := map[string]json.RawMessage{}
+        if err := json.Unmarshal(data, &fields); err != nil {
+                return nil, err
+        }
+
+        for key := range layers.envVars {
+                if raw, ok := layers.file[key]; ok {
+                        fields[key] = raw
+                } else {
+                        delete(fields, key)
+                }
+        }
+        return fields, nil
+}
+
+// markConfigFileSaved records that every field not pinned by the
+// environment now comes from the file.
+func markConfigFileSaved(fields map[string]json.RawMessage) {
+        appConfigLock.Lock()
+        defer appConfigLock.Unlock()
+
+        appConfigLayers.file = fields
+        for key := range fields {
+                if appConfigLayers.sources[key] != layerEnv {
+                        appConfigLayers.sources[key] = layerFile
+                }
+        }
+}
+
+// envPinnedErrors rejects updates to fields supplied by the environment.
+func envPinnedErrors(updates map[string]json.RawMessage) validationErrors {
+        appConfigLock.RLock()
+        defer appConfigLock.RUnlock()
+
+        var errs validationErrors
+        for key := range updates {
+                if envVar, ok := appConfigLayers.envVars[key]; ok {
+                        errs.add(key, "is set by the %s environment variable and cannot be changed through the API", envVar)
+                }
+        }
+        sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+        return errs
+}
+
+func handleConfigSources(w http.ResponseWriter, r *http.Request) {
+        appConfigLock.RLock()
+        fields := map[string]interface{}{}
+        for key, layer := range appConfigLayers.sources {
+                source := map[string]string{"layer": layer}
+                if layer == layerEnv {
+                        source["variable"] = appConfigLayers.envVars[key]
+                }
+                fields[key] = source
+        }
+        appConfigLock.RUnlock()
+
+        _, err := os.Stat(configPath)
+        writeJSON(w, http.StatusOK, map[string]interface{}{
+                "file":       configPath,
+                "format":     configFormat(configPath),
+                "fileExists": err == nil,
+                "fields":     fields,
+        })
+}
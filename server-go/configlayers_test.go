@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestBuildAppConfigStableSecrets(t *testing.T) {
+        t.Setenv(configEnvPrefix+"UNLOCK_PATTERN", "2-4-6-8")
+
+        first, _, _, err := buildAppConfig(nil)
+        if err != nil {
+                t.Fatal(err)
+        }
+        second, _, _, err := buildAppConfig(nil)
+        if err != nil {
+                t.Fatal(err)
+        }
+
+        if !verifySecret(first.UnlockPattern, "2-4-6-8") {
+                t.Fatalf("UNLOCK_PATTERN = %q, want the hashed env value", first.UnlockPattern)
+        }
+        if !verifySecret(first.ModuleUnlockValues["notepad"], "secret") {
+                t.Fatalf("notepad value = %q, want the hashed default", first.ModuleUnlockValues["notepad"])
+        }
+        if configETag(first) != configETag(second) {
+                t.Errorf("ETag changed between loads: %s, %s", configETag(first), configETag(second))
+        }
+}
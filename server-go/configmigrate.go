@@ -0,0 +1,239 @@
+package main
+
+import (
+        "encoding/json"
+        "fmt"
+        "log"
+        "os"
+        "strings"
+)
+
+// currentConfigVersion is the CONFIG_VERSION written by this server. Files
+// without the field are version 0.
+const currentConfigVersion = 1
+
+// configMigration upgrades a config file from version from to from+1. It
+// works on the raw file layer, so it can add, rename or drop keys that
+// AppConfig no longer knows about.
+type configMigration struct {
+        from        int
+        description string
+        apply       func(fields map[string]json.RawMessage) error
+}
+
+// configMigrations must stay ordered by from, one step per version.
+var configMigrations = []configMigration{
+        {
+                from:        0,
+                description: "fill in ORIGIN_VALIDATION defaults",
+                apply: func(fields map[string]json.RawMessage) error {
+                        var ov struct {
+                                Mode string `json:"mode"`
+                        }
+                        if raw, ok := fields["ORIGIN_VALIDATION"]; ok {
+                                if err := json.Unmarshal(raw, &ov); err != nil {
+                                        return fmt.Errorf("ORIGIN_VALIDATION: %v", err)
+                                }
+                        }
+                        if ov.Mode == "" {
+                                fields["ORIGIN_VALIDATION"], _ = json.Marshal(defaultOriginValidation())
+                        }
+                        return nil
+                },
+        },
+}
+
+// configFileVersion returns the CONFIG_VERSION of a decoded config file.
+func configFileVersion(fields map[string]json.RawMessage) (int, error) {
+        raw, ok := fields["CONFIG_VERSION"]
+        if !ok {
+                return 0, nil
+        }
+
+        var version int
+        if err := json.Unmarshal(raw, &version); err != nil || version < 0 {
+                return 0, fmt.Errorf("CONFIG_VERSION must be a non-negative integer")
+        }
+        return version, nil
+}
+
+// migrateConfigFields runs every migration newer than the file's version in
+// order and stamps the result with currentConfigVersion. It returns the
+// descriptions of the steps applied.
+func migrateConfigFields(fields map[string]json.RawMessage) ([]string, error) {
+        version, err := configFileVersion(fields)
+        if err != nil {
+                return nil, err
+        }
+        if version > currentConfigVersion {
+                return nil, fmt.Errorf("CONFIG_VERSION %d is newer than this server supports (%d)", version, currentConfigVersion)
+        }
+
+        var applied []string
+        for _, m := range configMigrations {
+                if m.from < version {
+                        continue
+                }
+                if err := m.apply(fields); err != nil {
+                        return nil, fmt.Errorf("migration %d -> %d: %v", m.from, m.from+1, err)
+                }
+                applied = append(applied, fmt.Sprintf("%d -> %d: %s", m.from, m.from+1, m.description))
+        }
+
+        if version != currentConfigVersion {
+                fields["CONFIG_VERSION"], _ = json.Marshal(currentConfigVersion)
+        }
+        return applied, nil
+}
+
+// persistMigratedConfig rewrites the config file after a load-time upgrade.
+// If CONFIG_VERSION goes up, the original is kept next to it as
+// <file>.v<version>.bak; rewrites that only hash secrets or encrypt the file
+// leave no backup.
+func persistMigratedConfig(path string, original []byte) error {
+        plain, _, err := openConfigData(original)
+        if err != nil {
+                return err
+        }
+
+        fields, err := decodeConfigFile(configFormat(path), plain)
+        if err != nil {
+                return err
+        }
+        version, err := configFileVersion(fields)
+        if err != nil {
+                return err
+        }
+        if version >= currentConfigVersion {
+                return saveAppConfig(path)
+        }
+
+        backup := fmt.Sprintf("%s.v%d.bak", path, version)
+        if err := writeConfigBackup(backup, configFormat(path), plain, fields); err != nil {
+                return fmt.Errorf("could not back up %s before migrating: %v", path, err)
+        }
+
+        log.Printf("Migrating %s to config version %d (backup: %s)", path, currentConfigVersion, backup)
+        return saveAppConfig(path)
+}
+
+// writeConfigBackup writes the pre-migration document to backup with any
+// plaintext unlock secrets hashed, so a backup never holds what the live
+// file no longer does. It is encrypted too if a key is configured.
+func writeConfigBackup(backup, format string, plain []byte, fields map[string]json.RawMessage) error {
+        hashed, err := hashFileLayerSecrets(fields)
+        if err != nil {
+                return err
+        }
+        if hashed {
+                if plain, err = encodeConfigFile(format, fields); err != nil {
+                        return err
+                }
+        }
+
+        sealed, err := sealConfigData(plain)
+        if err != nil {
+                return err
+        }
+        return writeFileAtomic(backup, sealed, 0600)
+}
+
+// migrationDryRun prints the changes loading path would make to it, without
+// writing anything. It returns the process exit code.
+func migrationDryRun(path string) int {
+        data, err := os.ReadFile(path)
+        if os.IsNotExist(err) {
+                fmt.Printf("%s does not exist; nothing to migrate\n", path)
+                return 0
+        }
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Could not read %s: %v\n", path, err)
+                return 1
+        }
+
+        format := configFormat(path)
+        plain, _, err := openConfigData(data)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Could not decrypt %s: %v\n", path, err)
+                return 1
+        }
+        fields, err := decodeConfigFile(format, plain)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Could not parse %s: %v\n", path, err)
+                return 1
+        }
+        version, _ := configFileVersion(fields)
+
+        cfg, layers, rewrite, err := parseAppConfig(format, data)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+                return 1
+        }
+
+        steps, _ := migrateConfigFields(fields)
+        fmt.Printf("%s: config version %d, current version %d\n", path, version, currentConfigVersion)
+        for _, step := range steps {
+                fmt.Printf("  migration %s\n", step)
+        }
+        if !rewrite {
+                fmt.Println("Up to date; nothing to migrate")
+                return 0
+        }
+
+        migrated, err := configFileFields(cfg, layers)
+        var after []byte
+        if err == nil {
+                after, err = encodeConfigFile(format, migrated)
+        }
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "Could not render migrated config: %v\n", err)
+                return 1
+        }
+
+        fmt.Printf("--- %s\n+++ %s (migrated)\n", path, path)
+        fmt.Print(lineDiff(string(plain), string(after)))
+        return 0
+}
+
+// lineDiff renders a minimal line-based diff of two small documents, with
+// "-", "+" and " " prefixes.
+func lineDiff(a, b string) string {
+        x := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+        y := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+
+        // lcs[i][j] is the length of the longest common subsequence of x[i:]
+        // and y[j:].
+        lcs := make([][]int, len(x)+1)
+        for i := range lcs {
+                lcs[i] = make([]int, len(y)+1)
+        }
+        for i := len(x) - 1; i >= 0; i-- {
+                for j := len(y) - 1; j >= 0; j-- {
+                        if x[i] == y[j] {
+                                lcs[i][j] = lcs[i+1][j+1] + 1
+                        } else if lcs[i+1][j] >= lcs[i][j+1] {
+                                lcs[i][j] = lcs[i+1][j]
+                        } else {
+                                lcs[i][j] = lcs[i][j+1]
+                        }
+                }
+        }
+
+        var out strings.Builder
+        i, j := 0, 0
+        for i < len(x) || j < len(y) {
+                switch {
+                case i < len(x) && j < len(y) && x[i] == y[j]:
+                        out.WriteString("  " + x[i] + "\n")
+                        i++
+                        j++
+                case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+                        out.WriteString("- " + x[i] + "\n")
+                        i++
+                default:
+                        out.WriteString("+ " + y[j] + "\n")
+                        j++
+                }
+        }
+        return out.String()
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+        "encoding/json"
+        "os"
+        "path/filepath"
+        "strings"
+        "testing"
+)
+
+func TestPersistMigratedConfigBackup(t *testing.T) {
+        tests := []struct {
+                name       string
+                file       string
+                wantBackup bool
+                plaintext  []string // values that must not appear in any file
+        }{
+                {
+                        name:       "old version with plaintext secrets",
+                        file:       `{"UNLOCK_PATTERN": "2-4-6-8", "MODULE_UNLOCK_VALUES": {"calculator": "97531="}}`,
+                        wantBackup: true,
+                        plaintext:  []string{"2-4-6-8", "97531="},
+                },
+                {
+                        name:       "old version without secrets",
+                        file:       `{"AUTO_LOCK_MINUTES": 7}`,
+                        wantBackup: true,
+                },
+                {
+                        name:      "current version with plaintext secrets",
+                        file:      `{"CONFIG_VERSION": 1, "UNLOCK_PATTERN": "2-4-6-8"}`,
+                        plaintext: []string{"2-4-6-8"},
+                },
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        path := filepath.Join(t.TempDir(), "config.json")
+                        if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+                                t.Fatal(err)
+                        }
+                        if err := loadAppConfig(path); err != nil {
+                                t.Fatal(err)
+                        }
+
+                        backup := path + ".v0.bak"
+                        data, err := os.ReadFile(backup)
+                        if !tt.wantBackup {
+                                if !os.IsNotExist(err) {
+                                        t.Fatalf("backup %s exists, want none", filepath.Base(backup))
+                                }
+                        } else if err != nil {
+                                t.Fatalf("backup: %v", err)
+                        }
+
+                        live, err := os.ReadFile(path)
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        for _, secret := range tt.plaintext {
+                                if strings.Contains(string(data), secret) || strings.Contains(string(live), secret) {
+                                        t.Errorf("plaintext %q written to disk", secret)
+                                }
+                        }
+                        if !tt.wantBackup {
+                                return
+                        }
+
+                        var fields map[string]json.RawMessage
+                        if err := json.Unmarshal(data, &fields); err != nil {
+                                t.Fatalf("backup is not JSON: %v", err)
+                        }
+                        if _, ok := fields["CONFIG_VERSION"]; ok {
+                                t.Errorf("backup has CONFIG_VERSION, want the original version 0 document")
+                        }
+                        var original map[string]json.RawMessage
+                        json.Unmarshal([]byte(tt.file), &original)
+                        for key := range original {
+                                if _, ok := fields[key]; !ok {
+                                        t.Errorf("backup lost %s", key)
+                                }
+                        }
+                        var pattern string
+                        if raw, ok := fields["UNLOCK_PATTERN"]; ok {
+                                json.Unmarshal(raw, &pattern)
+                                if !verifySecret(pattern, "2-4-6-8") {
+                                        t.Errorf("backup UNLOCK_PATTERN = %q, want a hash of the original", pattern)
+                                }
+                        }
+                })
+        }
+}
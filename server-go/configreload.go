@@ -0,0 +1,150 @@
+package main
+
+import (
+        "crypto/sha256"
+        "fmt"
+        "log"
+        "os"
+        "os/signal"
+        "sync"
+        "syscall"
+        "time"
+)
+
+// configFileState is what the server last read from or wrote to the config
+// file. The watcher compares it against the file on disk to spot edits made
+// outside the API.
+type configFileState struct {
+        modTime time.Time
+        size    int64
+        hash    [sha256.Size]byte
+}
+
+var (
+        configFileMu    sync.Mutex
+        configFileKnown configFileState
+)
+
+func rememberConfigFile(path string, data []byte) {
+        state := configFileState{size: int64(len(data)), hash: sha256.Sum256(data)}
+        if info, err := os.Stat(path); err == nil {
+                state.modTime = info.ModTime()
+        }
+
+        configFileMu.Lock()
+        configFileKnown = state
+        configFileMu.Unlock()
+}
+
+// configFileChanged reports whether the file differs from what the server
+// knows. The cheap mtime/size check runs first; the hash confirms a change
+// so that a touch without edits does not trigger a reload.
+func configFileChanged(path string) (bool, []byte, error) {
+        info, err := os.Stat(path)
+        if err != nil {
+                return false, nil, err
+        }
+
+        configFileMu.Lock()
+        known := configFileKnown
+        configFileMu.Unlock()
+
+        if info.ModTime().Equal(known.modTime) && info.Size() == known.size {
+                return false, nil, nil
+        }
+
+        data, err := os.ReadFile(path)
+        if err != nil {
+                return false, nil, err
+        }
+
+        if sha256.Sum256(data) == known.hash {
+                rememberConfigFile(path, data)
+                return false, nil, nil
+        }
+
+        return true, data, nil
+}
+
+// reloadAppConfig validates data and swaps it in as the live config. If the
+// new document is invalid the current config stays active. Callers hold
+// configUpdateLock.
+func reloadAppConfig(path string, data []byte, reason string) error {
+        cfg, layers, migrated, err := parseAppConfig(configFormat(path), data)
+        if err != nil {
+                return fmt.Errorf("parse: %v", err)
+        }
+
+        if errs := validateAppConfig(&cfg); len(errs) > 0 {
+                for _, e := range errs {
+                        log.Printf("Config reload (%s): %s %s", reason, e.Field, e.Message)
+                }
+                return fmt.Errorf("invalid config (field errors logged above)")
+        }
+
+        before := currentAppConfig()
+        setConfigLayers(layers)
+        setAppConfig(cfg)
+        rememberConfigFile(path, data)
+        log.Printf("Config reloaded from %s (%s)", path, reason)
+        auditConfigChange(nil, "config.reload", before, cfg)
+
+        if migrated {
+                return persistMigratedConfig(path, data)
+        }
+
+        return nil
+}
+
+// reloadIfChanged picks up external edits before an API write so the write
+// does not clobber them. Callers hold configUpdateLock.
+func reloadIfChanged(path, reason string) {
+        changed, data, err := configFileChanged(path)
+        if err != nil {
+                if !os.IsNotExist(err) {
+                        log.Printf("Warning: Could not check %s: %v", path, err)
+                }
+                return
+        }
+        if !changed {
+                return
+        }
+
+        if err := reloadAppConfig(path, data, reason); err != nil {
+                log.Printf("Warning: Keeping current config; reload failed: %v", err)
+                rememberConfigFile(path, data)
+        }
+}
+
+// watchConfigFile reloads the config when the file changes on disk (polled
+// every interval, 0 disables polling) or when the process receives SIGHUP.
+func watchConfigFile(path string, interval time.Duration) {
+        hup := make(chan os.Signal, 1)
+        signal.Notify(hup, syscall.SIGHUP)
+
+        var tick <-chan time.Time
+        if interval > 0 {
+                ticker := time.NewTicker(interval)
+                defer ticker.Stop()
+                tick = ticker.C
+        }
+
+        for {
+                select {
+                case <-tick:
+                        configUpdateLock.Lock()
+                        reloadIfChanged(path, "file changed")
+                        configUpdateLock.Unlock()
+
+                case <-hup:
+                        configUpdateLock.Lock()
+                        data, err := os.ReadFile(path)
+                        if err != nil {
+                                log.Printf("Warning: SIGHUP reload failed: %v", err)
+                        } else if err := reloadAppConfig(path, data, "SIGHUP"); err != nil {
+                                log.Printf("Warning: Keeping current config; reload failed: %v", err)
+                        }
+                        configUpdateLock.Unlock()
+                }
+        }
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+        "crypto/sha256"
+        "encoding/hex"
+        "encoding/json"
+        "fmt"
+        "log"
+        "net/http"
+        "os"
+        "path/filepath"
+        "regexp"
+        "sort"
+        "strings"
+        "sync"
+        "time"
+)
+
+const (
+        revisionsDirName = "revisions"
+        revisionIDLayout = "20060102T150405.000000000Z"
+)
+
+var (
+        configRevisionLimit = 10
+        configSaveLock      sync.Mutex
+        configUpdateLock    sync.Mutex
+        appConfigETag       string
+        revisionIDPattern   = regexp.MustCompile(`^\d{8}T\d{6}\.\d{9}Z$`)
+)
+
+type configRevision struct {
+        ID        string    `json:"id"`
+        Timestamp time.Time `json:"timestamp"`
+        Size      int64     `json:"size"`
+}
+
+// writeFileAtomic writes data to a temp file in the target directory, syncs
+// it and renames it over path, so readers never observe a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+        dir := filepath.Dir(path)
+
+        tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+        if err != nil {
+                return err
+        }
+        tmpPath := tmp.Name()
+        defer os.Remove(tmpPath)
+
+        if err := tmp.Chmod(perm); err != nil {
+                tmp.Close()
+                return err
+        }
+        if _, err := tmp.Write(data); err != nil {
+                tmp.Close()
+                return err
+        }
+        if err := tmp.Sync(); err != nil {
+                tmp.Close()
+                return err
+        }
+        if err := tmp.Close(); err != nil {
+                return err
+        }
+
+        if err := os.Rename(tmpPath, path); err != nil {
+                return err
+        }
+
+        if d, err := os.Open(dir); err == nil {
+                d.Sync()
+                d.Close()
+        }
+
+        return nil
+}
+
+func revisionsDir(path string) string {
+        return filepath.Join(filepath.Dir(path), revisionsDirName)
+}
+
+// recordRevision stores a timestamped copy of a saved config and prunes all
+// but the newest configRevisionLimit copies.
+func recordRevision(path string, data []byte) error {
+        if configRevisionLimit <= 0 {
+                return nil
+        }
+
+        dir := revisionsDir(path)
+        if err := os.MkdirAll(dir, 0700); err != nil {
+                return err
+        }
+
+        id := time.Now().UTC().Format(revisionIDLayout)
+        if err := writeFileAtomic(filepath.Join(dir, id+".json"), data, 0600); err != nil {
+                return err
+        }
+
+        revisions, err := listRevisions(path)
+        if err != nil {
+                return err
+        }
+        for _, rev := range revisions[min(len(revisions), configRevisionLimit):] {
+                if err := os.Remove(filepath.Join(dir, rev.ID+".json")); err != nil {
+                        log.Printf("Warning: Could not prune config revision %s: %v", rev.ID, err)
+                }
+        }
+
+        return nil
+}
+
+// listRevisions returns the stored revisions, newest first.
+func listRevisions(path string) ([]configRevision, error) {
+        entries, err := os.ReadDir(revisionsDir(path))
+        if os.IsNotExist(err) {
+                return []configRevision{}, nil
+        }
+        if err != nil {
+                return nil, err
+        }
+
+        revisions := []configRevision{}
+        for _, entry := range entries {
+                id := strings.TrimSuffix(entry.Name(), ".json")
+                if entry.IsDir() || !revisionIDPattern.MatchString(id) {
+                        continue
+                }
+
+                ts, err := time.Parse(revisionIDLayout, id)
+                if err != nil {
+                        continue
+                }
+
+                info, err := entry.Info()
+                if err != nil {
+                        continue
+                }
+
+                revisions = append(revisions, configRevision{ID: id, Timestamp: ts, Size: info.Size()})
+        }
+
+        sort.Slice(revisions, func(i, j int) bool {
+                return revisions[i].ID > revisions[j].ID
+        })
+
+        return revisions, nil
+}
+
+func readRevision(path, id string) ([]byte, error) {
+        if !revisionIDPattern.MatchString(id) {
+                return nil, fmt.Errorf("invalid revision id %q", id)
+        }
+        return os.ReadFile(filepath.Join(revisionsDir(path), id+".json"))
+}
+
+// configETag identifies a config revision by the digest of its contents, so
+// it is stable across restarts and changes with every saved edit.
+func configETag(cfg AppConfig) string {
+        data, _ := json.Marshal(cfg)
+        sum := sha256.Sum256(data)
+        return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// setAppConfig swaps in cfg as the live config, updates its ETag and wakes
+// config stream subscribers.
+func setAppConfig(cfg AppConfig) {
+        etag := configETag(cfg)
+
+        appConfigLock.Lock()
+        appConfig = cfg
+        appConfigETag = etag
+        appConfigLock.Unlock()
+
+        notifyConfigChanged()
+}
+
+// commitAppConfig hashes any plaintext unlock secrets in a validated config,
+// persists it, swaps it in as the live config and records the change made by
+// r in the audit log. If it cannot be saved, the live config is unchanged and
+// subscribers are not notified.
+func commitAppConfig(r *http.Request, action string, cfg AppConfig) error {
+        if _, err := hashPlaintextSecrets(&cfg); err != nil {
+                return err
+        }
+
+        before := currentAppConfig()
+        if err := saveConfig(configPath, cfg); err != nil {
+                return err
+        }
+        setAppConfig(cfg)
+
+        auditConfigChange(r, action, before, cfg)
+        return nil
+}
+
+func ifMatchSatisfied(header, etag string) bool {
+        if header == "" {
+                return true
+        }
+        for _, tag := range strings.Split(header, ",") {
+                tag = strings.TrimSpace(tag)
+                if tag == "*" || tag == etag {
+                        return true
+                }
+        }
+        return false
+}
+
+// beginConfigUpdate serializes read-modify-write cycles on the config and
+// enforces If-Match. On success it returns the current config and a release
+// func the caller must invoke once the update is committed or abandoned.
+func beginConfigUpdate(w http.ResponseWriter, r *http.Request) (AppConfig, func(), bool) {
+        configUpdateLock.Lock()
+
+        reloadIfChanged(configPath, "changed on disk before update")
+
+        cfg, etag := snapshotAppConfig()
+        if !ifMatchSatisfied(r.Header.Get("If-Match"), etag) {
+                configUpdateLock.Unlock()
+                w.Header().Set("ETag", etag)
+                writeJSONError(w, http.StatusPreconditionFailed, "Config has changed since it was read; fetch it again and retry")
+                return AppConfig{}, nil, false
+        }
+
+        return cfg, configUpdateLock.Unlock, true
+}
+
+func handleConfigRevisions(w http.ResponseWriter, r *http.Request) {
+        revisions, err := listRevisions(configPath)
+        if err != nil {
+                writeJSONError(w, http.StatusInternalServerError, "Failed to list config revisions")
+                return
+        }
+
+        writeJSON(w, http.StatusOK, map[string]interface{}{
+                "limit":     configRevisionLimit,
+                "revisions": revisions,
+        })
+}
+
+func handleConfigRollback(w http.ResponseWriter, r *http.Request) {
+        var req struct {
+                Revision string `json:"revision"`
+        }
+
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Revision == "" {
+                writeJSONError(w, http.StatusBadRequest, "revision is required")
+                return
+        }
+
+        _, release, ok := beginConfigUpdate(w, r)
+        if !ok {
+                return
+        }
+        defer release()
+
+        data, err := readRevision(configPath, req.Revision)
+        if os.IsNotExist(err) {
+                writeJSONError(w, http.StatusNotFound, "Unknown revision")
+                return
+        }
+        if err != nil {
+                writeJSONError(w, http.StatusBadRequest, err.Error())
+                return
+        }
+
+        // Revisions hold the file layer; environment overrides still apply.
+        cfg, layers, _, err := parseAppConfig("json", data)
+        if err != nil {
+                writeJSONError(w, http.StatusUnprocessableEntity, "Revision is not a valid config document")
+                return
+        }
+
+        if errs := validateAppConfig(&cfg); len(errs) > 0 {
+                writeValidationErrors(w, errs)
+                return
+        }
+
+        previousLayers := currentConfigLayers()
+        setConfigLayers(layers)
+
+        if err := commitAppConfig(r, "config.rollback", cfg); err != nil {
+                setConfigLayers(previousLayers)
+                log.Printf("Failed to save config: %v", err)
+                writeJSONError(w, http.StatusInternalServerError, "Failed to save config")
+                return
+        }
+
+        log.Printf("Config rolled back to revision %s", req.Revision)
+        handleAdminConfigGet(w, r)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "strings"
+        "sync"
+        "time"
+)
+
+const (
+        streamHeartbeatInterval = 15 * time.Second
+        streamRetryMillis       = 5000
+)
+
+// configSubscribers are woken whenever setAppConfig swaps in a new config.
+// Each channel has a buffer of one, so a slow stream only ever has a single
+// pending wake-up and always sends the latest config.
+var (
+        configSubscribersMu sync.Mutex
+        configSubscribers   = map[chan struct{}]struct{}{}
+)
+
+func subscribeConfigChanges() (chan struct{}, func()) {
+        ch := make(chan struct{}, 1)
+
+        configSubscribersMu.Lock()
+        configSubscribers[ch] = struct{}{}
+        configSubscribersMu.Unlock()
+
+        return ch, func() {
+                configSubscribersMu.Lock()
+                delete(configSubscribers, ch)
+                configSubscribersMu.Unlock()
+        }
+}
+
+func notifyConfigChanged() {
+        configSubscribersMu.Lock()
+        defer configSubscribersMu.Unlock()
+
+        for ch := range configSubscribers {
+                select {
+                case ch <- struct{}{}:
+                default:
+                }
+        }
+}
+
+// handleConfigStream pushes the public config as Server-Sent Events: once on
+// connect and again after every change. Event ids are the config ETag, so a
+// client reconnecting with an up-to-date Last-Event-ID is not sent a
+// duplicate. The duress response for the device is applied as on GET; the
+// id changes with it, and an event carrying WIPE is always sent.
+func handleConfigStream(w http.ResponseWriter, r *http.Request) {
+        if r.Method != "GET" {
+                http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                return
+        }
+
+        rc := http.NewResponseController(w)
+        // The server-wide WriteTimeout would otherwise cut long-lived streams.
+        rc.SetWriteDeadline(time.Time{})
+
+        changes, unsubscribe := subscribeConfigChanges()
+        defer unsubscribe()
+
+        w.Header().Set("Content-Type", "text/event-stream")
+        w.Header().Set("Cache-Control", "no-cache")
+        w.Header().Set("X-Accel-Buffering", "no")
+        w.WriteHeader(http.StatusOK)
+
+        fmt.Fprintf(w, "retry: %d\n\n", streamRetryMillis)
+
+        lastID := r.Header.Get("Last-Event-ID")
+        send := func() error {
+                cfg, _, etag := resolvedAppConfig(r)
+                view := projectConfig(cfg, publicView)
+                applyDuressResponse(r, view)
+
+                id := strings.Trim(etag, `"`)
+                if view["DECOY_GALLERY"] == true {
+                        id += "-decoy"
+                }
+                if id == lastID && view["WIPE"] != true {
+                        return nil
+                }
+
+                data, err := json.Marshal(view)
+                if err != nil {
+                        return err
+                }
+
+                if _, err := fmt.Fprintf(w, "id: %s\nevent: config\ndata: %s\n\n", id, data); err != nil {
+                        return err
+                }
+                lastID = id
+                return nil
+        }
+
+        if err := send(); err != nil {
+                return
+        }
+        if err := rc.Flush(); err != nil {
+                return
+        }
+
+        heartbeat := time.NewTicker(streamHeartbeatInterval)
+        defer heartbeat.Stop()
+
+        for {
+                select {
+                case <-r.Context().Done():
+                        return
+                case <-changes:
+                        if err := send(); err != nil {
+                                return
+                        }
+                case <-heartbeat.C:
+                        if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+                                return
+                        }
+                }
+
+                if err := rc.Flush(); err != nil {
+                        return
+                }
+        }
+}
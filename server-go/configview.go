@@ -0,0 +1,67 @@
+package main
+
+import (
+        "reflect"
+        "strings"
+)
+
+// Field visibility is declared with a `visibility` struct tag on AppConfig:
+//
+//	visibility:"public"  returned to every client by GET /api/config
+//	visibility:"secret"  never returned by any endpoint
+//
+// Fields without the tag are private: only the admin view includes them.
+const (
+        visibilityPublic = "public"
+        visibilitySecret = "secret"
+)
+
+type configView int
+
+const (
+        publicView configView = iota
+        adminView
+)
+
+func currentAppConfig() AppConfig {
+        appConfigLock.RLock()
+        defer appConfigLock.RUnlock()
+        return appConfig
+}
+
+// snapshotAppConfig returns the live config together with its ETag.
+func snapshotAppConfig() (AppConfig, string) {
+        appConfigLock.RLock()
+        defer appConfigLock.RUnlock()
+        return appConfig, appConfigETag
+}
+
+// projectConfig returns the fields of cfg visible in the given view, keyed
+// by their JSON names.
+func projectConfig(cfg AppConfig, view configView) map[string]interface{} {
+        out := map[string]interface{}{}
+
+        v := reflect.ValueOf(cfg)
+        t := v.Type()
+        for i := 0; i < t.NumField(); i++ {
+                field := t.Field(i)
+                name := strings.Split(field.Tag.Get("json"), ",")[0]
+                if name == "" || name == "-" || !field.IsExported() {
+                        continue
+                }
+
+                switch field.Tag.Get("visibility") {
+                case visibilitySecret:
+                        continue
+                case visibilityPublic:
+                default:
+                        if view != adminView {
+                                continue
+                        }
+                }
+
+                out[name] = v.Field(i).Interface()
+        }
+
+        return out
+}
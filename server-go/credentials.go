@@ -0,0 +1,210 @@
+package main
+
+import (
+        "encoding/json"
+        "fmt"
+        "log"
+        "net/http"
+        "regexp"
+        "sort"
+        "strings"
+)
+
+// Provider credentials live in PROXY_CREDENTIALS, sealed like the TOTP
+// secret and set only through /api/admin/credentials, which never returns
+// them. PROXY_CREDENTIAL_RULES says which credential goes to which upstream
+// host and where: a query parameter, a header, or a "{secret}" placeholder
+// in the URL path.
+const secretPlaceholder = "{secret}"
+
+var (
+        credentialNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+        credentialLocations   = []string{"query", "header", "url"}
+)
+
+type CredentialRule struct {
+        Host       string `json:"host"`
+        Credential string `json:"credential"`
+        In         string `json:"in"`
+        Name       string `json:"name,omitempty"`
+        Template   string `json:"template,omitempty"`
+}
+
+func validateCredentialRules(cfg *AppConfig, errs *validationErrors) {
+        for name, value := range cfg.ProxyCredentials {
+                field := "PROXY_CREDENTIALS." + name
+                if !credentialNamePattern.MatchString(name) {
+                        errs.add(field, "name must match %s", credentialNamePattern)
+                }
+                if !isSealedSecret(value) {
+                        errs.add(field, "must be a sealed secret; set it through /api/admin/credentials")
+                }
+        }
+
+        for i, rule := range cfg.ProxyCredentialRules {
+                field := fmt.Sprintf("PROXY_CREDENTIAL_RULES[%d]", i)
+                if err := validateHost(rule.Host); err != nil {
+                        errs.add(field+".host", "%v", err)
+                }
+                if _, ok := cfg.ProxyCredentials[rule.Credential]; !ok {
+                        errs.add(field+".credential", "unknown credential %q", rule.Credential)
+                }
+                if !contains(credentialLocations, rule.In) {
+                        errs.add(field+".in", "must be one of %s", strings.Join(credentialLocations, ", "))
+                }
+                if (rule.In == "query" || rule.In == "header") && rule.Name == "" {
+                        errs.add(field+".name", "is required for %s credentials", rule.In)
+                }
+                if rule.Template != "" && !strings.Contains(rule.Template, secretPlaceholder) {
+                        errs.add(field+".template", "must contain %s", secretPlaceholder)
+                }
+        }
+}
+
+// hostCredentialRules returns the rules for an upstream host, if any.
+func hostCredentialRules(host string) ([]CredentialRule, map[string]string) {
+        appConfigLock.RLock()
+        defer appConfigLock.RUnlock()
+
+        var rules []CredentialRule
+        for _, rule := range appConfig.ProxyCredentialRules {
+                if strings.EqualFold(rule.Host, host) {
+                        rules = append(rules, rule)
+                }
+        }
+        return rules, appConfig.ProxyCredentials
+}
+
+// injectCredentials applies the credential rules for req's host, replacing
+// anything the client sent under the same name. It reports whether any
+// rule applied.
+func injectCredentials(req *http.Request) (bool, error) {
+        rules, vault := hostCredentialRules(req.URL.Host)
+        for _, rule := range rules {
+                secret, err := openSecret(vault[rule.Credential])
+                if err != nil {
+                        log.Printf("Warning: Could not open credential %s: %v", rule.Credential, err)
+                        return false, err
+                }
+
+                value := string(secret)
+                if rule.Template != "" {
+                        value = strings.ReplaceAll(rule.Template, secretPlaceholder, value)
+                }
+
+                switch rule.In {
+                case "query":
+                        query := req.URL.Query()
+                        query.Set(rule.Name, value)
+                        req.URL.RawQuery = query.Encode()
+                case "header":
+                        req.Header.Set(rule.Name, value)
+                case "url":
+                        req.URL.Path = strings.ReplaceAll(req.URL.Path, secretPlaceholder, value)
+                        req.URL.RawPath = ""
+                }
+        }
+        return len(rules) > 0, nil
+}
+
+// stripCredentialHeaders removes headers injected for host, so a redirect to
+// another host does not carry them along.
+func stripCredentialHeaders(req *http.Request, host string) {
+        rules, _ := hostCredentialRules(host)
+        for _, rule := range rules {
+                if rule.In == "header" {
+                        req.Header.Del(rule.Name)
+                }
+        }
+}
+
+// handleCredentials lists credential names (GET /api/admin/credentials),
+// stores a value (PUT /api/admin/credentials/{name} with {"value": "..."})
+// or deletes one (DELETE). Values are never returned.
+func handleCredentials(w http.ResponseWriter, r *http.Request) {
+        name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/admin/credentials"), "/")
+
+        if name == "" {
+                if r.Method != "GET" {
+                        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                        return
+                }
+                cfg, _ := snapshotAppConfig()
+                names := []string{}
+                for name := range cfg.ProxyCredentials {
+                        names = append(names, name)
+                }
+                sort.Strings(names)
+                writeJSON(w, http.StatusOK, map[string]interface{}{
+                        "credentials": names,
+                        "rules":       cfg.ProxyCredentialRules,
+                })
+                return
+        }
+
+        if r.Method != "PUT" && r.Method != "DELETE" {
+                http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                return
+        }
+        if !credentialNamePattern.MatchString(name) {
+                writeJSONError(w, http.StatusBadRequest, "Credential names are 1-64 lowercase letters, digits, '-' or '_'")
+                return
+        }
+
+        var sealed string
+        if r.Method == "PUT" {
+                var req struct {
+                        Value string `json:"value"`
+                }
+                if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Value == "" {
+                        writeJSONError(w, http.StatusBadRequest, "Body must be {\"value\": \"...\"}")
+                        return
+                }
+                var err error
+                if sealed, err = sealSecret([]byte(req.Value)); err != nil {
+                        log.Printf("Failed to seal credential: %v", err)
+                        writeJSONError(w, http.StatusInternalServerError, "Failed to store credential")
+                        return
+                }
+        }
+
+        current, release, ok := beginConfigUpdate(w, r)
+        if !ok {
+                return
+        }
+        defer release()
+
+        cfg, err := cloneAppConfig(current)
+        if err != nil {
+                writeJSONError(w, http.StatusInternalServerError, "Failed to read config")
+                return
+        }
+        if cfg.ProxyCredentials == nil {
+                cfg.ProxyCredentials = map[string]string{}
+        }
+
+        action := "credential.put"
+        if r.Method == "DELETE" {
+                if _, ok := cfg.ProxyCredentials[name]; !ok {
+                        writeJSONError(w, http.StatusNotFound, "Unknown credential")
+                        return
+                }
+                action = "credential.delete"
+                delete(cfg.ProxyCredentials, name)
+        } else {
+                cfg.ProxyCredentials[name] = sealed
+        }
+
+        if errs := validateAppConfig(&cfg); len(errs) > 0 {
+                writeValidationErrors(w, errs)
+                return
+        }
+
+        if err := commitAppConfig(r, action, cfg); err != nil {
+                log.Printf("Failed to save config: %v", err)
+                writeJSONError(w, http.StatusInternalServerError, "Failed to save config")
+                return
+        }
+
+        w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+        "fmt"
+        "log"
+        "os"
+        "path/filepath"
+        "strings"
+)
+
+// configFileName is the name of the live config inside the data directory.
+// The data directory must live outside the static web root.
+const configFileName = "config.json"
+
+// sensitiveStaticFiles are never served from the static directory, even if
+// they exist there (for example a config.json left over from older builds).
+var sensitiveStaticFiles = map[string]bool{
+        "config.json": true,
+        "config.yaml": true,
+        "config.yml":  true,
+        "config.toml": true,
+}
+
+var sensitiveStaticExts = map[string]bool{
+        ".bak": true,
+        ".key": true,
+        ".pem": true,
+        ".tmp": true,
+}
+
+// isSensitiveStaticPath reports whether a path relative to the static root
+// points at config, key material, backups or dotfiles.
+func isSensitiveStaticPath(relPath string) bool {
+        for _, segment := range strings.Split(filepath.ToSlash(relPath), "/") {
+                if strings.HasPrefix(segment, ".") && segment != "." {
+                        return true
+                }
+        }
+
+        base := strings.ToLower(filepath.Base(relPath))
+        return sensitiveStaticFiles[base] || sensitiveStaticExts[filepath.Ext(base)]
+}
+
+// prepareDataDir creates the data directory and refuses any location inside
+// the static directory, where spaHandler could serve its contents.
+func prepareDataDir(dataDir, staticDir string) error {
+        rel, err := filepath.Rel(staticDir, dataDir)
+        if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+                return fmt.Errorf("data directory %s must not be inside the static directory %s", dataDir, staticDir)
+        }
+
+        return os.MkdirAll(dataDir, 0700)
+}
+
+// migrateLegacyConfig moves a config.json found in the static directory into
+// the data directory. If both exist, the data directory copy wins.
+func migrateLegacyConfig(staticDir, path string) error {
+        legacyPath := filepath.Join(staticDir, configFileName)
+        data, err := os.ReadFile(legacyPath)
+        if os.IsNotExist(err) {
+                return nil
+        }
+        if err != nil {
+                return err
+        }
+
+        if _, err := os.Stat(path); err == nil {
+                log.Printf("Warning: Ignoring legacy %s; using %s. Delete the legacy file, it is no longer served.", legacyPath, path)
+                return nil
+        }
+
+        log.Printf("Warning: Found config in the static directory (%s); moving it to %s", legacyPath, path)
+
+        if err := writeFileAtomic(path, data, 0600); err != nil {
+                return err
+        }
+
+        if err := os.Remove(legacyPath); err != nil {
+                log.Printf("Warning: Could not remove legacy config %s: %v", legacyPath, err)
+        }
+
+        return nil
+}
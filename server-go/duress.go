@@ -0,0 +1,233 @@
+package main
+
+import (
+        "bytes"
+        "encoding/json"
+        "log"
+        "net/http"
+        "os"
+        "sort"
+        "sync"
+        "time"
+)
+
+// A duress value unlocks like the module's real value, so the client cannot
+// tell them apart, but it also marks the device as under duress. The
+// responses enabled in DURESS_RESPONSE then apply to that device until an
+// admin clears it: the decoy gallery flag and wipe instruction are added to
+// its config fetches and config stream, and a webhook is notified once per
+// event. Devices are known only by X-Device-Id; without it the event is
+// still logged and reported, but no device is marked.
+const (
+        duressStateFile      = "duress.json"
+        duressWebhookTimeout = 10 * time.Second
+)
+
+type DuressResponseConfig struct {
+        DecoyGallery bool   `json:"decoyGallery"`
+        Wipe         bool   `json:"wipe"`
+        WebhookURL   string `json:"webhookUrl"`
+}
+
+type duressState struct {
+        Module       string     `json:"module"`
+        Since        time.Time  `json:"since"`
+        SourceIP     string     `json:"sourceIp"`
+        DecoyGallery bool       `json:"decoyGallery,omitempty"`
+        Wipe         bool       `json:"wipe,omitempty"`
+        WipeSentAt   *time.Time `json:"wipeSentAt,omitempty"`
+}
+
+var (
+        duressMu       sync.Mutex
+        duressPath     string
+        duressByDevice = map[string]*duressState{}
+)
+
+func loadDuressState(path string) error {
+        duressMu.Lock()
+        defer duressMu.Unlock()
+
+        duressPath = path
+        data, err := os.ReadFile(path)
+        if os.IsNotExist(err) {
+                return nil
+        }
+        if err != nil {
+                return err
+        }
+
+        plain, _, err := openConfigData(data)
+        if err != nil {
+                return err
+        }
+        if err := json.Unmarshal(plain, &duressByDevice); err != nil {
+                return err
+        }
+        if duressByDevice == nil {
+                duressByDevice = map[string]*duressState{}
+        }
+        return nil
+}
+
+// saveDuressState persists the device states. Callers hold duressMu.
+func saveDuressState() {
+        if duressPath == "" {
+                return
+        }
+
+        data, err := json.MarshalIndent(duressByDevice, "", "  ")
+        if err == nil {
+                data, err = sealConfigData(data)
+        }
+        if err == nil {
+                err = writeFileAtomic(duressPath, data, 0600)
+        }
+        if err != nil {
+                log.Printf("Warning: Could not save duress state: %v", err)
+        }
+}
+
+// duressDeviceKey identifies the device by X-Device-Id. A client IP would be
+// shared by every device behind the same NAT, so there is no fallback.
+func duressDeviceKey(r *http.Request) (string, bool) {
+        device := requestDeviceID(r)
+        if device == "" {
+                return "", false
+        }
+        return "device:" + device, true
+}
+
+// recordDuress marks the requesting device as under duress, writes an audit
+// entry and fires the webhook if one is configured.
+func recordDuress(r *http.Request, module string, cfg AppConfig) {
+        device, identified := duressDeviceKey(r)
+        ip := clientIP(r)
+        now := time.Now().UTC()
+        response := cfg.DuressResponse
+
+        if identified {
+                duressMu.Lock()
+                duressByDevice[device] = &duressState{
+                        Module:       module,
+                        Since:        now,
+                        SourceIP:     ip,
+                        DecoyGallery: response.DecoyGallery,
+                        Wipe:         response.Wipe,
+                }
+                saveDuressState()
+                duressMu.Unlock()
+
+                // Open config streams pick up the new state right away.
+                notifyConfigChanged()
+                log.Printf("Warning: Duress unlock for %s from %s", module, device)
+        } else {
+                device = "unknown"
+                log.Printf("Warning: Duress unlock for %s from %s without %s; no device was marked", module, ip, deviceIDHeader)
+        }
+
+        entry := auditEntry{
+                Action:   "unlock.duress",
+                SourceIP: ip,
+                Changes: []auditChange{
+                        {Field: "device", New: device},
+                        {Field: "module", New: module},
+                },
+        }
+        if err := appendAudit(entry); err != nil {
+                log.Printf("Warning: Could not write audit log entry for unlock.duress: %v", err)
+        }
+
+        if response.WebhookURL != "" {
+                go sendDuressWebhook(response.WebhookURL, map[string]interface{}{
+                        "event":    "unlock.duress",
+                        "module":   module,
+                        "device":   device,
+                        "sourceIp": ip,
+                        "time":     now,
+                })
+        }
+}
+
+func sendDuressWebhook(url string, payload map[string]interface{}) {
+        body, err := json.Marshal(payload)
+        if err != nil {
+                return
+        }
+
+        client := &http.Client{Timeout: duressWebhookTimeout}
+        resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+        if err != nil {
+                log.Printf("Warning: Duress webhook failed: %v", err)
+                return
+        }
+        resp.Body.Close()
+        if resp.StatusCode >= 300 {
+                log.Printf("Warning: Duress webhook returned %s", resp.Status)
+        }
+}
+
+// applyDuressResponse adds DECOY_GALLERY and WIPE to the public config sent
+// to a device under duress. The wipe instruction is sent on one fetch or
+// stream event only.
+func applyDuressResponse(r *http.Request, view map[string]interface{}) {
+        device, ok := duressDeviceKey(r)
+        if !ok {
+                return
+        }
+
+        duressMu.Lock()
+        defer duressMu.Unlock()
+
+        state, ok := duressByDevice[device]
+        if !ok {
+                return
+        }
+
+        if state.DecoyGallery {
+                view["DECOY_GALLERY"] = true
+        }
+        if state.Wipe && state.WipeSentAt == nil {
+                view["WIPE"] = true
+                now := time.Now().UTC()
+                state.WipeSentAt = &now
+                saveDuressState()
+        }
+}
+
+// handleDuress lists devices under duress; DELETE clears them all or only the
+// one named by ?device=.
+func handleDuress(w http.ResponseWriter, r *http.Request) {
+        duressMu.Lock()
+        defer duressMu.Unlock()
+
+        switch r.Method {
+        case "GET":
+                type entry struct {
+                        Device string `json:"device"`
+                        duressState
+                }
+                entries := []entry{}
+                for device, state := range duressByDevice {
+                        entries = append(entries, entry{Device: device, duressState: *state})
+                }
+                sort.Slice(entries, func(i, j int) bool { return entries[i].Device < entries[j].Device })
+                writeJSON(w, http.StatusOK, map[string]interface{}{"devices": entries})
+
+        case "DELETE":
+                if device := r.URL.Query().Get("device"); device != "" {
+                        if _, ok := duressByDevice[device]; !ok {
+                                writeJSONError(w, http.StatusNotFound, "Unknown device")
+                                return
+                        }
+                        delete(duressByDevice, device)
+                } else {
+                        duressByDevice = map[string]*duressState{}
+                }
+                saveDuressState()
+                w.WriteHeader(http.StatusNoContent)
+
+        default:
+                http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        }
+}
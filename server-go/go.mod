@@ -1,3 +1,10 @@
 module camroid-server
 
 go 1.21
+
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/crypto v0.9.0
+	gopkg.in/yaml.v3 v3.0.1
+)
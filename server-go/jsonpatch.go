@@ -0,0 +1,288 @@
+package main
+
+import (
+        "encoding/json"
+        "fmt"
+        "reflect"
+        "strconv"
+        "strings"
+)
+
+// applyMergePatch applies an RFC 7396 JSON Merge Patch to target.
+func applyMergePatch(target, patch interface{}) interface{} {
+        patchObj, ok := patch.(map[string]interface{})
+        if !ok {
+                return patch
+        }
+
+        targetObj, ok := target.(map[string]interface{})
+        if !ok {
+                targetObj = map[string]interface{}{}
+        }
+
+        for key, value := range patchObj {
+                if value == nil {
+                        delete(targetObj, key)
+                        continue
+                }
+                targetObj[key] = applyMergePatch(targetObj[key], value)
+        }
+
+        return targetObj
+}
+
+type jsonPatchOp struct {
+        Op    string         `json:"op"`
+        Path  string         `json:"path"`
+        From  *string        `json:"from"`
+        Value jsonPatchValue `json:"value"`
+}
+
+// jsonPatchValue records whether an operation had a "value" member apart
+// from its content, so that "value": null is a value rather than a missing
+// one.
+type jsonPatchValue struct {
+        Set bool
+        Raw json.RawMessage
+}
+
+func (v *jsonPatchValue) UnmarshalJSON(data []byte) error {
+        v.Set = true
+        v.Raw = append(v.Raw[:0], data...)
+        return nil
+}
+
+// applyJSONPatch applies an RFC 6902 JSON Patch to doc. The operations are
+// applied in order and the whole patch fails if any operation fails.
+func applyJSONPatch(doc interface{}, ops []jsonPatchOp) (interface{}, error) {
+        for i, op := range ops {
+                var err error
+                doc, err = applyJSONPatchOp(doc, op)
+                if err != nil {
+                        return nil, fmt.Errorf("operation %d (%s %s): %v", i, op.Op, op.Path, err)
+                }
+        }
+        return doc, nil
+}
+
+func applyJSONPatchOp(doc interface{}, op jsonPatchOp) (interface{}, error) {
+        path, err := parseJSONPointer(op.Path)
+        if err != nil {
+                return nil, err
+        }
+
+        var value interface{}
+        if op.Op == "add" || op.Op == "replace" || op.Op == "test" {
+                if !op.Value.Set {
+                        return nil, fmt.Errorf("missing value")
+                }
+                if err := json.Unmarshal(op.Value.Raw, &value); err != nil {
+                        return nil, err
+                }
+        }
+
+        switch op.Op {
+        case "add":
+                return jsonPointerAdd(doc, path, value)
+        case "remove":
+                doc, _, err := jsonPointerRemove(doc, path)
+                return doc, err
+        case "replace":
+                if _, err := jsonPointerGet(doc, path); err != nil {
+                        return nil, err
+                }
+                if len(path) == 0 {
+                        return value, nil
+                }
+                if doc, _, err = jsonPointerRemove(doc, path); err != nil {
+                        return nil, err
+                }
+                return jsonPointerAdd(doc, path, value)
+        case "move", "copy":
+                if op.From == nil {
+                        return nil, fmt.Errorf("missing from")
+                }
+                from, err := parseJSONPointer(*op.From)
+                if err != nil {
+                        return nil, err
+                }
+                if op.Op == "move" && strings.HasPrefix(op.Path+"/", *op.From+"/") && op.Path != *op.From {
+                        return nil, fmt.Errorf("cannot move a value into one of its children")
+                }
+                moved, err := jsonPointerGet(doc, from)
+                if err != nil {
+                        return nil, err
+                }
+                if op.Op == "move" {
+                        if doc, _, err = jsonPointerRemove(doc, from); err != nil {
+                                return nil, err
+                        }
+                } else {
+                        moved = deepCopyJSON(moved)
+                }
+                return jsonPointerAdd(doc, path, moved)
+        case "test":
+                current, err := jsonPointerGet(doc, path)
+                if err != nil {
+                        return nil, err
+                }
+                if !reflect.DeepEqual(current, value) {
+                        return nil, fmt.Errorf("test failed")
+                }
+                return doc, nil
+        default:
+                return nil, fmt.Errorf("unknown op %q", op.Op)
+        }
+}
+
+// parseJSONPointer splits an RFC 6901 pointer into unescaped tokens.
+func parseJSONPointer(pointer string) ([]string, error) {
+        if pointer == "" {
+                return nil, nil
+        }
+        if !strings.HasPrefix(pointer, "/") {
+                return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+        }
+
+        tokens := strings.Split(pointer[1:], "/")
+        for i, token := range tokens {
+                tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+        }
+        return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+        if token == "-" && allowEnd {
+                return length, nil
+        }
+        idx, err := strconv.Atoi(token)
+        if err != nil || idx < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+                return 0, fmt.Errorf("invalid array index %q", token)
+        }
+        max := length - 1
+        if allowEnd {
+                max = length
+        }
+        if idx > max {
+                return 0, fmt.Errorf("array index %d out of range", idx)
+        }
+        return idx, nil
+}
+
+func jsonPointerGet(doc interface{}, path []string) (interface{}, error) {
+        current := doc
+        for _, token := range path {
+                switch node := current.(type) {
+                case map[string]interface{}:
+                        value, ok := node[token]
+                        if !ok {
+                                return nil, fmt.Errorf("path not found")
+                        }
+                        current = value
+                case []interface{}:
+                        idx, err := arrayIndex(token, len(node), false)
+                        if err != nil {
+                                return nil, err
+                        }
+                        current = node[idx]
+                default:
+                        return nil, fmt.Errorf("path not found")
+                }
+        }
+        return current, nil
+}
+
+// jsonPointerAdd returns doc with value added at path. Containers are updated
+// in place where possible; the returned root replaces doc.
+func jsonPointerAdd(doc interface{}, path []string, value interface{}) (interface{}, error) {
+        if len(path) == 0 {
+                return value, nil
+        }
+
+        parent, err := jsonPointerGet(doc, path[:len(path)-1])
+        if err != nil {
+                return nil, err
+        }
+        last := path[len(path)-1]
+
+        switch node := parent.(type) {
+        case map[string]interface{}:
+                node[last] = value
+                return doc, nil
+        case []interface{}:
+                idx, err := arrayIndex(last, len(node), true)
+                if err != nil {
+                        return nil, err
+                }
+                grown := append(node[:idx:idx], append([]interface{}{value}, node[idx:]...)...)
+                return jsonPointerReplaceContainer(doc, path[:len(path)-1], grown)
+        default:
+                return nil, fmt.Errorf("parent of path is not a container")
+        }
+}
+
+func jsonPointerRemove(doc interface{}, path []string) (interface{}, interface{}, error) {
+        if len(path) == 0 {
+                return nil, nil, fmt.Errorf("cannot remove the document root")
+        }
+
+        parent, err := jsonPointerGet(doc, path[:len(path)-1])
+        if err != nil {
+                return nil, nil, err
+        }
+        last := path[len(path)-1]
+
+        switch node := parent.(type) {
+        case map[string]interface{}:
+                removed, ok := node[last]
+                if !ok {
+                        return nil, nil, fmt.Errorf("path not found")
+                }
+                delete(node, last)
+                return doc, removed, nil
+        case []interface{}:
+                idx, err := arrayIndex(last, len(node), false)
+                if err != nil {
+                        return nil, nil, err
+                }
+                removed := node[idx]
+                shrunk := append(node[:idx:idx], node[idx+1:]...)
+                doc, err = jsonPointerReplaceContainer(doc, path[:len(path)-1], shrunk)
+                return doc, removed, err
+        default:
+                return nil, nil, fmt.Errorf("path not found")
+        }
+}
+
+// jsonPointerReplaceContainer swaps a resized array back into its parent,
+// since Go slices cannot grow or shrink in place.
+func jsonPointerReplaceContainer(doc interface{}, path []string, container interface{}) (interface{}, error) {
+        if len(path) == 0 {
+                return container, nil
+        }
+
+        parent, err := jsonPointerGet(doc, path[:len(path)-1])
+        if err != nil {
+                return nil, err
+        }
+        last := path[len(path)-1]
+
+        switch node := parent.(type) {
+        case map[string]interface{}:
+                node[last] = container
+        case []interface{}:
+                idx, err := arrayIndex(last, len(node), false)
+                if err != nil {
+                        return nil, err
+                }
+                node[idx] = container
+        }
+        return doc, nil
+}
+
+func deepCopyJSON(v interface{}) interface{} {
+        data, _ := json.Marshal(v)
+        var out interface{}
+        json.Unmarshal(data, &out)
+        return out
+}
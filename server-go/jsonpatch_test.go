@@ -0,0 +1,132 @@
+package main
+
+import (
+        "encoding/json"
+        "reflect"
+        "testing"
+)
+
+func TestApplyJSONPatch(t *testing.T) {
+        tests := []struct {
+                name  string
+                doc   string
+                patch string
+                want  string // empty if the patch must fail
+        }{
+                // RFC 6902 Appendix A.
+                {"add object member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+                {"add array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+                {"remove object member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+                {"remove array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+                {"replace value", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+                {"move value", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+                {"move array element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+                {"test success", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`},
+                {"test failure", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ""},
+                {"add nested member", `{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"child":{"grandchild":{}},"foo":"bar"}`},
+                {"ignore unknown members", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux","xyz":123}]`, `{"baz":"qux","foo":"bar"}`},
+                {"add to nonexistent target", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ""},
+                {"tilde escaping", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+                {"string is not a number", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":"10"}]`, ""},
+                {"add array value", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+
+                // null is a value, not a missing one.
+                {"add null", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":null}]`, `{"baz":null,"foo":"bar"}`},
+                {"replace with null", `{"foo":"bar"}`, `[{"op":"replace","path":"/foo","value":null}]`, `{"foo":null}`},
+                {"test null", `{"foo":null}`, `[{"op":"test","path":"/foo","value":null}]`, `{"foo":null}`},
+                {"test null against missing", `{}`, `[{"op":"test","path":"/foo","value":null}]`, ""},
+                {"missing value", `{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`, ""},
+
+                // Pointers and indexes.
+                {"replace root", `{"foo":"bar"}`, `[{"op":"replace","path":"","value":{"baz":1}}]`, `{"baz":1}`},
+                {"remove root", `{"foo":"bar"}`, `[{"op":"remove","path":""}]`, ""},
+                {"pointer without slash", `{"foo":"bar"}`, `[{"op":"remove","path":"foo"}]`, ""},
+                {"empty key", `{"":1}`, `[{"op":"replace","path":"/","value":2}]`, `{"":2}`},
+                {"leading zero index", `{"foo":["a","b"]}`, `[{"op":"remove","path":"/foo/01"}]`, ""},
+                {"index past end", `{"foo":["a"]}`, `[{"op":"add","path":"/foo/2","value":"b"}]`, ""},
+                {"dash only for add", `{"foo":["a"]}`, `[{"op":"remove","path":"/foo/-"}]`, ""},
+                {"replace missing member", `{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":1}]`, ""},
+                {"remove missing member", `{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, ""},
+                {"move into own child", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a/b/c"}]`, ""},
+                {"move to itself", `{"a":1}`, `[{"op":"move","from":"/a","path":"/a"}]`, `{"a":1}`},
+                {"move to sibling prefix", `{"a":1}`, `[{"op":"move","from":"/a","path":"/ab"}]`, `{"ab":1}`},
+                {"copy is deep", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`},
+                {"copy without from", `{"a":1}`, `[{"op":"copy","path":"/b"}]`, ""},
+                {"move root", `{"a":1}`, `[{"op":"move","from":"","path":"/b"}]`, ""},
+                {"unknown op", `{}`, `[{"op":"merge","path":"/a","value":1}]`, ""},
+
+                // The patch is atomic.
+                {"later failure", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":1},{"op":"test","path":"/foo","value":"x"}]`, ""},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        var doc interface{}
+                        if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+                                t.Fatal(err)
+                        }
+                        var ops []jsonPatchOp
+                        if err := json.Unmarshal([]byte(tt.patch), &ops); err != nil {
+                                t.Fatal(err)
+                        }
+
+                        got, err := applyJSONPatch(doc, ops)
+                        if tt.want == "" {
+                                if err == nil {
+                                        t.Fatalf("applyJSONPatch() = %v, want an error", got)
+                                }
+                                return
+                        }
+                        if err != nil {
+                                t.Fatalf("applyJSONPatch() error = %v", err)
+                        }
+                        var want interface{}
+                        if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+                                t.Fatal(err)
+                        }
+                        if !reflect.DeepEqual(got, want) {
+                                t.Errorf("applyJSONPatch() = %v, want %v", got, want)
+                        }
+                })
+        }
+}
+
+func TestApplyMergePatch(t *testing.T) {
+        // RFC 7396 Appendix A.
+        tests := []struct {
+                target string
+                patch  string
+                want   string
+        }{
+                {`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+                {`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+                {`{"a":"b"}`, `{"a":null}`, `{}`},
+                {`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+                {`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+                {`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+                {`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+                {`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+                {`["a","b"]`, `["c","d"]`, `["c","d"]`},
+                {`{"a":"b"}`, `["c"]`, `["c"]`},
+                {`{"a":"foo"}`, `null`, `null`},
+                {`{"a":"foo"}`, `"bar"`, `"bar"`},
+                {`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+                {`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+                {`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+        }
+        for _, tt := range tests {
+                t.Run(tt.target+" "+tt.patch, func(t *testing.T) {
+                        var target, patch, want interface{}
+                        for _, v := range []struct {
+                                src string
+                                dst *interface{}
+                        }{{tt.target, &target}, {tt.patch, &patch}, {tt.want, &want}} {
+                                if err := json.Unmarshal([]byte(v.src), v.dst); err != nil {
+                                        t.Fatal(err)
+                                }
+                        }
+                        if got := applyMergePatch(target, patch); !reflect.DeepEqual(got, want) {
+                                t.Errorf("applyMergePatch() = %v, want %v", got, want)
+                        }
+                })
+        }
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+        "bytes"
         "compress/gzip"
         "encoding/json"
         "flag"
@@ -13,6 +14,7 @@ import (
         "net/url"
         "os"
         "path/filepath"
+        "reflect"
         "strings"
         "sync"
         "time"
@@ -24,13 +26,20 @@ var (
 )
 
 type Config struct {
-        Port          string
-        Host          string
-        StaticDir     string
-        EnableGzip    bool
-        EnableCache   bool
-        CacheMaxAge   int
-        EnableLogging bool
+        Port            string
+        Host            string
+        StaticDir       string
+        DataDir         string
+        ConfigRevisions int
+        ConfigPoll      time.Duration
+        ConfigKeyFile   string
+        EnableGzip      bool
+        EnableCache     bool
+        CacheMaxAge     int
+        EnableLogging   bool
+        AdminToken      string
+        AdminTokenHash  string
+        TrustedProxies  string
 }
 
 type OriginValidationConfig struct {
@@ -40,17 +49,31 @@ type OriginValidationConfig struct {
         AllowedSchemes  []string `json:"allowedSchemes"`
 }
 
+// AppConfig fields are private to the admin view unless tagged otherwise;
+// see configview.go.
 type AppConfig struct {
-        PrivacyMode        bool                   `json:"PRIVACY_MODE"`
-        SelectedModule     string                 `json:"SELECTED_MODULE"`
-        ModuleUnlockValues map[string]string      `json:"MODULE_UNLOCK_VALUES"`
-        UnlockGesture      string                 `json:"UNLOCK_GESTURE"`
-        UnlockPattern      string                 `json:"UNLOCK_PATTERN"`
-        UnlockFingers      int                    `json:"UNLOCK_FINGERS"`
-        AutoLockMinutes    int                    `json:"AUTO_LOCK_MINUTES"`
-        DebugMode          bool                   `json:"DEBUG_MODE"`
-        AllowedProxyHosts  []string               `json:"ALLOWED_PROXY_HOSTS"`
-        OriginValidation   OriginValidationConfig `json:"ORIGIN_VALIDATION"`
+        PrivacyMode          bool                     `json:"PRIVACY_MODE" visibility:"public"`
+        SelectedModule       string                   `json:"SELECTED_MODULE" visibility:"public"`
+        ModuleUnlockValues   map[string]string        `json:"MODULE_UNLOCK_VALUES" visibility:"secret"`
+        ModuleDuressValues   map[string]string        `json:"MODULE_DURESS_VALUES" visibility:"secret"`
+        UnlockGesture        string                   `json:"UNLOCK_GESTURE" visibility:"public"`
+        UnlockPattern        string                   `json:"UNLOCK_PATTERN" visibility:"secret"`
+        UnlockTOTPSecret     string                   `json:"UNLOCK_TOTP_SECRET,omitempty" visibility:"secret"`
+        CalculatorTOTP       bool                     `json:"CALCULATOR_TOTP" visibility:"public"`
+        UnlockFingers        int                      `json:"UNLOCK_FINGERS"`
+        AutoLockMinutes      int                      `json:"AUTO_LOCK_MINUTES" visibility:"public"`
+        DebugMode            bool                     `json:"DEBUG_MODE"`
+        AllowedProxyHosts    []ProxyHostPolicy        `json:"ALLOWED_PROXY_HOSTS"`
+        ProxyAllowedNetworks []string                 `json:"PROXY_ALLOWED_NETWORKS"`
+        ProxyCredentials     map[string]string        `json:"PROXY_CREDENTIALS,omitempty" visibility:"secret"`
+        ProxyCredentialRules []CredentialRule         `json:"PROXY_CREDENTIAL_RULES"`
+        UnlockMaxAttempts    int                      `json:"UNLOCK_MAX_ATTEMPTS"`
+        UnlockBackoffSeconds int                      `json:"UNLOCK_BACKOFF_SECONDS"`
+        UnlockLockoutMinutes int                      `json:"UNLOCK_LOCKOUT_MINUTES"`
+        DuressResponse       DuressResponseConfig     `json:"DURESS_RESPONSE"`
+        OriginValidation     OriginValidationConfig   `json:"ORIGIN_VALIDATION"`
+        Profiles             map[string]ConfigProfile `json:"PROFILES,omitempty" visibility:"secret"`
+        ConfigVersion        int                      `json:"CONFIG_VERSION"`
 }
 
 var (
@@ -68,6 +91,21 @@ func (w gzipResponseWriter) Write(b []byte) (int, error) {
         return w.Writer.Write(b)
 }
 
+// Flush pushes buffered compressed data to the client so streaming
+// responses (Server-Sent Events) are not held back by the gzip writer.
+func (w gzipResponseWriter) Flush() {
+        if gz, ok := w.Writer.(*gzip.Writer); ok {
+                gz.Flush()
+        }
+        if f, ok := w.ResponseWriter.(http.Flusher); ok {
+                f.Flush()
+        }
+}
+
+func (w gzipResponseWriter) Unwrap() http.ResponseWriter {
+        return w.ResponseWriter
+}
+
 var gzipWriterPool = sync.Pool{
         New: func() interface{} {
                 return gzip.NewWriter(nil)
@@ -84,6 +122,16 @@ func (rw *responseWriter) WriteHeader(code int) {
         rw.ResponseWriter.WriteHeader(code)
 }
 
+func (rw *responseWriter) Flush() {
+        if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+                f.Flush()
+        }
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+        return rw.ResponseWriter
+}
+
 func loggerMiddleware(next http.Handler, enabled bool) http.Handler {
         return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
                 if !enabled {
@@ -112,6 +160,14 @@ func gzipMiddleware(next http.Handler, enabled bool) http.Handler {
                         return
                 }
 
+                // The proxy relays the upstream body with its own
+                // Content-Encoding and Content-Length; compressing it again
+                // would double-encode it and leave the length wrong.
+                if r.URL.Path == "/api/proxy" {
+                        next.ServeHTTP(w, r)
+                        return
+                }
+
                 ext := filepath.Ext(r.URL.Path)
                 skipGzip := map[string]bool{
                         ".png": true, ".jpg": true, ".jpeg": true, ".gif": true,
@@ -163,11 +219,7 @@ func cacheMiddleware(next http.Handler, enabled bool, maxAge int) http.Handler {
                                 w.Header().Set("Cache-Control", "public, max-age=3600")
                         }
                 case ".json":
-                        if r.URL.Path == "/config.json" {
-                                w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-                        } else {
-                                w.Header().Set("Cache-Control", "public, max-age=3600")
-                        }
+                        w.Header().Set("Cache-Control", "public, max-age=3600")
                 case ".png", ".jpg", ".jpeg", ".gif", ".webp", ".svg", ".ico":
                         w.Header().Set("Cache-Control", "public, max-age=86400")
                 case ".woff", ".woff2", ".ttf", ".eot":
@@ -192,17 +244,38 @@ func securityMiddleware(next http.Handler) http.Handler {
         })
 }
 
+// corsAllowedHeaders are the request headers the API reads. The proxy's
+// X-Proxy-Header-* names are open-ended, so a preflight gets back whichever
+// of those it asked for.
+var corsAllowedHeaders = []string{
+        "Content-Type", "Accept", "Authorization", "If-Match",
+        unlockSessionHeader, deviceIDHeader, deviceTokenHeader, "X-Config-Profile",
+        "X-Proxy-Url", "X-Proxy-Method",
+}
+
+func corsAllowHeaders(r *http.Request) string {
+        allowed := append([]string(nil), corsAllowedHeaders...)
+        for _, name := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+                name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+                if strings.HasPrefix(name, proxyHeaderPrefix) && len(name) > len(proxyHeaderPrefix) {
+                        allowed = append(allowed, name)
+                }
+        }
+        return strings.Join(allowed, ", ")
+}
+
 func corsMiddleware(next http.Handler) http.Handler {
         return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
                 origin := r.Header.Get("Origin")
                 if origin != "" {
                         w.Header().Set("Access-Control-Allow-Origin", origin)
                         w.Header().Set("Access-Control-Allow-Credentials", "true")
+                        w.Header().Set("Access-Control-Expose-Headers", "ETag")
                 }
 
                 if r.Method == "OPTIONS" {
-                        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-                        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Accept")
+                        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+                        w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders(r))
                         w.Header().Set("Access-Control-Max-Age", "86400")
                         w.WriteHeader(http.StatusNoContent)
                         return
@@ -212,70 +285,117 @@ func corsMiddleware(next http.Handler) http.Handler {
         })
 }
 
+// loadAppConfig builds the live config from the defaults, the config file at
+// path (if any) and CAMROID_* environment variables; see configlayers.go.
 func loadAppConfig(path string) error {
-        appConfigLock.Lock()
-        defer appConfigLock.Unlock()
-
         data, err := os.ReadFile(path)
+        if err != nil && !os.IsNotExist(err) {
+                return err
+        }
+
+        cfg, layers, migrated, err := parseAppConfig(configFormat(path), data)
         if err != nil {
-                appConfig = AppConfig{
-                        PrivacyMode:        false,
-                        SelectedModule:     "game-2048",
-                        ModuleUnlockValues: map[string]string{"calculator": "123456=", "notepad": "secret", "game-2048": ""},
-                        UnlockGesture:      "severalFingers",
-                        UnlockPattern:      "0-4-8-5",
-                        UnlockFingers:      4,
-                        AutoLockMinutes:    5,
-                        DebugMode:          false,
-                        AllowedProxyHosts:  []string{"api.imgbb.com", "api.imgur.com", "api.cloudinary.com"},
-                        OriginValidation: OriginValidationConfig{
-                                Mode:            "disabled",
-                                AllowedHosts:    []string{},
-                                AllowedPatterns: []string{},
-                                AllowedSchemes:  []string{"https", "http"},
-                        },
-                }
-                return nil
-        }
-
-        if err := json.Unmarshal(data, &appConfig); err != nil {
                 return err
         }
 
-        if appConfig.OriginValidation.Mode == "" {
-                appConfig.OriginValidation = OriginValidationConfig{
-                        Mode:            "disabled",
-                        AllowedHosts:    []string{},
-                        AllowedPatterns: []string{},
-                        AllowedSchemes:  []string{"https", "http"},
-                }
+        for _, e := range validateAppConfig(&cfg) {
+                log.Printf("Warning: %s: %s %s", filepath.Base(path), e.Field, e.Message)
+        }
+
+        setConfigLayers(layers)
+        setAppConfig(cfg)
+        if data != nil {
+                rememberConfigFile(path, data)
+        }
+
+        if migrated {
+                return persistMigratedConfig(path, data)
         }
 
         return nil
 }
 
+// parseAppConfig decodes a config document in the given format, upgrades it
+// to currentConfigVersion and layers it between the defaults and the
+// environment. Encrypted documents are decrypted first and plaintext unlock
+// secrets are hashed; it reports whether the document itself needs
+// rewriting.
+func parseAppConfig(format string, data []byte) (AppConfig, configLayers, bool, error) {
+        data, encrypted, err := openConfigData(data)
+        if err != nil {
+                return AppConfig{}, configLayers{}, false, err
+        }
+        // A plaintext file is re-encrypted once a key is configured.
+        unsealed := configCipher != nil && !encrypted && len(bytes.TrimSpace(data)) > 0
+
+        var file map[string]json.RawMessage
+        if len(bytes.TrimSpace(data)) > 0 {
+                if file, err = decodeConfigFile(format, data); err != nil {
+                        return AppConfig{}, configLayers{}, false, err
+                }
+        }
+
+        upgraded := false
+        if file != nil {
+                steps, err := migrateConfigFields(file)
+                if err != nil {
+                        return AppConfig{}, configLayers{}, false, err
+                }
+                upgraded = len(steps) > 0
+        }
+
+        cfg, layers, hashed, err := buildAppConfig(file)
+        return cfg, layers, upgraded || hashed || unsealed, err
+}
+
+// saveAppConfig atomically replaces the config file, in the format given by
+// its extension, and records a JSON revision. The file is created 0600
+// because it holds unlock secret hashes.
 func saveAppConfig(path string) error {
+        return saveConfig(path, currentAppConfig())
+}
+
+// saveConfig writes cfg to path. It does not change the live config, so a
+// config that cannot be saved never takes effect.
+func saveConfig(path string, cfg AppConfig) error {
+        configSaveLock.Lock()
+        defer configSaveLock.Unlock()
+
         appConfigLock.RLock()
-        data, err := json.MarshalIndent(appConfig, "", "  ")
+        layers := appConfigLayers
         appConfigLock.RUnlock()
 
+        fields, err := configFileFields(cfg, layers)
         if err != nil {
                 return err
         }
 
-        return os.WriteFile(path, data, 0644)
-}
+        data, err := encodeConfigFile(configFormat(path), fields)
+        if err == nil {
+                data, err = sealConfigData(data)
+        }
+        if err != nil {
+                return err
+        }
 
-func isHostAllowed(targetHost string) bool {
-        appConfigLock.RLock()
-        defer appConfigLock.RUnlock()
+        if err := writeFileAtomic(path, data, 0600); err != nil {
+                return err
+        }
+        rememberConfigFile(path, data)
+        markConfigFileSaved(fields)
 
-        for _, allowed := range appConfig.AllowedProxyHosts {
-                if strings.EqualFold(targetHost, allowed) {
-                        return true
-                }
+        revision, err := json.MarshalIndent(fields, "", "  ")
+        if err == nil {
+                revision, err = sealConfigData(revision)
         }
-        return false
+        if err != nil {
+                return err
+        }
+        if err := recordRevision(path, revision); err != nil {
+                log.Printf("Warning: Could not record config revision: %v", err)
+        }
+
+        return nil
 }
 
 func matchHostPattern(host, pattern string) bool {
@@ -367,61 +487,203 @@ func validateOrigin(r *http.Request) bool {
         }
 }
 
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+        w.Header().Set("Content-Type", "application/json")
+        w.WriteHeader(status)
+        json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+        writeJSON(w, status, map[string]string{"error": message})
+}
+
+// handleConfigGet returns the public config for the profile that matches
+// the request; see profiles.go.
 func handleConfigGet(w http.ResponseWriter, r *http.Request) {
-        appConfigLock.RLock()
-        defer appConfigLock.RUnlock()
+        cfg, profile, etag := resolvedAppConfig(r)
+        w.Header().Set("ETag", etag)
+        w.Header().Set("Vary", profileVaryHeaders+", "+deviceIDHeader)
+        if profile != "" {
+                w.Header().Set("X-Config-Profile", profile)
+        }
+        view := projectConfig(cfg, publicView)
+        applyDuressResponse(r, view)
+        writeJSON(w, http.StatusOK, view)
+}
 
-        w.Header().Set("Content-Type", "application/json")
-        json.NewEncoder(w).Encode(appConfig)
+func handleAdminConfigGet(w http.ResponseWriter, r *http.Request) {
+        cfg, etag := snapshotAppConfig()
+        w.Header().Set("ETag", etag)
+        writeJSON(w, http.StatusOK, projectConfig(cfg, adminView))
 }
 
 func handleConfigPost(w http.ResponseWriter, r *http.Request) {
-        var updates map[string]interface{}
+        var updates map[string]json.RawMessage
         if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-                http.Error(w, "Invalid JSON", http.StatusBadRequest)
+                writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+                return
+        }
+
+        current, release, ok := beginConfigUpdate(w, r)
+        if !ok {
                 return
         }
+        defer release()
 
-        appConfigLock.Lock()
+        updateAppConfig(w, r, current, updates)
+}
+
+// handleConfigPatch accepts RFC 7396 merge patches and RFC 6902 JSON Patch
+// documents. Both are applied to the stored config document (secrets appear
+// as their hashes) and the changed top-level fields go through the same
+// validation as POST.
+func handleConfigPatch(w http.ResponseWriter, r *http.Request) {
+        mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+        var patch interface{}
+        var ops []jsonPatchOp
+        switch mediaType {
+        case "application/merge-patch+json":
+                if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+                        writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+                        return
+                }
+        case "application/json-patch+json":
+                if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+                        writeJSONError(w, http.StatusBadRequest, "Invalid JSON Patch document")
+                        return
+                }
+        default:
+                w.Header().Set("Accept-Patch", "application/merge-patch+json, application/json-patch+json")
+                writeJSONError(w, http.StatusUnsupportedMediaType, "Use application/merge-patch+json or application/json-patch+json")
+                return
+        }
 
-        if v, ok := updates["PRIVACY_MODE"].(bool); ok {
-                appConfig.PrivacyMode = v
+        current, release, ok := beginConfigUpdate(w, r)
+        if !ok {
+                return
         }
-        if v, ok := updates["SELECTED_MODULE"].(string); ok {
-                appConfig.SelectedModule = v
+        defer release()
+
+        var before map[string]interface{}
+        data, _ := json.Marshal(current)
+        if err := json.Unmarshal(data, &before); err != nil {
+                writeJSONError(w, http.StatusInternalServerError, "Failed to read config")
+                return
         }
-        if v, ok := updates["UNLOCK_GESTURE"].(string); ok {
-                appConfig.UnlockGesture = v
+
+        var after interface{}
+        if ops != nil {
+                var err error
+                if after, err = applyJSONPatch(deepCopyJSON(before), ops); err != nil {
+                        writeJSONError(w, http.StatusConflict, "JSON Patch failed: "+err.Error())
+                        return
+                }
+        } else {
+                after = applyMergePatch(deepCopyJSON(before), patch)
         }
-        if v, ok := updates["UNLOCK_PATTERN"].(string); ok {
-                appConfig.UnlockPattern = v
+
+        afterObj, ok := after.(map[string]interface{})
+        if !ok {
+                writeJSONError(w, http.StatusUnprocessableEntity, "Patched config must be a JSON object")
+                return
         }
-        if v, ok := updates["UNLOCK_FINGERS"].(float64); ok {
-                appConfig.UnlockFingers = int(v)
+
+        updates, errs := configUpdatesFromDiff(before, afterObj)
+        if len(errs) > 0 {
+                writeValidationErrors(w, errs)
+                return
         }
-        if v, ok := updates["AUTO_LOCK_MINUTES"].(float64); ok {
-                appConfig.AutoLockMinutes = int(v)
+
+        updateAppConfig(w, r, current, updates)
+}
+
+// moduleSecretFields are merged per module by applyConfigUpdates, so a
+// patch only sends the entries that changed.
+var moduleSecretFields = []string{"MODULE_UNLOCK_VALUES", "MODULE_DURESS_VALUES"}
+
+// configUpdatesFromDiff turns a patched config document back into the
+// partial-update form accepted by applyConfigUpdates. Removed module secret
+// entries become null, which deletes them.
+func configUpdatesFromDiff(before, after map[string]interface{}) (map[string]json.RawMessage, validationErrors) {
+        var errs validationErrors
+        updates := map[string]json.RawMessage{}
+
+        for key, oldValue := range before {
+                if _, ok := after[key]; !ok {
+                        errs.add(key, "field cannot be removed")
+                        continue
+                }
+                if contains(moduleSecretFields, key) {
+                        continue
+                }
+                if !reflect.DeepEqual(oldValue, after[key]) {
+                        updates[key], _ = json.Marshal(after[key])
+                }
         }
-        if v, ok := updates["DEBUG_MODE"].(bool); ok {
-                appConfig.DebugMode = v
+
+        for key, newValue := range after {
+                if _, ok := before[key]; !ok && !contains(moduleSecretFields, key) {
+                        updates[key], _ = json.Marshal(newValue)
+                }
         }
-        if v, ok := updates["MODULE_UNLOCK_VALUES"].(map[string]interface{}); ok {
-                for key, val := range v {
-                        if strVal, ok := val.(string); ok {
-                                appConfig.ModuleUnlockValues[key] = strVal
+
+        for _, key := range moduleSecretFields {
+                oldValues, _ := before[key].(map[string]interface{})
+                newValues, isMap := after[key].(map[string]interface{})
+                if after[key] != nil && !isMap {
+                        updates[key], _ = json.Marshal(after[key])
+                        continue
+                }
+                changed := map[string]interface{}{}
+                for module := range oldValues {
+                        if _, ok := newValues[module]; !ok {
+                                changed[module] = nil
+                        }
+                }
+                for module, value := range newValues {
+                        if !reflect.DeepEqual(oldValues[module], value) {
+                                changed[module] = value
                         }
                 }
+                if len(changed) > 0 {
+                        updates[key], _ = json.Marshal(changed)
+                }
         }
 
-        appConfigLock.Unlock()
+        return updates, errs
+}
 
-        if err := saveAppConfig(configPath); err != nil {
+// updateAppConfig validates updates against current and commits the result.
+// Callers hold the config update lock.
+func updateAppConfig(w http.ResponseWriter, r *http.Request, current AppConfig, updates map[string]json.RawMessage) {
+        if errs := envPinnedErrors(updates); len(errs) > 0 {
+                writeValidationErrors(w, errs)
+                return
+        }
+
+        cfg, errs, err := stageConfigUpdates(current, updates)
+        if err != nil {
+                writeJSONError(w, http.StatusInternalServerError, "Failed to read config")
+                return
+        }
+
+        if len(errs) > 0 {
+                writeValidationErrors(w, errs)
+                return
+        }
+
+        if err := commitAppConfig(r, "config.update", cfg); err != nil {
                 log.Printf("Failed to save config: %v", err)
+                writeJSONError(w, http.StatusInternalServerError, "Failed to save config")
+                return
         }
 
-        handleConfigGet(w, r)
+        handleAdminConfigGet(w, r)
 }
 
+const imgbbHost = "api.imgbb.com"
+
 func handleImgBBUpload(w http.ResponseWriter, r *http.Request) {
         if r.Method != "POST" {
                 http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -433,7 +695,8 @@ func handleImgBBUpload(w http.ResponseWriter, r *http.Request) {
                 return
         }
 
-        if !isHostAllowed("api.imgbb.com") {
+        policy, ok := proxyHostPolicy(imgbbHost)
+        if !ok {
                 http.Error(w, "Forbidden: ImgBB not in whitelist", http.StatusForbidden)
                 return
         }
@@ -449,39 +712,65 @@ func handleImgBBUpload(w http.ResponseWriter, r *http.Request) {
                 return
         }
 
-        if req.APIKey == "" {
-                http.Error(w, "API key required", http.StatusBadRequest)
-                return
-        }
-
         body := &strings.Builder{}
         writer := multipart.NewWriter(body)
         writer.WriteField("image", req.Image)
         writer.Close()
 
-        uploadURL := fmt.Sprintf("https://api.imgbb.com/1/upload?key=%s", req.APIKey)
+        uploadURL := "https://" + imgbbHost + "/1/upload"
         if req.Expiration > 0 {
-                uploadURL += fmt.Sprintf("&expiration=%d", req.Expiration)
+                uploadURL += fmt.Sprintf("?expiration=%d", req.Expiration)
         }
 
-        httpReq, err := http.NewRequest("POST", uploadURL, strings.NewReader(body.String()))
+        httpReq, err := http.NewRequestWithContext(r.Context(), "POST", uploadURL, strings.NewReader(body.String()))
         if err != nil {
                 http.Error(w, "Failed to create request", http.StatusInternalServerError)
                 return
         }
-        httpReq.Header.Set("Content-Type", writer.FormDataContentType())
 
-        client := &http.Client{Timeout: 60 * time.Second}
-        resp, err := client.Do(httpReq)
+        // The upload is held to the api.imgbb.com policy like any proxied
+        // request.
+        headers := map[string]string{"Content-Type": writer.FormDataContentType()}
+        if err := policy.checkRequest("POST", httpReq.URL, headers, int64(body.Len())); err != nil {
+                http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+                return
+        }
+        httpReq.Header.Set("Content-Type", headers["Content-Type"])
+
+        // A stored credential rule for api.imgbb.com supplies the key; the
+        // client's apiKey is only used when none is configured.
+        injected, err := injectCredentials(httpReq)
+        if err != nil {
+                http.Error(w, "Failed to apply stored credentials", http.StatusInternalServerError)
+                return
+        }
+        if !injected {
+                if req.APIKey == "" {
+                        http.Error(w, "API key required", http.StatusBadRequest)
+                        return
+                }
+                query := httpReq.URL.Query()
+                query.Set("key", req.APIKey)
+                httpReq.URL.RawQuery = query.Encode()
+        }
+
+        resp, err := newProxyClient(60 * time.Second).Do(httpReq)
         if err != nil {
-                http.Error(w, "ImgBB request failed: "+err.Error(), http.StatusBadGateway)
+                writeProxyError(w, "ImgBB request failed: ", err)
                 return
         }
         defer resp.Body.Close()
 
+        policy, _ = proxyHostPolicy(resp.Request.URL.Host)
+        respBody, err := policy.limitResponse(resp)
+        if err != nil {
+                writeProxyError(w, "ImgBB request failed: ", err)
+                return
+        }
+
         w.Header().Set("Content-Type", "application/json")
         w.WriteHeader(resp.StatusCode)
-        io.Copy(w, resp.Body)
+        io.Copy(w, respBody)
 }
 
 func handleProxy(w http.ResponseWriter, r *http.Request) {
@@ -495,16 +784,29 @@ func handleProxy(w http.ResponseWriter, r *http.Request) {
                 return
         }
 
-        var proxyReq struct {
-                URL     string            `json:"url"`
-                Method  string            `json:"method"`
-                Headers map[string]string `json:"headers"`
-                Body    string            `json:"body"`
-        }
+        proxyReq, streamed := streamProxyRequest(r)
+        if !streamed {
+                var jsonReq struct {
+                        URL     string            `json:"url"`
+                        Method  string            `json:"method"`
+                        Headers map[string]string `json:"headers"`
+                        Body    string            `json:"body"`
+                }
 
-        if err := json.NewDecoder(r.Body).Decode(&proxyReq); err != nil {
-                http.Error(w, "Invalid JSON", http.StatusBadRequest)
-                return
+                if err := json.NewDecoder(r.Body).Decode(&jsonReq); err != nil {
+                        http.Error(w, "Invalid JSON", http.StatusBadRequest)
+                        return
+                }
+
+                proxyReq = proxyRequest{
+                        URL:      jsonReq.URL,
+                        Method:   jsonReq.Method,
+                        Headers:  jsonReq.Headers,
+                        BodySize: int64(len(jsonReq.Body)),
+                }
+                if jsonReq.Body != "" {
+                        proxyReq.Body = strings.NewReader(jsonReq.Body)
+                }
         }
 
         targetURL, err := url.Parse(proxyReq.URL)
@@ -513,50 +815,74 @@ func handleProxy(w http.ResponseWriter, r *http.Request) {
                 return
         }
 
-        if !isHostAllowed(targetURL.Host) {
+        policy, ok := proxyHostPolicy(targetURL.Host)
+        if (targetURL.Scheme != "http" && targetURL.Scheme != "https") || !ok {
                 http.Error(w, "Forbidden: Host not in whitelist", http.StatusForbidden)
                 return
         }
 
-        method := proxyReq.Method
+        method := strings.ToUpper(proxyReq.Method)
         if method == "" {
                 method = "GET"
         }
 
-        var bodyReader io.Reader
-        if proxyReq.Body != "" {
-                bodyReader = strings.NewReader(proxyReq.Body)
+        if err := policy.checkRequest(method, targetURL, proxyReq.Headers, proxyReq.BodySize); err != nil {
+                http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+                return
+        }
+
+        timeout := 30 * time.Second
+        if proxyReq.Stream {
+                timeout = proxyStreamTimeout
+                extendStreamDeadlines(w)
         }
 
-        req, err := http.NewRequest(method, proxyReq.URL, bodyReader)
+        req, err := http.NewRequestWithContext(r.Context(), method, proxyReq.URL, policy.limitRequestBody(proxyReq.Body))
         if err != nil {
                 http.Error(w, "Failed to create request", http.StatusInternalServerError)
                 return
         }
+        if proxyReq.Body != nil {
+                req.ContentLength = proxyReq.BodySize
+        }
+
+        var dropped []string
+        req.Header, dropped = proxyRequestHeaders(r, proxyReq.Headers)
+        traceDroppedHeaders("request to", targetURL.Host, dropped)
 
-        for key, value := range proxyReq.Headers {
-                req.Header.Set(key, value)
+        if _, err := injectCredentials(req); err != nil {
+                http.Error(w, "Failed to apply stored credentials", http.StatusInternalServerError)
+                return
         }
 
-        client := &http.Client{Timeout: 30 * time.Second}
-        resp, err := client.Do(req)
+        resp, err := newProxyClient(timeout).Do(req)
         if err != nil {
-                http.Error(w, "Proxy request failed: "+err.Error(), http.StatusBadGateway)
+                writeProxyError(w, "Proxy request failed: ", err)
                 return
         }
         defer resp.Body.Close()
 
-        for key, values := range resp.Header {
-                for _, value := range values {
-                        w.Header().Add(key, value)
-                }
+        // Redirects may have ended on another allowed host with its own limit.
+        policy, _ = proxyHostPolicy(resp.Request.URL.Host)
+        limit := policy.limitResponse
+        if proxyReq.Stream {
+                limit = policy.limitStreamResponse
+        }
+        body, err := limit(resp)
+        if err != nil {
+                writeProxyError(w, "Proxy request failed: ", err)
+                return
         }
 
-        w.Header().Del("Access-Control-Allow-Origin")
-        w.Header().Del("Access-Control-Allow-Credentials")
+        dropped = copyProxyResponseHeaders(w, resp)
+        traceDroppedHeaders("response from", resp.Request.URL.Host, dropped)
 
         w.WriteHeader(resp.StatusCode)
-        io.Copy(w, resp.Body)
+        if proxyReq.Stream {
+                streamProxyResponse(w, body)
+                return
+        }
+        io.Copy(w, body)
 }
 
 func handleHealthCheck(w http.ResponseWriter, r *http.Request) {
@@ -581,14 +907,76 @@ func (h apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
                 case "GET":
                         handleConfigGet(w, r)
                 case "POST":
-                        handleConfigPost(w, r)
+                        requireAdmin(handleConfigPost)(w, r)
+                case "PATCH":
+                        requireAdmin(handleConfigPatch)(w, r)
                 default:
                         http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
                 }
+        case r.URL.Path == "/api/config/stream":
+                handleConfigStream(w, r)
+        case r.URL.Path == "/api/config/schema":
+                handleConfigSchema(w, r)
+        case r.URL.Path == "/api/admin/config":
+                if r.Method != "GET" {
+                        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                        return
+                }
+                requireAdmin(handleAdminConfigGet)(w, r)
+        case r.URL.Path == "/api/admin/config/revisions":
+                if r.Method != "GET" {
+                        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                        return
+                }
+                requireAdmin(handleConfigRevisions)(w, r)
+        case r.URL.Path == "/api/admin/config/rollback":
+                if r.Method != "POST" {
+                        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                        return
+                }
+                requireAdmin(handleConfigRollback)(w, r)
+        case r.URL.Path == "/api/admin/config/sources":
+                if r.Method != "GET" {
+                        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                        return
+                }
+                requireAdmin(handleConfigSources)(w, r)
+        case r.URL.Path == "/api/admin/config/export":
+                if r.Method != "GET" {
+                        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                        return
+                }
+                requireAdmin(handleConfigExport)(w, r)
+        case r.URL.Path == "/api/admin/config/import":
+                if r.Method != "POST" {
+                        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                        return
+                }
+                requireAdmin(handleConfigImport)(w, r)
+        case r.URL.Path == "/api/admin/audit":
+                if r.Method != "GET" {
+                        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                        return
+                }
+                requireAdmin(handleAudit)(w, r)
+        case r.URL.Path == "/api/admin/unlock/attempts":
+                requireAdmin(handleUnlockAttempts)(w, r)
+        case r.URL.Path == "/api/admin/unlock/totp":
+                requireAdmin(handleTOTP)(w, r)
+        case r.URL.Path == "/api/admin/credentials" || strings.HasPrefix(r.URL.Path, "/api/admin/credentials/"):
+                requireAdmin(handleCredentials)(w, r)
+        case r.URL.Path == "/api/admin/duress":
+                requireAdmin(handleDuress)(w, r)
+        case r.URL.Path == "/api/admin/profiles" || strings.HasPrefix(r.URL.Path, "/api/admin/profiles/"):
+                requireAdmin(handleProfiles)(w, r)
+        case r.URL.Path == "/api/unlock/verify":
+                handleUnlockVerify(w, r)
+        case r.URL.Path == "/api/unlock/heartbeat":
+                handleUnlockHeartbeat(w, r)
         case r.URL.Path == "/api/imgbb":
-                handleImgBBUpload(w, r)
+                requireUnlockSession(handleImgBBUpload)(w, r)
         case r.URL.Path == "/api/proxy":
-                handleProxy(w, r)
+                requireUnlockSession(handleProxy)(w, r)
         default:
                 http.NotFound(w, r)
         }
@@ -624,6 +1012,11 @@ func (h spaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
                 return
         }
 
+        if isSensitiveStaticPath(relPath) {
+                http.NotFound(w, r)
+                return
+        }
+
         fi, err := os.Stat(fullPath)
         if os.IsNotExist(err) {
                 http.ServeFile(w, r, filepath.Join(h.staticPath, h.indexPath))
@@ -660,12 +1053,25 @@ func main() {
         flag.StringVar(&config.Port, "port", getEnv("PORT", "5000"), "Server port")
         flag.StringVar(&config.Host, "host", getEnv("HOST", "0.0.0.0"), "Server host")
         flag.StringVar(&config.StaticDir, "static", getEnv("STATIC_DIR", "./public"), "Static files directory")
+        flag.IntVar(&config.ConfigRevisions, "config-revisions", 10, "Number of config revisions to keep for rollback")
+        flag.DurationVar(&config.ConfigPoll, "config-poll", 5*time.Second, "Interval for checking config.json for external edits (0 disables; SIGHUP always reloads)")
+        flag.StringVar(&config.DataDir, "config", getEnv("CONFIG_DIR", "./data"), "Data directory holding config.json (must be outside the static directory)")
         flag.BoolVar(&config.EnableGzip, "gzip", true, "Enable gzip compression")
         flag.BoolVar(&config.EnableCache, "cache", true, "Enable cache headers")
         flag.IntVar(&config.CacheMaxAge, "cache-max-age", 31536000, "Cache max age in seconds")
         flag.BoolVar(&config.EnableLogging, "logging", true, "Enable request logging")
+        flag.StringVar(&config.AdminToken, "admin-token", getEnv("ADMIN_TOKEN", ""), "Admin token required for config changes (prefer the ADMIN_TOKEN env var)")
+        flag.StringVar(&config.AdminTokenHash, "admin-token-hash", getEnv("ADMIN_TOKEN_HASH", ""), "Hex SHA-256 digest of the admin token (alternative to -admin-token)")
+        flag.StringVar(&config.TrustedProxies, "trusted-proxies", getEnv("TRUSTED_PROXIES", ""), "Comma-separated reverse proxy addresses or CIDR ranges whose X-Forwarded-For is trusted")
 
         showVersion := flag.Bool("version", false, "Show version")
+        migrateDryRun := flag.Bool("migrate-dry-run", false, "Print the changes a config migration would make and exit")
+        flag.StringVar(&config.ConfigKeyFile, "config-key-file", getEnv("CONFIG_KEY_FILE", ""), "File holding the 32-byte key for encrypting the config at rest (or set CONFIG_KEY / CONFIG_PASSPHRASE)")
+
+        if len(os.Args) > 1 && os.Args[1] == "edit-config" {
+                os.Exit(runEditConfig(os.Args[2:]))
+        }
+
         flag.Parse()
 
         if *showVersion {
@@ -673,6 +1079,20 @@ func main() {
                 os.Exit(0)
         }
 
+        var err error
+        configCipher, err = loadConfigEncryption(config.ConfigKeyFile)
+        if err != nil {
+                log.Fatalf("Invalid config encryption key: %v", err)
+        }
+
+        if *migrateDryRun {
+                dataDir, err := filepath.Abs(config.DataDir)
+                if err != nil {
+                        log.Fatalf("Invalid data directory: %v", err)
+                }
+                os.Exit(migrationDryRun(findConfigFile(dataDir)))
+        }
+
         staticDir, err := filepath.Abs(config.StaticDir)
         if err != nil {
                 log.Fatalf("Invalid static directory: %v", err)
@@ -687,11 +1107,68 @@ func main() {
                 log.Fatalf("index.html not found in: %s", staticDir)
         }
 
-        configPath = filepath.Join(staticDir, "config.json")
+        admin, err = newAdminAuth(config.AdminToken, config.AdminTokenHash)
+        if err != nil {
+                log.Fatalf("Invalid admin credentials: %v", err)
+        }
+        config.AdminToken = ""
+        config.AdminTokenHash = ""
+
+        trustedProxies, err = parseTrustedProxies(config.TrustedProxies)
+        if err != nil {
+                log.Fatalf("Invalid trusted proxies: %v", err)
+        }
+
+        dataDir, err := filepath.Abs(config.DataDir)
+        if err != nil {
+                log.Fatalf("Invalid data directory: %v", err)
+        }
+
+        if err := prepareDataDir(dataDir, staticDir); err != nil {
+                log.Fatalf("Invalid data directory: %v", err)
+        }
+
+        configRevisionLimit = config.ConfigRevisions
+        configPath = findConfigFile(dataDir)
+        if err := migrateLegacyConfig(staticDir, configPath); err != nil {
+                log.Fatalf("Could not migrate legacy config.json: %v", err)
+        }
+
         if err := loadAppConfig(configPath); err != nil {
-                log.Printf("Warning: Could not load config.json: %v", err)
+                log.Fatalf("Could not load %s: %v", configPath, err)
+        }
+
+        if err := openAuditLog(filepath.Join(dataDir, auditFileName)); err != nil {
+                log.Fatalf("Could not open audit log: %v", err)
         }
 
+        bundleSigningKey, err = loadSigningKey(filepath.Join(dataDir, signingKeyFileName))
+        if err != nil {
+                log.Fatalf("Could not load config signing key: %v", err)
+        }
+        trustedKeysPath = filepath.Join(dataDir, trustedKeysFileName)
+        bundleImportsPath = filepath.Join(dataDir, bundleImportsFileName)
+
+        if err := loadUnlockAttempts(filepath.Join(dataDir, unlockAttemptsFile)); err != nil {
+                log.Fatalf("Could not load unlock attempt counters: %v", err)
+        }
+        secretsKey, err = loadSecretsKey(filepath.Join(dataDir, secretsKeyFileName))
+        if err != nil {
+                log.Fatalf("Could not load secrets key: %v", err)
+        }
+        for _, e := range checkSealedSecrets(currentAppConfig()) {
+                log.Printf("Warning: %s %s", e.Field, e.Message)
+        }
+        sessionKey, err = loadSessionKey(filepath.Join(dataDir, sessionKeyFileName))
+        if err != nil {
+                log.Fatalf("Could not load unlock session key: %v", err)
+        }
+        if err := loadDuressState(filepath.Join(dataDir, duressStateFile)); err != nil {
+                log.Fatalf("Could not load duress state: %v", err)
+        }
+
+        go watchConfigFile(configPath, config.ConfigPoll)
+
         handler := spaHandler{
                 staticPath: staticDir,
                 indexPath:  "index.html",
@@ -718,6 +1195,11 @@ func main() {
         log.Printf("Config file: %s", configPath)
         log.Printf("Listening on %s:%s", config.Host, config.Port)
         log.Printf("Gzip: %v | Cache: %v | Logging: %v", config.EnableGzip, config.EnableCache, config.EnableLogging)
+        if admin.enabled {
+                log.Printf("Admin API: enabled")
+        } else {
+                log.Printf("Admin API: disabled (set ADMIN_TOKEN to allow config changes)")
+        }
 
         if err := server.ListenAndServe(); err != nil {
                 log.Fatal(err)
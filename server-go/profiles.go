@@ -0,0 +1,394 @@
+package main
+
+import (
+        "crypto/sha256"
+        "encoding/hex"
+        "encoding/json"
+        "log"
+        "net"
+        "net/http"
+        "regexp"
+        "sort"
+        "strings"
+)
+
+// Profiles layer partial AppConfig documents over the base config so one
+// server can drive devices with different cover modules and unlock methods.
+// A request is matched to a profile by device token, then by the
+// X-Config-Profile header, then by the request Host.
+const (
+        deviceTokenHeader   = "X-Device-Token"
+        configProfileHeader = "X-Config-Profile"
+        deviceTokenPrefix   = "sha256:"
+        profileVaryHeaders  = "X-Device-Token, X-Config-Profile, Host"
+)
+
+var profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// profileOverridableFields are the device-facing settings a profile may
+// override. Proxy and origin rules are server-wide.
+var profileOverridableFields = []string{
+        "PRIVACY_MODE",
+        "SELECTED_MODULE",
+        "MODULE_UNLOCK_VALUES",
+        "UNLOCK_GESTURE",
+        "UNLOCK_PATTERN",
+        "UNLOCK_FINGERS",
+        "AUTO_LOCK_MINUTES",
+        "DEBUG_MODE",
+}
+
+type ConfigProfile struct {
+        Overrides    map[string]json.RawMessage `json:"overrides"`
+        Hosts        []string                   `json:"hosts,omitempty"`
+        DeviceTokens []string                   `json:"deviceTokens,omitempty"`
+}
+
+func hashDeviceToken(token string) string {
+        if strings.HasPrefix(token, deviceTokenPrefix) {
+                return token
+        }
+        sum := sha256.Sum256([]byte(token))
+        return deviceTokenPrefix + hex.EncodeToString(sum[:])
+}
+
+// selectProfile returns the name of the profile that applies to r, or "" for
+// the base config.
+func selectProfile(cfg AppConfig, r *http.Request) string {
+        return pickProfile(cfg, r, true)
+}
+
+// selectUnlockProfile is selectProfile without X-Config-Profile: a header
+// any caller can set must not choose which unlock secrets are checked.
+func selectUnlockProfile(cfg AppConfig, r *http.Request) string {
+        return pickProfile(cfg, r, false)
+}
+
+func pickProfile(cfg AppConfig, r *http.Request, byName bool) string {
+        if len(cfg.Profiles) == 0 {
+                return ""
+        }
+
+        names := make([]string, 0, len(cfg.Profiles))
+        for name := range cfg.Profiles {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+
+        if token := r.Header.Get(deviceTokenHeader); token != "" {
+                hashed := hashDeviceToken(token)
+                for _, name := range names {
+                        if contains(cfg.Profiles[name].DeviceTokens, hashed) {
+                                return name
+                        }
+                }
+        }
+
+        if name := r.Header.Get(configProfileHeader); byName && name != "" {
+                if _, ok := cfg.Profiles[name]; ok {
+                        return name
+                }
+        }
+
+        host := r.Host
+        if h, _, err := net.SplitHostPort(host); err == nil {
+                host = h
+        }
+        host = strings.ToLower(host)
+        for _, name := range names {
+                for _, pattern := range cfg.Profiles[name].Hosts {
+                        if matchHostPattern(host, pattern) {
+                                return name
+                        }
+                }
+        }
+
+        return ""
+}
+
+// resolveProfile layers a profile's overrides over the base config.
+func resolveProfile(base AppConfig, profile ConfigProfile) (AppConfig, validationErrors, error) {
+        cfg, err := cloneAppConfig(base)
+        if err != nil {
+                return cfg, nil, err
+        }
+        cfg.Profiles = nil
+
+        errs := applyConfigUpdates(&cfg, profile.Overrides)
+        return cfg, errs, nil
+}
+
+// resolvedAppConfig returns the config that applies to r along with the
+// profile name and the base config ETag.
+func resolvedAppConfig(r *http.Request) (AppConfig, string, string) {
+        base, etag := snapshotAppConfig()
+        return resolveSelectedProfile(base, etag, selectProfile(base, r))
+}
+
+// resolvedUnlockConfig is resolvedAppConfig for unlocking and unlock
+// sessions, with the profile chosen by selectUnlockProfile.
+func resolvedUnlockConfig(r *http.Request) (AppConfig, string) {
+        base, etag := snapshotAppConfig()
+        cfg, name, _ := resolveSelectedProfile(base, etag, selectUnlockProfile(base, r))
+        return cfg, name
+}
+
+func resolveSelectedProfile(base AppConfig, etag, name string) (AppConfig, string, string) {
+        if name == "" {
+                return base, "", etag
+        }
+
+        cfg, errs, err := resolveProfile(base, base.Profiles[name])
+        if err != nil || len(errs) > 0 {
+                log.Printf("Warning: Could not resolve config profile %q, using base config: %v %v", name, err, errs)
+                return base, "", etag
+        }
+
+        return cfg, name, etag
+}
+
+// validateProfiles checks every stored profile by resolving it against cfg.
+func validateProfiles(cfg *AppConfig, errs *validationErrors) {
+        for name, profile := range cfg.Profiles {
+                prefix := "PROFILES." + name
+                if !profileNamePattern.MatchString(name) {
+                        errs.add(prefix, "profile names must match %s", profileNamePattern)
+                }
+                for key := range profile.Overrides {
+                        if !contains(profileOverridableFields, key) {
+                                errs.add(prefix+".overrides."+key, "cannot be overridden per profile")
+                        }
+                }
+                validateHostList(prefix+".hosts", profile.Hosts, validateHostPattern, errs)
+
+                resolved, resolveErrs, err := resolveProfile(*cfg, profile)
+                if err != nil {
+                        errs.add(prefix, "%v", err)
+                        continue
+                }
+                resolveErrs = append(resolveErrs, validateAppConfig(&resolved)...)
+                for _, e := range resolveErrs {
+                        errs.add(prefix+".overrides."+e.Field, "%s", e.Message)
+                }
+        }
+}
+
+// hashProfileSecrets replaces plaintext unlock secrets in a profile's
+// overrides with hashes made by hash. It reports whether anything was
+// rewritten.
+func hashProfileSecrets(profile *ConfigProfile, hash func(string) (string, error)) (bool, error) {
+        changed := false
+
+        if raw, ok := profile.Overrides["UNLOCK_PATTERN"]; ok {
+                var pattern string
+                if err := json.Unmarshal(raw, &pattern); err != nil {
+                        return false, err
+                }
+                if pattern != "" && !isSecretHash(pattern) {
+                        hashed, err := hash(pattern)
+                        if err != nil {
+                                return false, err
+                        }
+                        profile.Overrides["UNLOCK_PATTERN"], _ = json.Marshal(hashed)
+                        changed = true
+                }
+        }
+
+        if raw, ok := profile.Overrides["MODULE_UNLOCK_VALUES"]; ok {
+                var values map[string]*string
+                if err := json.Unmarshal(raw, &values); err != nil {
+                        return false, err
+                }
+                rewritten := false
+                for module, value := range values {
+                        if value == nil || *value == "" || isSecretHash(*value) {
+                                continue
+                        }
+                        hashed, err := hash(*value)
+                        if err != nil {
+                                return false, err
+                        }
+                        values[module] = &hashed
+                        rewritten = true
+                }
+                if rewritten {
+                        profile.Overrides["MODULE_UNLOCK_VALUES"], _ = json.Marshal(values)
+                        changed = true
+                }
+        }
+
+        return changed, nil
+}
+
+// profileView is the admin representation of a profile. Unlock secrets and
+// device token hashes are never returned; only which ones are set.
+func profileView(name string, profile ConfigProfile) map[string]interface{} {
+        overrides := map[string]json.RawMessage{}
+        secrets := []string{}
+        for key, raw := range profile.Overrides {
+                switch key {
+                case "UNLOCK_PATTERN":
+                        secrets = append(secrets, key)
+                case "MODULE_UNLOCK_VALUES":
+                        var values map[string]*string
+                        json.Unmarshal(raw, &values)
+                        for module := range values {
+                                secrets = append(secrets, key+"."+module)
+                        }
+                default:
+                        overrides[key] = raw
+                }
+        }
+        sort.Strings(secrets)
+
+        hosts := profile.Hosts
+        if hosts == nil {
+                hosts = []string{}
+        }
+
+        return map[string]interface{}{
+                "name":             name,
+                "overrides":        overrides,
+                "secretOverrides":  secrets,
+                "hosts":            hosts,
+                "deviceTokenCount": len(profile.DeviceTokens),
+        }
+}
+
+func handleProfiles(w http.ResponseWriter, r *http.Request) {
+        name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/profiles"), "/")
+
+        if name == "" {
+                if r.Method != "GET" {
+                        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                        return
+                }
+                cfg, etag := snapshotAppConfig()
+                profiles := []map[string]interface{}{}
+                for profileName, profile := range cfg.Profiles {
+                        profiles = append(profiles, profileView(profileName, profile))
+                }
+                sort.Slice(profiles, func(i, j int) bool {
+                        return profiles[i]["name"].(string) < profiles[j]["name"].(string)
+                })
+                w.Header().Set("ETag", etag)
+                writeJSON(w, http.StatusOK, map[string]interface{}{"profiles": profiles})
+                return
+        }
+
+        if !profileNamePattern.MatchString(name) {
+                writeJSONError(w, http.StatusBadRequest, "Profile names must be 1-32 lowercase letters, digits, '-' or '_'")
+                return
+        }
+
+        switch r.Method {
+        case "GET":
+                cfg, etag := snapshotAppConfig()
+                profile, ok := cfg.Profiles[name]
+                if !ok {
+                        writeJSONError(w, http.StatusNotFound, "Unknown profile")
+                        return
+                }
+                w.Header().Set("ETag", etag)
+                writeJSON(w, http.StatusOK, profileView(name, profile))
+        case "PUT":
+                handleProfilePut(w, r, name)
+        case "DELETE":
+                handleProfileDelete(w, r, name)
+        default:
+                http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        }
+}
+
+// handleProfilePut creates or replaces a profile. Device tokens are sent in
+// plaintext and stored as SHA-256 digests; unlock secrets are scrypt-hashed.
+func handleProfilePut(w http.ResponseWriter, r *http.Request, name string) {
+        var profile ConfigProfile
+        dec := json.NewDecoder(r.Body)
+        dec.DisallowUnknownFields()
+        if err := dec.Decode(&profile); err != nil {
+                writeJSONError(w, http.StatusBadRequest, "Invalid JSON: "+strings.TrimPrefix(err.Error(), "json: "))
+                return
+        }
+        if profile.Overrides == nil {
+                profile.Overrides = map[string]json.RawMessage{}
+        }
+        profile.Hosts = normalizeHosts(profile.Hosts)
+        for i, token := range profile.DeviceTokens {
+                profile.DeviceTokens[i] = hashDeviceToken(token)
+        }
+
+        current, release, ok := beginConfigUpdate(w, r)
+        if !ok {
+                return
+        }
+        defer release()
+
+        cfg, err := cloneAppConfig(current)
+        if err != nil {
+                writeJSONError(w, http.StatusInternalServerError, "Failed to read config")
+                return
+        }
+        if cfg.Profiles == nil {
+                cfg.Profiles = map[string]ConfigProfile{}
+        }
+
+        for otherName, other := range cfg.Profiles {
+                if otherName == name {
+                        continue
+                }
+                for _, token := range profile.DeviceTokens {
+                        if contains(other.DeviceTokens, token) {
+                                writeValidationErrors(w, validationErrors{{Field: "deviceTokens", Message: "a device token is already assigned to profile " + otherName}})
+                                return
+                        }
+                }
+        }
+
+        cfg.Profiles[name] = profile
+
+        var errs validationErrors
+        validateProfiles(&cfg, &errs)
+        if len(errs) > 0 {
+                writeValidationErrors(w, errs)
+                return
+        }
+
+        if err := commitAppConfig(r, "profile.put", cfg); err != nil {
+                log.Printf("Failed to save config: %v", err)
+                writeJSONError(w, http.StatusInternalServerError, "Failed to save config")
+                return
+        }
+
+        saved, etag := snapshotAppConfig()
+        w.Header().Set("ETag", etag)
+        writeJSON(w, http.StatusOK, profileView(name, saved.Profiles[name]))
+}
+
+func handleProfileDelete(w http.ResponseWriter, r *http.Request, name string) {
+        current, release, ok := beginConfigUpdate(w, r)
+        if !ok {
+                return
+        }
+        defer release()
+
+        if _, ok := current.Profiles[name]; !ok {
+                writeJSONError(w, http.StatusNotFound, "Unknown profile")
+                return
+        }
+
+        cfg, err := cloneAppConfig(current)
+        if err != nil {
+                writeJSONError(w, http.StatusInternalServerError, "Failed to read config")
+                return
+        }
+        delete(cfg.Profiles, name)
+
+        if err := commitAppConfig(r, "profile.delete", cfg); err != nil {
+                log.Printf("Failed to save config: %v", err)
+                writeJSONError(w, http.StatusInternalServerError, "Failed to save config")
+                return
+        }
+
+        w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+        "fmt"
+        "log"
+        "net/http"
+        "net/textproto"
+        "sort"
+        "strings"
+)
+
+// /api/proxy relays headers between the browser and the upstream host, so it
+// acts as an HTTP intermediary: hop-by-hop headers (RFC 7230 section 6.1),
+// including any named in Connection, are not passed on in either direction.
+// Cookies stay on their own side: the client cannot send any upstream, and
+// upstream cookies are not set on the app's origin. Headers that describe
+// the client connection, or that would apply upstream security policy to
+// the app's origin, are dropped too. With DEBUG_MODE on, each dropped header
+// is logged.
+const proxyViaName = "camroid"
+
+var (
+        hopByHopHeaders = []string{
+                "Connection",
+                "Keep-Alive",
+                "Proxy-Authenticate",
+                "Proxy-Authorization",
+                "Proxy-Connection",
+                "Te",
+                "Trailer",
+                "Transfer-Encoding",
+                "Upgrade",
+        }
+
+        // proxyRequestDenyHeaders may not be set by the client.
+        proxyRequestDenyHeaders = []string{
+                "Host",
+                "Cookie",
+                "Cookie2",
+                "Content-Length",
+                "Forwarded",
+                "Via",
+                "X-Real-Ip",
+                "X-Client-Ip",
+                "True-Client-Ip",
+                "Cf-Connecting-Ip",
+        }
+
+        // proxyResponseDenyHeaders are not relayed to the browser.
+        proxyResponseDenyHeaders = []string{
+                "Set-Cookie",
+                "Set-Cookie2",
+                "Strict-Transport-Security",
+                "Content-Security-Policy",
+                "Content-Security-Policy-Report-Only",
+                "Public-Key-Pins",
+                "Public-Key-Pins-Report-Only",
+                "Alt-Svc",
+                "Clear-Site-Data",
+                "Access-Control-Allow-Origin",
+                "Access-Control-Allow-Credentials",
+                "Access-Control-Allow-Headers",
+                "Access-Control-Allow-Methods",
+                "Access-Control-Expose-Headers",
+                "Access-Control-Max-Age",
+        }
+)
+
+// connectionTokens returns the header names listed in Connection, which are
+// hop-by-hop for this message only.
+func connectionTokens(values []string) []string {
+        var names []string
+        for _, value := range values {
+                for _, token := range strings.Split(value, ",") {
+                        if token = strings.TrimSpace(token); token != "" {
+                                names = append(names, textproto.CanonicalMIMEHeaderKey(token))
+                        }
+                }
+        }
+        return names
+}
+
+func isHopByHopHeader(name string, connection []string) bool {
+        return contains(hopByHopHeaders, name) || contains(connection, name)
+}
+
+// viaValue is this hop's Via entry for a message received over HTTP
+// major.minor (RFC 7230 section 5.7.1).
+func viaValue(major, minor int) string {
+        if major >= 2 {
+                return fmt.Sprintf("%d %s", major, proxyViaName)
+        }
+        return fmt.Sprintf("%d.%d %s", major, minor, proxyViaName)
+}
+
+// proxyRequestHeaders builds the upstream request headers from the ones the
+// client supplied in r's body, returning the names it dropped.
+func proxyRequestHeaders(r *http.Request, client map[string]string) (http.Header, []string) {
+        header := http.Header{}
+        var dropped []string
+
+        var connection []string
+        for name, value := range client {
+                if http.CanonicalHeaderKey(name) == "Connection" {
+                        connection = connectionTokens([]string{value})
+                }
+        }
+
+        for name, value := range client {
+                name = http.CanonicalHeaderKey(name)
+                if isHopByHopHeader(name, connection) || contains(proxyRequestDenyHeaders, name) ||
+                        strings.HasPrefix(name, "X-Forwarded-") {
+                        dropped = append(dropped, name)
+                        continue
+                }
+                header.Set(name, value)
+        }
+
+        header.Set("Via", viaValue(r.ProtoMajor, r.ProtoMinor))
+        return header, dropped
+}
+
+// copyProxyResponseHeaders relays the upstream response headers to w,
+// returning the names it dropped.
+func copyProxyResponseHeaders(w http.ResponseWriter, resp *http.Response) []string {
+        connection := connectionTokens(resp.Header.Values("Connection"))
+        var dropped []string
+
+        for name, values := range resp.Header {
+                if isHopByHopHeader(name, connection) || contains(proxyResponseDenyHeaders, name) {
+                        dropped = append(dropped, name)
+                        continue
+                }
+                for _, value := range values {
+                        w.Header().Add(name, value)
+                }
+        }
+
+        // This hop goes after any upstream intermediaries.
+        w.Header().Add("Via", viaValue(resp.ProtoMajor, resp.ProtoMinor))
+        return dropped
+}
+
+// traceDroppedHeaders logs headers removed from a proxied exchange when
+// DEBUG_MODE is on.
+func traceDroppedHeaders(direction, host string, names []string) {
+        if len(names) == 0 {
+                return
+        }
+        if cfg, _ := snapshotAppConfig(); !cfg.DebugMode {
+                return
+        }
+        sort.Strings(names)
+        log.Printf("Proxy %s %s: dropped headers %s", direction, host, strings.Join(names, ", "))
+}
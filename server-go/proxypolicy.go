@@ -0,0 +1,279 @@
+package main
+
+import (
+        "bytes"
+        "encoding/json"
+        "fmt"
+        "io"
+        "net/http"
+        "net/url"
+        "regexp"
+        "strings"
+)
+
+// ALLOWED_PROXY_HOSTS entries are either a host name or a policy object that
+// narrows what may be sent to that host. Empty lists and zero sizes mean no
+// restriction, so a plain host name allows everything, as it always has.
+type ProxyHostPolicy struct {
+        Host             string   `json:"host"`
+        Methods          []string `json:"methods,omitempty"`
+        PathPrefixes     []string `json:"pathPrefixes,omitempty"`
+        PathPatterns     []string `json:"pathPatterns,omitempty"`
+        AllowHeaders     []string `json:"allowHeaders,omitempty"`
+        DenyHeaders      []string `json:"denyHeaders,omitempty"`
+        MaxRequestBytes  int64    `json:"maxRequestBytes,omitempty"`
+        MaxResponseBytes int64    `json:"maxResponseBytes,omitempty"`
+        HTTPSOnly        bool     `json:"httpsOnly,omitempty"`
+
+        // pathRegexps holds PathPatterns compiled by validation.
+        pathRegexps []*regexp.Regexp
+}
+
+var proxyMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// proxyPolicyError is a request refused by a host policy.
+type proxyPolicyError struct {
+        msg string
+}
+
+func (e *proxyPolicyError) Error() string {
+        return e.msg
+}
+
+func policyErrorf(format string, args ...interface{}) error {
+        return &proxyPolicyError{msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *ProxyHostPolicy) UnmarshalJSON(data []byte) error {
+        var host string
+        if err := json.Unmarshal(data, &host); err == nil {
+                *p = ProxyHostPolicy{Host: host}
+                return nil
+        }
+
+        type plain ProxyHostPolicy
+        dec := json.NewDecoder(bytes.NewReader(data))
+        dec.DisallowUnknownFields()
+        var v plain
+        if err := dec.Decode(&v); err != nil {
+                return fmt.Errorf("must be a host name or a policy object: %v", strings.TrimPrefix(err.Error(), "json: "))
+        }
+        *p = ProxyHostPolicy(v)
+        return nil
+}
+
+// MarshalJSON writes a policy without restrictions as its plain host name.
+func (p ProxyHostPolicy) MarshalJSON() ([]byte, error) {
+        type plain ProxyHostPolicy
+        if p.isHostOnly() {
+                return json.Marshal(p.Host)
+        }
+        return json.Marshal(plain(p))
+}
+
+func (p ProxyHostPolicy) isHostOnly() bool {
+        return len(p.Methods) == 0 && len(p.PathPrefixes) == 0 && len(p.PathPatterns) == 0 &&
+                len(p.AllowHeaders) == 0 && len(p.DenyHeaders) == 0 &&
+                p.MaxRequestBytes == 0 && p.MaxResponseBytes == 0 && !p.HTTPSOnly
+}
+
+// validateProxyHostPolicies checks each policy and returns a copy with its
+// path patterns compiled, so requests do not compile them again. The input
+// may be shared with the live config and is left untouched.
+func validateProxyHostPolicies(policies []ProxyHostPolicy, errs *validationErrors) []ProxyHostPolicy {
+        if policies == nil {
+                return nil
+        }
+        compiled := make([]ProxyHostPolicy, len(policies))
+        for i, p := range policies {
+                p.pathRegexps = nil
+                field := fmt.Sprintf("ALLOWED_PROXY_HOSTS[%d]", i)
+                if err := validateHost(p.Host); err != nil {
+                        errs.add(field, "%v", err)
+                }
+                for _, method := range p.Methods {
+                        if !contains(proxyMethods, method) {
+                                errs.add(field+".methods", "%q is not one of %s", method, strings.Join(proxyMethods, ", "))
+                        }
+                }
+                for _, prefix := range p.PathPrefixes {
+                        if !strings.HasPrefix(prefix, "/") {
+                                errs.add(field+".pathPrefixes", "%q must start with /", prefix)
+                        }
+                }
+                for _, pattern := range p.PathPatterns {
+                        re, err := compilePathPattern(pattern)
+                        if err != nil {
+                                errs.add(field+".pathPatterns", "%q is not a valid regular expression", pattern)
+                                continue
+                        }
+                        p.pathRegexps = append(p.pathRegexps, re)
+                }
+                if p.MaxRequestBytes < 0 || p.MaxResponseBytes < 0 {
+                        errs.add(field, "size limits must not be negative")
+                }
+                compiled[i] = p
+        }
+        return compiled
+}
+
+func normalizeProxyHostPolicies(policies []ProxyHostPolicy) []ProxyHostPolicy {
+        out := make([]ProxyHostPolicy, 0, len(policies))
+        for _, p := range policies {
+                p.Host = strings.ToLower(strings.TrimSpace(p.Host))
+                for i, method := range p.Methods {
+                        p.Methods[i] = strings.ToUpper(method)
+                }
+                out = append(out, p)
+        }
+        return out
+}
+
+// proxyHostPolicy returns the policy for an upstream host, if it is allowed.
+func proxyHostPolicy(host string) (ProxyHostPolicy, bool) {
+        appConfigLock.RLock()
+        defer appConfigLock.RUnlock()
+
+        for _, p := range appConfig.AllowedProxyHosts {
+                if strings.EqualFold(host, p.Host) {
+                        return p, true
+                }
+        }
+        return ProxyHostPolicy{}, false
+}
+
+// compilePathPattern anchors a path pattern at both ends, so it has to
+// match the whole path: "/items/[0-9]+" does not allow "/admin/items/1" or
+// "/items/1/delete".
+func compilePathPattern(pattern string) (*regexp.Regexp, error) {
+        return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+// regexps returns the compiled path patterns, compiling them for a policy
+// that did not come through validation.
+func (p ProxyHostPolicy) regexps() []*regexp.Regexp {
+        if len(p.pathRegexps) == len(p.PathPatterns) {
+                return p.pathRegexps
+        }
+        var res []*regexp.Regexp
+        for _, pattern := range p.PathPatterns {
+                if re, err := compilePathPattern(pattern); err == nil {
+                        res = append(res, re)
+                }
+        }
+        return res
+}
+
+// hasPathPrefix matches prefix on segment boundaries, so "/v1" allows "/v1"
+// and "/v1/files" but not "/v1admin".
+func hasPathPrefix(path, prefix string) bool {
+        if !strings.HasPrefix(path, prefix) {
+                return false
+        }
+        return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// hasUnsafeSegment reports a path segment that is "." or "..", literally or
+// percent-encoded, or one that hides a separator as %2F or %5C. Upstreams
+// may resolve these after the prefix check, escaping the allowed paths.
+func hasUnsafeSegment(path string) bool {
+        for _, segment := range strings.Split(path, "/") {
+                decoded, err := url.PathUnescape(segment)
+                if err != nil || decoded == "." || decoded == ".." || strings.ContainsAny(decoded, "/\\") {
+                        return true
+                }
+        }
+        return false
+}
+
+// checkTarget applies the scheme, method and path rules.
+func (p ProxyHostPolicy) checkTarget(method string, target *url.URL) error {
+        if p.HTTPSOnly && target.Scheme != "https" {
+                return policyErrorf("%s only accepts https", p.Host)
+        }
+
+        if len(p.Methods) > 0 && !contains(p.Methods, method) {
+                return policyErrorf("method %s is not allowed for %s (allowed: %s)", method, p.Host, strings.Join(p.Methods, ", "))
+        }
+
+        if len(p.PathPrefixes) == 0 && len(p.PathPatterns) == 0 {
+                return nil
+        }
+        path := target.EscapedPath()
+        if path == "" {
+                path = "/"
+        }
+        if hasUnsafeSegment(path) {
+                return policyErrorf("path %s has dot segments or encoded separators, not allowed for %s", path, p.Host)
+        }
+        for _, prefix := range p.PathPrefixes {
+                if hasPathPrefix(path, prefix) {
+                        return nil
+                }
+        }
+        for _, re := range p.regexps() {
+                if re.MatchString(path) {
+                        return nil
+                }
+        }
+        return policyErrorf("path %s is not allowed for %s", path, p.Host)
+}
+
+// checkRequest applies every request-side rule to what the client asked to
+// send. A bodySize of -1 means the size is not known yet; see
+// limitRequestBody.
+func (p ProxyHostPolicy) checkRequest(method string, target *url.URL, headers map[string]string, bodySize int64) error {
+        if err := p.checkTarget(method, target); err != nil {
+                return err
+        }
+
+        for name := range headers {
+                if containsFold(p.DenyHeaders, name) {
+                        return policyErrorf("header %s is not allowed for %s", http.CanonicalHeaderKey(name), p.Host)
+                }
+                if len(p.AllowHeaders) > 0 && !containsFold(p.AllowHeaders, name) {
+                        return policyErrorf("header %s is not allowed for %s (allowed: %s)", http.CanonicalHeaderKey(name), p.Host, strings.Join(p.AllowHeaders, ", "))
+                }
+        }
+
+        if p.MaxRequestBytes > 0 && bodySize > p.MaxRequestBytes {
+                return policyErrorf("request body of %d bytes exceeds the %d byte limit for %s", bodySize, p.MaxRequestBytes, p.Host)
+        }
+        return nil
+}
+
+// limitResponse returns the upstream body to relay, refusing it if it is
+// larger than MaxResponseBytes. Bodies of unknown length are buffered up to
+// the limit so the check happens before anything is sent to the client.
+func (p ProxyHostPolicy) limitResponse(resp *http.Response) (io.Reader, error) {
+        limit := p.MaxResponseBytes
+        if limit == 0 {
+                return resp.Body, nil
+        }
+        tooLarge := policyErrorf("response from %s exceeds the %d byte limit", p.Host, limit)
+
+        if resp.ContentLength > limit {
+                return nil, tooLarge
+        }
+        if resp.ContentLength >= 0 {
+                return io.LimitReader(resp.Body, limit), nil
+        }
+
+        data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+        if err != nil {
+                return nil, err
+        }
+        if int64(len(data)) > limit {
+                return nil, tooLarge
+        }
+        return bytes.NewReader(data), nil
+}
+
+func containsFold(list []string, v string) bool {
+        for _, item := range list {
+                if strings.EqualFold(item, v) {
+                        return true
+                }
+        }
+        return false
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+        "errors"
+        "net/http"
+        "net/http/httptest"
+        "net/url"
+        "strings"
+        "testing"
+)
+
+func TestProxyPolicyCheckTarget(t *testing.T) {
+        var errs validationErrors
+        policies := validateProxyHostPolicies([]ProxyHostPolicy{{
+                Host:         "api.example.com",
+                Methods:      []string{"GET", "POST"},
+                PathPrefixes: []string{"/v1", "/static/"},
+                PathPatterns: []string{`^/items/[0-9]+$`, `/files/[a-z]+`},
+                HTTPSOnly:    true,
+        }}, &errs)
+        if len(errs) > 0 {
+                t.Fatalf("validation errors: %v", errs)
+        }
+        policy := policies[0]
+        if len(policy.pathRegexps) != 2 {
+                t.Fatalf("pathRegexps not compiled: %v", policy.pathRegexps)
+        }
+
+        tests := []struct {
+                name   string
+                method string
+                target string
+                ok     bool
+        }{
+                {"prefix itself", "GET", "https://api.example.com/v1", true},
+                {"below prefix", "GET", "https://api.example.com/v1/files/a", true},
+                {"prefix with trailing slash", "GET", "https://api.example.com/static/app.js", true},
+                {"pattern match", "POST", "https://api.example.com/items/42", true},
+                {"prefix is not a segment", "GET", "https://api.example.com/v1admin", false},
+                {"prefix in a longer segment", "GET", "https://api.example.com/v10/files", false},
+                {"pattern mismatch", "GET", "https://api.example.com/items/abc", false},
+                {"unanchored pattern match", "GET", "https://api.example.com/files/abc", true},
+                {"unanchored pattern inside a path", "GET", "https://api.example.com/admin/files/abc", false},
+                {"unanchored pattern with suffix", "GET", "https://api.example.com/files/abc/delete", false},
+                {"unanchored pattern with trailing characters", "GET", "https://api.example.com/files/abc9", false},
+                {"outside every rule", "GET", "https://api.example.com/admin", false},
+                {"root", "GET", "https://api.example.com", false},
+                {"dot dot segment", "GET", "https://api.example.com/v1/../admin", false},
+                {"dot segment", "GET", "https://api.example.com/v1/./files", false},
+                {"trailing dot dot", "GET", "https://api.example.com/v1/..", false},
+                {"encoded dot dot", "GET", "https://api.example.com/v1/%2e%2e/admin", false},
+                {"upper-case encoded dot dot", "GET", "https://api.example.com/v1/%2E%2E/admin", false},
+                {"mixed encoded dot dot", "GET", "https://api.example.com/v1/.%2e/admin", false},
+                {"encoded slash", "GET", "https://api.example.com/v1%2f..%2fadmin", false},
+                {"encoded backslash", "GET", "https://api.example.com/v1/..%5cadmin", false},
+                {"dots inside a name", "GET", "https://api.example.com/v1/file..name", true},
+                {"method not allowed", "DELETE", "https://api.example.com/v1", false},
+                {"plain http", "GET", "http://api.example.com/v1", false},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        target, err := url.Parse(tt.target)
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        err = policy.checkTarget(tt.method, target)
+                        if (err == nil) != tt.ok {
+                                t.Errorf("checkTarget(%s %s) error = %v, want ok %v", tt.method, tt.target, err, tt.ok)
+                        }
+                        var policyErr *proxyPolicyError
+                        if err != nil && !errors.As(err, &policyErr) {
+                                t.Errorf("error %v is not a policy error", err)
+                        }
+                })
+        }
+}
+
+func TestProxyPolicyHostOnlyAllowsAnyPath(t *testing.T) {
+        policy := ProxyHostPolicy{Host: "api.example.com"}
+        for _, path := range []string{"/", "/a/../b", "/%2e%2e/x"} {
+                target, _ := url.Parse("http://api.example.com" + path)
+                if err := policy.checkTarget("PUT", target); err != nil {
+                        t.Errorf("checkTarget(%s) = %v, want nil", path, err)
+                }
+        }
+}
+
+func TestValidateProxyHostPolicies(t *testing.T) {
+        tests := []struct {
+                name   string
+                policy ProxyHostPolicy
+                fields []string
+        }{
+                {"host only", ProxyHostPolicy{Host: "api.example.com"}, nil},
+                {"bad method", ProxyHostPolicy{Host: "api.example.com", Methods: []string{"TRACE"}}, []string{"ALLOWED_PROXY_HOSTS[0].methods"}},
+                {"relative prefix", ProxyHostPolicy{Host: "api.example.com", PathPrefixes: []string{"v1"}}, []string{"ALLOWED_PROXY_HOSTS[0].pathPrefixes"}},
+                {"bad pattern", ProxyHostPolicy{Host: "api.example.com", PathPatterns: []string{"("}}, []string{"ALLOWED_PROXY_HOSTS[0].pathPatterns"}},
+                {"negative size", ProxyHostPolicy{Host: "api.example.com", MaxRequestBytes: -1}, []string{"ALLOWED_PROXY_HOSTS[0]"}},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        var errs validationErrors
+                        input := []ProxyHostPolicy{tt.policy}
+                        validateProxyHostPolicies(input, &errs)
+                        var fields []string
+                        for _, e := range errs {
+                                fields = append(fields, e.Field)
+                        }
+                        if strings.Join(fields, ",") != strings.Join(tt.fields, ",") {
+                                t.Errorf("error fields = %v, want %v", fields, tt.fields)
+                        }
+                        if input[0].pathRegexps != nil {
+                                t.Error("validation modified its input")
+                        }
+                })
+        }
+}
+
+func TestProxyPolicyCheckRequest(t *testing.T) {
+        policy := ProxyHostPolicy{
+                Host:            "api.example.com",
+                AllowHeaders:    []string{"Content-Type", "Accept"},
+                DenyHeaders:     []string{"Cookie"},
+                MaxRequestBytes: 100,
+        }
+        target, _ := url.Parse("https://api.example.com/upload")
+
+        tests := []struct {
+                name    string
+                headers map[string]string
+                size    int64
+                ok      bool
+        }{
+                {"allowed headers", map[string]string{"content-type": "text/plain"}, 10, true},
+                {"header outside allow list", map[string]string{"X-Other": "1"}, 10, false},
+                {"denied header", map[string]string{"Cookie": "a=b"}, 10, false},
+                {"at size limit", nil, 100, true},
+                {"over size limit", nil, 101, false},
+                {"size unknown", nil, -1, true},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        err := policy.checkRequest("POST", target, tt.headers, tt.size)
+                        if (err == nil) != tt.ok {
+                                t.Errorf("checkRequest() error = %v, want ok %v", err, tt.ok)
+                        }
+                })
+        }
+}
+
+func TestImgBBUploadPolicy(t *testing.T) {
+        tests := []struct {
+                name   string
+                policy ProxyHostPolicy
+                reason string
+        }{
+                {"method not allowed", ProxyHostPolicy{Host: imgbbHost, Methods: []string{"GET"}}, "method POST"},
+                {"path not allowed", ProxyHostPolicy{Host: imgbbHost, PathPrefixes: []string{"/2"}}, "path /1/upload"},
+                {"header not allowed", ProxyHostPolicy{Host: imgbbHost, AllowHeaders: []string{"Accept"}}, "header Content-Type"},
+                {"body too large", ProxyHostPolicy{Host: imgbbHost, MaxRequestBytes: 10}, "exceeds the 10 byte limit"},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        withProxyConfig(t, AppConfig{
+                                AllowedProxyHosts: []ProxyHostPolicy{tt.policy},
+                                OriginValidation:  OriginValidationConfig{Mode: "disabled"},
+                        })
+
+                        r := httptest.NewRequest("POST", "/api/imgbb", strings.NewReader(`{"image":"aGVsbG8gd29ybGQ=","apiKey":"k"}`))
+                        w := httptest.NewRecorder()
+                        handleImgBBUpload(w, r)
+
+                        if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), tt.reason) {
+                                t.Errorf("got %d %q, want 403 mentioning %q", w.Code, w.Body.String(), tt.reason)
+                        }
+                })
+        }
+}
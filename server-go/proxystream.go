@@ -0,0 +1,169 @@
+package main
+
+import (
+        "io"
+        "net/http"
+        "strings"
+        "time"
+)
+
+// /api/proxy also takes the request body as-is: when the target is given by
+// the X-Proxy-Url header or the url query parameter, the options come from
+// headers or query parameters and the body is streamed upstream unchanged,
+// so binary and multipart uploads need no base64 wrapping. The response is
+// streamed back as it arrives, each chunk written before the next is read
+// from upstream.
+//
+//	X-Proxy-Url / url          upstream URL
+//	X-Proxy-Method / method    upstream method, default POST
+//	X-Proxy-Header-<Name>      header sent upstream as <Name>
+//	header=<Name>: <value>     the same, as a repeatable query parameter
+//
+// The request's own Content-Type, including any multipart boundary, is sent
+// upstream unless a X-Proxy-Header-Content-Type overrides it.
+const (
+        proxyHeaderPrefix  = "X-Proxy-Header-"
+        proxyStreamTimeout = 10 * time.Minute
+)
+
+// proxyRequest is an upstream request as described by the client, from
+// either request mode.
+type proxyRequest struct {
+        URL      string
+        Method   string
+        Headers  map[string]string
+        Body     io.Reader
+        BodySize int64 // -1 if unknown
+        Stream   bool
+}
+
+// streamProxyRequest reads a streaming mode request from r. It reports false
+// if r names no target, meaning the body is a JSON request.
+func streamProxyRequest(r *http.Request) (proxyRequest, bool) {
+        query := r.URL.Query()
+        target := r.Header.Get("X-Proxy-Url")
+        if target == "" {
+                target = query.Get("url")
+        }
+        if target == "" {
+                return proxyRequest{}, false
+        }
+
+        method := r.Header.Get("X-Proxy-Method")
+        if method == "" {
+                method = query.Get("method")
+        }
+        if method == "" {
+                method = "POST"
+        }
+
+        headers := map[string]string{}
+        if ct := r.Header.Get("Content-Type"); ct != "" {
+                headers["Content-Type"] = ct
+        }
+        for _, h := range query["header"] {
+                if name, value, ok := strings.Cut(h, ":"); ok {
+                        headers[http.CanonicalHeaderKey(strings.TrimSpace(name))] = strings.TrimSpace(value)
+                }
+        }
+        for name, values := range r.Header {
+                if strings.HasPrefix(name, proxyHeaderPrefix) && len(name) > len(proxyHeaderPrefix) && len(values) > 0 {
+                        headers[http.CanonicalHeaderKey(strings.TrimPrefix(name, proxyHeaderPrefix))] = values[0]
+                }
+        }
+
+        req := proxyRequest{
+                URL:      target,
+                Method:   method,
+                Headers:  headers,
+                Body:     r.Body,
+                BodySize: r.ContentLength,
+                Stream:   true,
+        }
+        if r.ContentLength == 0 {
+                req.Body = nil
+        }
+        return req, true
+}
+
+// extendStreamDeadlines lifts the server's read and write timeouts for one
+// streamed exchange, which may take longer than an ordinary API call.
+func extendStreamDeadlines(w http.ResponseWriter) {
+        rc := http.NewResponseController(w)
+        deadline := time.Now().Add(proxyStreamTimeout)
+        rc.SetReadDeadline(deadline)
+        rc.SetWriteDeadline(deadline)
+}
+
+// cappedReader fails with err once more than n bytes have been read, rather
+// than ending the stream early as io.LimitReader would.
+type cappedReader struct {
+        r   io.Reader
+        n   int64
+        err error
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+        if c.n < 0 {
+                return 0, c.err
+        }
+        if int64(len(p)) > c.n+1 {
+                p = p[:c.n+1]
+        }
+        n, err := c.r.Read(p)
+        c.n -= int64(n)
+        if c.n < 0 {
+                return n, c.err
+        }
+        return n, err
+}
+
+// limitRequestBody enforces MaxRequestBytes on a body of unknown length while
+// it is sent.
+func (p ProxyHostPolicy) limitRequestBody(body io.Reader) io.Reader {
+        if p.MaxRequestBytes == 0 || body == nil {
+                return body
+        }
+        return &cappedReader{
+                r:   body,
+                n:   p.MaxRequestBytes,
+                err: policyErrorf("request body exceeds the %d byte limit for %s", p.MaxRequestBytes, p.Host),
+        }
+}
+
+// limitStreamResponse is limitResponse for streamed responses: a body of
+// unknown length is cut off with an error at the limit instead of being
+// buffered first.
+func (p ProxyHostPolicy) limitStreamResponse(resp *http.Response) (io.Reader, error) {
+        if p.MaxResponseBytes == 0 || resp.ContentLength >= 0 {
+                return p.limitResponse(resp)
+        }
+        return &cappedReader{
+                r:   resp.Body,
+                n:   p.MaxResponseBytes,
+                err: policyErrorf("response from %s exceeds the %d byte limit", p.Host, p.MaxResponseBytes),
+        }, nil
+}
+
+// flushWriter sends each write on to the client immediately.
+type flushWriter struct {
+        w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+        n, err := f.w.Write(p)
+        if flusher, ok := f.w.(http.Flusher); ok {
+                flusher.Flush()
+        }
+        return n, err
+}
+
+// streamProxyResponse copies body to w as it arrives. Writes block while the
+// client is not reading, which in turn stops reads from upstream. If the
+// copy fails, the connection is aborted so the client cannot mistake a
+// truncated body for a complete one.
+func streamProxyResponse(w http.ResponseWriter, body io.Reader) {
+        if _, err := io.Copy(flushWriter{w}, body); err != nil {
+                panic(http.ErrAbortHandler)
+        }
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+        "context"
+        "errors"
+        "fmt"
+        "net"
+        "net/http"
+        "net/url"
+        "strings"
+        "time"
+)
+
+// Upstream requests from /api/proxy and /api/imgbb go through a transport
+// that resolves names itself and only dials addresses it has vetted, so an
+// allowed host name cannot be pointed at the server's own network. Each
+// redirect hop must again be an allowed host. Addresses in
+// PROXY_ALLOWED_NETWORKS are exempt from the blocked ranges.
+const (
+        maxProxyRedirects = 10
+        proxyDialTimeout  = 10 * time.Second
+)
+
+// blockedProxyNetworks are ranges proxied requests may not reach, beyond
+// what net.IP already classifies as loopback, private or link-local.
+var blockedProxyNetworks = mustParseCIDRs(
+        "0.0.0.0/8",         // "this" network
+        "100.64.0.0/10",     // carrier-grade NAT, also Alibaba Cloud metadata
+        "169.254.0.0/16",    // link-local, cloud metadata (169.254.169.254)
+        "192.0.0.0/24",      // IETF protocol assignments
+        "198.18.0.0/15",     // benchmarking
+        "240.0.0.0/4",       // reserved, broadcast
+        "64:ff9b::/96",      // NAT64, embeds IPv4 addresses
+        "fd00:ec2::254/128", // AWS IPv6 metadata
+)
+
+var errProxyRedirectBlocked = errors.New("redirect to a host not in the whitelist")
+
+// blockedAddrError reports a dial refused because of the target address.
+type blockedAddrError struct {
+        host string
+        ip   net.IP
+}
+
+func (e *blockedAddrError) Error() string {
+        return fmt.Sprintf("%s resolves to blocked address %s", e.host, e.ip)
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+        nets := make([]*net.IPNet, 0, len(cidrs))
+        for _, cidr := range cidrs {
+                _, n, err := net.ParseCIDR(cidr)
+                if err != nil {
+                        panic(err)
+                }
+                nets = append(nets, n)
+        }
+        return nets
+}
+
+// parseNetwork accepts a CIDR or a single IP address.
+func parseNetwork(s string) (*net.IPNet, error) {
+        if ip := net.ParseIP(s); ip != nil {
+                bits := 8 * net.IPv4len
+                if ip.To4() == nil {
+                        bits = 8 * net.IPv6len
+                }
+                return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+        }
+        _, n, err := net.ParseCIDR(s)
+        return n, err
+}
+
+func isBlockedProxyIP(ip net.IP) bool {
+        if ip4 := ip.To4(); ip4 != nil {
+                ip = ip4
+        }
+        if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+                ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+                return true
+        }
+        for _, n := range blockedProxyNetworks {
+                if n.Contains(ip) {
+                        return true
+                }
+        }
+        return false
+}
+
+func isAllowedProxyNetwork(ip net.IP) bool {
+        appConfigLock.RLock()
+        defer appConfigLock.RUnlock()
+
+        for _, s := range appConfig.ProxyAllowedNetworks {
+                if n, err := parseNetwork(s); err == nil && n.Contains(ip) {
+                        return true
+                }
+        }
+        return false
+}
+
+// safeDialContext resolves addr, refuses blocked addresses and dials the
+// first acceptable one directly, so the connection goes to the address that
+// was checked rather than to a second lookup's answer.
+func safeDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+        host, port, err := net.SplitHostPort(addr)
+        if err != nil {
+                return nil, err
+        }
+
+        var ips []net.IP
+        if ip := net.ParseIP(host); ip != nil {
+                ips = []net.IP{ip}
+        } else {
+                addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+                if err != nil {
+                        return nil, err
+                }
+                for _, a := range addrs {
+                        ips = append(ips, a.IP)
+                }
+        }
+
+        dialer := &net.Dialer{Timeout: proxyDialTimeout}
+        var lastErr error
+        for _, ip := range ips {
+                if isBlockedProxyIP(ip) && !isAllowedProxyNetwork(ip) {
+                        lastErr = &blockedAddrError{host: host, ip: ip}
+                        continue
+                }
+                conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+                if err == nil {
+                        return conn, nil
+                }
+                lastErr = err
+        }
+        if lastErr == nil {
+                lastErr = fmt.Errorf("no addresses for %s", host)
+        }
+        return nil, lastErr
+}
+
+// proxyTransport is shared so upstream connections are reused. It ignores
+// HTTP_PROXY and friends: a forward proxy would do its own resolving.
+var proxyTransport = &http.Transport{
+        DialContext:           safeDialContext,
+        ForceAttemptHTTP2:     true,
+        MaxIdleConns:          100,
+        IdleConnTimeout:       90 * time.Second,
+        TLSHandshakeTimeout:   10 * time.Second,
+        ExpectContinueTimeout: 1 * time.Second,
+}
+
+func checkProxyRedirect(req *http.Request, via []*http.Request) error {
+        if len(via) >= maxProxyRedirects {
+                return fmt.Errorf("stopped after %d redirects", maxProxyRedirects)
+        }
+        policy, ok := proxyHostPolicy(req.URL.Host)
+        if (req.URL.Scheme != "http" && req.URL.Scheme != "https") || !ok {
+                return fmt.Errorf("%w: %s", errProxyRedirectBlocked, req.URL.Host)
+        }
+        if err := policy.checkTarget(req.Method, req.URL); err != nil {
+                return err
+        }
+
+        // Credentials belong to the host they were injected for.
+        if prev := via[len(via)-1].URL.Host; !strings.EqualFold(prev, req.URL.Host) {
+                stripCredentialHeaders(req, prev)
+        }
+        _, err := injectCredentials(req)
+        return err
+}
+
+func newProxyClient(timeout time.Duration) *http.Client {
+        return &http.Client{
+                Transport:     proxyTransport,
+                CheckRedirect: checkProxyRedirect,
+                Timeout:       timeout,
+        }
+}
+
+// writeProxyError answers a failed upstream request: 403 when the SSRF
+// checks or a host policy refused it, 502 otherwise. The "Post \"<url>\": "
+// prefix of a *url.Error is always dropped, since the URL may carry a
+// credential injected into its query or path.
+func writeProxyError(w http.ResponseWriter, prefix string, err error) {
+        var urlErr *url.Error
+        if errors.As(err, &urlErr) {
+                err = urlErr.Err
+        }
+
+        var blocked *blockedAddrError
+        var policy *proxyPolicyError
+        if errors.As(err, &blocked) || errors.As(err, &policy) || errors.Is(err, errProxyRedirectBlocked) {
+                http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+                return
+        }
+        http.Error(w, prefix+err.Error(), http.StatusBadGateway)
+}
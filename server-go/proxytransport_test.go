@@ -0,0 +1,335 @@
+package main
+
+import (
+        "bytes"
+        "compress/gzip"
+        "context"
+        "crypto/rand"
+        "errors"
+        "io"
+        "net"
+        "net/http"
+        "net/http/httptest"
+        "net/url"
+        "strconv"
+        "strings"
+        "testing"
+)
+
+// withProxyConfig makes cfg the live config for the duration of the test.
+func withProxyConfig(t *testing.T, cfg AppConfig) {
+        t.Helper()
+        previous := currentAppConfig()
+        setAppConfig(cfg)
+        t.Cleanup(func() { setAppConfig(previous) })
+}
+
+// withSecretsKey loads a throwaway key so secrets can be sealed and opened.
+func withSecretsKey(t *testing.T) {
+        t.Helper()
+        previous := secretsKey
+        secretsKey = make([]byte, configKeyLen)
+        if _, err := rand.Read(secretsKey); err != nil {
+                t.Fatal(err)
+        }
+        t.Cleanup(func() { secretsKey = previous })
+}
+
+func TestIsBlockedProxyIP(t *testing.T) {
+        tests := []struct {
+                ip   string
+                want bool
+        }{
+                {"8.8.8.8", false},
+                {"1.1.1.1", false},
+                {"2606:4700:4700::1111", false},
+                {"127.0.0.1", true},
+                {"127.8.9.10", true},
+                {"::1", true},
+                {"::ffff:127.0.0.1", true},
+                {"10.1.2.3", true},
+                {"172.16.0.1", true},
+                {"172.31.255.255", true},
+                {"172.32.0.1", false},
+                {"192.168.1.1", true},
+                {"fd12:3456::1", true},
+                {"0.0.0.0", true},
+                {"0.1.2.3", true},
+                {"::", true},
+                {"169.254.169.254", true},
+                {"fe80::1", true},
+                {"100.64.0.1", true},
+                {"100.100.100.200", true},
+                {"100.128.0.1", false},
+                {"192.0.0.8", true},
+                {"198.18.0.1", true},
+                {"198.20.0.1", false},
+                {"224.0.0.1", true},
+                {"ff02::1", true},
+                {"240.0.0.1", true},
+                {"255.255.255.255", true},
+                {"64:ff9b::7f00:1", true},
+                {"fd00:ec2::254", true},
+        }
+        for _, tt := range tests {
+                t.Run(tt.ip, func(t *testing.T) {
+                        ip := net.ParseIP(tt.ip)
+                        if ip == nil {
+                                t.Fatalf("bad test IP %q", tt.ip)
+                        }
+                        if got := isBlockedProxyIP(ip); got != tt.want {
+                                t.Errorf("isBlockedProxyIP(%s) = %v, want %v", tt.ip, got, tt.want)
+                        }
+                })
+        }
+}
+
+func TestSafeDialContextAllowedNetworks(t *testing.T) {
+        srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+        defer srv.Close()
+        addr := srv.Listener.Addr().String()
+
+        tests := []struct {
+                name    string
+                allowed []string
+                blocked bool
+        }{
+                {"loopback blocked by default", nil, true},
+                {"other network allowed", []string{"10.0.0.0/8"}, true},
+                {"allowed by CIDR", []string{"127.0.0.0/8"}, false},
+                {"allowed by single address", []string{"127.0.0.1"}, false},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        withProxyConfig(t, AppConfig{ProxyAllowedNetworks: tt.allowed})
+
+                        conn, err := safeDialContext(context.Background(), "tcp", addr)
+                        if conn != nil {
+                                conn.Close()
+                        }
+                        var blocked *blockedAddrError
+                        if got := errors.As(err, &blocked); got != tt.blocked {
+                                t.Errorf("blocked = %v (err %v), want %v", got, err, tt.blocked)
+                        }
+                        if !tt.blocked && err != nil {
+                                t.Errorf("dial failed: %v", err)
+                        }
+                })
+        }
+}
+
+func TestCheckProxyRedirect(t *testing.T) {
+        withSecretsKey(t)
+        sealed, err := sealSecret([]byte("k3y"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        withProxyConfig(t, AppConfig{
+                AllowedProxyHosts: []ProxyHostPolicy{
+                        {Host: "api.example.com"},
+                        {Host: "other.example.com"},
+                        {Host: "strict.example.com", HTTPSOnly: true, PathPrefixes: []string{"/v1"}},
+                },
+                ProxyCredentials: map[string]string{"key": sealed},
+                ProxyCredentialRules: []CredentialRule{
+                        {Host: "api.example.com", Credential: "key", In: "header", Name: "X-Api-Key"},
+                },
+        })
+
+        tests := []struct {
+                name       string
+                from       string
+                to         string
+                hops       int
+                wantErr    bool
+                wantPolicy bool
+                wantKey    string
+        }{
+                {"same host", "https://api.example.com/a", "https://api.example.com/b", 1, false, false, "k3y"},
+                {"other allowed host", "https://api.example.com/a", "https://other.example.com/b", 1, false, false, ""},
+                {"back to credential host", "https://other.example.com/a", "https://api.example.com/b", 1, false, false, "k3y"},
+                {"host not in whitelist", "https://api.example.com/a", "https://evil.example.com/", 1, true, false, ""},
+                {"port changes the host", "https://api.example.com/a", "https://api.example.com:8443/", 1, true, false, ""},
+                {"non-http scheme", "https://api.example.com/a", "ftp://api.example.com/", 1, true, false, ""},
+                {"policy path refused", "https://api.example.com/a", "https://strict.example.com/admin", 1, true, true, ""},
+                {"policy https only", "https://api.example.com/a", "http://strict.example.com/v1/x", 1, true, true, ""},
+                {"policy allows", "https://api.example.com/a", "https://strict.example.com/v1/x", 1, false, false, ""},
+                {"too many redirects", "https://api.example.com/a", "https://api.example.com/b", maxProxyRedirects, true, false, ""},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        prev := httptest.NewRequest("GET", tt.from, nil)
+                        prev.Header.Set("X-Api-Key", "k3y")
+                        via := make([]*http.Request, tt.hops)
+                        for i := range via {
+                                via[i] = prev
+                        }
+
+                        target, _ := url.Parse(tt.to)
+                        req := &http.Request{Method: "GET", URL: target, Host: target.Host, Header: prev.Header.Clone()}
+                        err := checkProxyRedirect(req, via)
+                        if (err != nil) != tt.wantErr {
+                                t.Fatalf("checkProxyRedirect() error = %v, wantErr %v", err, tt.wantErr)
+                        }
+                        var policy *proxyPolicyError
+                        if tt.wantErr && errors.As(err, &policy) != tt.wantPolicy {
+                                t.Errorf("policy error = %v, want %v (err %v)", !tt.wantPolicy, tt.wantPolicy, err)
+                        }
+                        if tt.wantErr {
+                                return
+                        }
+                        if got := req.Header.Get("X-Api-Key"); got != tt.wantKey {
+                                t.Errorf("X-Api-Key = %q, want %q", got, tt.wantKey)
+                        }
+                })
+        }
+}
+
+func TestProxyClientRedirects(t *testing.T) {
+        var srv *httptest.Server
+        srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                switch r.URL.Path {
+                case "/ok":
+                        w.Write([]byte("done"))
+                case "/self":
+                        http.Redirect(w, r, "/ok", http.StatusFound)
+                case "/alias":
+                        // Same server under a name that is not whitelisted.
+                        http.Redirect(w, r, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)+"/ok", http.StatusFound)
+                case "/external":
+                        http.Redirect(w, r, "http://evil.example.com/", http.StatusFound)
+                case "/loop":
+                        http.Redirect(w, r, "/loop", http.StatusFound)
+                }
+        }))
+        defer srv.Close()
+
+        withProxyConfig(t, AppConfig{
+                AllowedProxyHosts:    []ProxyHostPolicy{{Host: srv.Listener.Addr().String()}},
+                ProxyAllowedNetworks: []string{"127.0.0.1"},
+        })
+
+        tests := []struct {
+                path        string
+                wantBlocked bool
+                wantErr     bool
+        }{
+                {"/ok", false, false},
+                {"/self", false, false},
+                {"/alias", true, true},
+                {"/external", true, true},
+                {"/loop", false, true},
+        }
+        for _, tt := range tests {
+                t.Run(tt.path, func(t *testing.T) {
+                        resp, err := newProxyClient(0).Get(srv.URL + tt.path)
+                        if resp != nil {
+                                resp.Body.Close()
+                        }
+                        if (err != nil) != tt.wantErr {
+                                t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+                        }
+                        if got := errors.Is(err, errProxyRedirectBlocked); got != tt.wantBlocked {
+                                t.Errorf("redirect blocked = %v, want %v (err %v)", got, tt.wantBlocked, err)
+                        }
+                })
+        }
+}
+
+func TestProxyErrorHidesInjectedCredentials(t *testing.T) {
+        withSecretsKey(t)
+        sealed, err := sealSecret([]byte("t0p-s3cret"))
+        if err != nil {
+                t.Fatal(err)
+        }
+
+        // A port nothing listens on, so the upstream request fails.
+        ln, err := net.Listen("tcp", "127.0.0.1:0")
+        if err != nil {
+                t.Fatal(err)
+        }
+        host := ln.Addr().String()
+        ln.Close()
+
+        tests := []struct {
+                name string
+                rule CredentialRule
+                path string
+        }{
+                {"query", CredentialRule{Host: host, Credential: "key", In: "query", Name: "key"}, "/upload"},
+                {"url", CredentialRule{Host: host, Credential: "key", In: "url"}, "/bot{secret}/send"},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        withProxyConfig(t, AppConfig{
+                                AllowedProxyHosts:    []ProxyHostPolicy{{Host: host}},
+                                ProxyAllowedNetworks: []string{"127.0.0.1"},
+                                ProxyCredentials:     map[string]string{"key": sealed},
+                                ProxyCredentialRules: []CredentialRule{tt.rule},
+                                OriginValidation:     OriginValidationConfig{Mode: "disabled"},
+                        })
+
+                        body := `{"url":"http://` + host + tt.path + `"}`
+                        r := httptest.NewRequest("POST", "/api/proxy", strings.NewReader(body))
+                        w := httptest.NewRecorder()
+                        handleProxy(w, r)
+
+                        if w.Code != http.StatusBadGateway {
+                                t.Fatalf("status = %d (%q), want 502", w.Code, w.Body.String())
+                        }
+                        if strings.Contains(w.Body.String(), "t0p-s3cret") {
+                                t.Errorf("response leaks the credential: %q", w.Body.String())
+                        }
+                })
+        }
+}
+
+func TestProxyResponseNotRecompressed(t *testing.T) {
+        const payload = `{"ok":true,"padding":"` + "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa" + `"}`
+        var compressed bytes.Buffer
+        gz := gzip.NewWriter(&compressed)
+        gz.Write([]byte(payload))
+        gz.Close()
+
+        srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+                w.Header().Set("Content-Type", "application/json")
+                w.Header().Set("Content-Encoding", "gzip")
+                w.Header().Set("Content-Length", strconv.Itoa(compressed.Len()))
+                w.Write(compressed.Bytes())
+        }))
+        defer srv.Close()
+
+        withProxyConfig(t, AppConfig{
+                AllowedProxyHosts:    []ProxyHostPolicy{{Host: srv.Listener.Addr().String()}},
+                ProxyAllowedNetworks: []string{"127.0.0.1"},
+                OriginValidation:     OriginValidationConfig{Mode: "disabled"},
+        })
+
+        body := `{"url":"` + srv.URL + `/data","headers":{"Accept-Encoding":"gzip"}}`
+        r := httptest.NewRequest("POST", "/api/proxy", strings.NewReader(body))
+        r.Header.Set("Accept-Encoding", "gzip")
+        w := httptest.NewRecorder()
+        gzipMiddleware(http.HandlerFunc(handleProxy), true).ServeHTTP(w, r)
+
+        if w.Code != http.StatusOK {
+                t.Fatalf("status = %d (%q), want 200", w.Code, w.Body.String())
+        }
+        if got := w.Header().Values("Content-Encoding"); len(got) != 1 || got[0] != "gzip" {
+                t.Errorf("Content-Encoding = %q, want a single gzip", got)
+        }
+        if got := w.Header().Get("Content-Length"); got != strconv.Itoa(w.Body.Len()) {
+                t.Errorf("Content-Length = %q, body is %d bytes", got, w.Body.Len())
+        }
+
+        zr, err := gzip.NewReader(w.Body)
+        if err != nil {
+                t.Fatal(err)
+        }
+        plain, err := io.ReadAll(zr)
+        if err != nil {
+                t.Fatal(err)
+        }
+        if string(plain) != payload {
+                t.Errorf("body = %q, want %q", plain, payload)
+        }
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+        "crypto/aes"
+        "crypto/cipher"
+        "crypto/rand"
+        "encoding/base64"
+        "fmt"
+        "log"
+        "os"
+        "sort"
+        "strings"
+)
+
+// Secrets the server must read back, unlike the hashed unlock values, are
+// stored in the config as "sealed$<nonce>$<ciphertext>": AES-256-GCM under
+// the key in secrets.key. The key file is created on first start and is
+// itself encrypted when a config key is set, so a copied config file alone
+// does not reveal these secrets.
+const (
+        sealedSecretPrefix = "sealed$"
+        secretsKeyFileName = "secrets.key"
+)
+
+var secretsKey []byte
+
+// loadSecretsKey reads the field encryption key, creating it on first use.
+func loadSecretsKey(path string) ([]byte, error) {
+        data, err := os.ReadFile(path)
+        if os.IsNotExist(err) {
+                key := make([]byte, configKeyLen)
+                if _, err := rand.Read(key); err != nil {
+                        return nil, err
+                }
+                sealed, err := sealConfigData([]byte(base64.StdEncoding.EncodeToString(key) + "\n"))
+                if err != nil {
+                        return nil, err
+                }
+                if err := writeFileAtomic(path, sealed, 0600); err != nil {
+                        return nil, err
+                }
+                log.Printf("Generated secrets key %s", path)
+                return key, nil
+        }
+        if err != nil {
+                return nil, err
+        }
+
+        plain, _, err := openConfigData(data)
+        if err != nil {
+                return nil, err
+        }
+        key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(plain)))
+        if err != nil || len(key) != configKeyLen {
+                return nil, fmt.Errorf("%s does not hold a base64 %d-byte key", path, configKeyLen)
+        }
+        return key, nil
+}
+
+func isSealedSecret(v string) bool {
+        return strings.HasPrefix(v, sealedSecretPrefix)
+}
+
+func secretsGCM() (cipher.AEAD, error) {
+        if secretsKey == nil {
+                return nil, fmt.Errorf("no secrets key is loaded")
+        }
+        block, err := aes.NewCipher(secretsKey)
+        if err != nil {
+                return nil, err
+        }
+        return cipher.NewGCM(block)
+}
+
+func sealSecret(plain []byte) (string, error) {
+        gcm, err := secretsGCM()
+        if err != nil {
+                return "", err
+        }
+        nonce := make([]byte, gcm.NonceSize())
+        if _, err := rand.Read(nonce); err != nil {
+                return "", err
+        }
+        sealed := gcm.Seal(nil, nonce, plain, []byte(sealedSecretPrefix))
+        return sealedSecretPrefix + b64.EncodeToString(nonce) + "$" + b64.EncodeToString(sealed), nil
+}
+
+func openSecret(v string) ([]byte, error) {
+        nonceText, sealedText, ok := strings.Cut(strings.TrimPrefix(v, sealedSecretPrefix), "$")
+        if !isSealedSecret(v) || !ok {
+                return nil, fmt.Errorf("malformed sealed secret")
+        }
+        nonce, err1 := b64.DecodeString(nonceText)
+        sealed, err2 := b64.DecodeString(sealedText)
+        if err1 != nil || err2 != nil {
+                return nil, fmt.Errorf("malformed sealed secret")
+        }
+
+        gcm, err := secretsGCM()
+        if err != nil {
+                return nil, err
+        }
+        if len(nonce) != gcm.NonceSize() {
+                return nil, fmt.Errorf("malformed sealed secret")
+        }
+        plain, err := gcm.Open(nil, nonce, sealed, []byte(sealedSecretPrefix))
+        if err != nil {
+                return nil, fmt.Errorf("sealed with a different secrets key")
+        }
+        return plain, nil
+}
+
+// checkSealedSecrets tries to open every sealed value in cfg. A value that
+// fails was sealed under another secrets.key, for example after the config
+// was copied without it, and the feature using it will not work.
+func checkSealedSecrets(cfg AppConfig) validationErrors {
+        var errs validationErrors
+        names := make([]string, 0, len(cfg.ProxyCredentials))
+        for name := range cfg.ProxyCredentials {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+                if _, err := openSecret(cfg.ProxyCredentials[name]); err != nil {
+                        errs.add("PROXY_CREDENTIALS."+name, "cannot be opened: %v", err)
+                }
+        }
+        if cfg.UnlockTOTPSecret != "" {
+                if _, err := openSecret(cfg.UnlockTOTPSecret); err != nil {
+                        errs.add("UNLOCK_TOTP_SECRET", "cannot be opened: %v", err)
+                }
+        }
+        return errs
+}
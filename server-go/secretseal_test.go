@@ -0,0 +1,47 @@
+package main
+
+import (
+        "reflect"
+        "testing"
+)
+
+func TestCheckSealedSecrets(t *testing.T) {
+        // foreign is sealed under a key the server no longer has.
+        withSecretsKey(t)
+        foreign, err := sealSecret([]byte("value"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        withSecretsKey(t)
+        good, err := sealSecret([]byte("value"))
+        if err != nil {
+                t.Fatal(err)
+        }
+
+        tests := []struct {
+                name string
+                cfg  AppConfig
+                want []string
+        }{
+                {"nothing stored", AppConfig{}, nil},
+                {"all readable", AppConfig{
+                        ProxyCredentials: map[string]string{"a": good, "b": good},
+                        UnlockTOTPSecret: good,
+                }, nil},
+                {"foreign and malformed values", AppConfig{
+                        ProxyCredentials: map[string]string{"c": foreign, "a": good, "b": "sealed$bad"},
+                        UnlockTOTPSecret: foreign,
+                }, []string{"PROXY_CREDENTIALS.b", "PROXY_CREDENTIALS.c", "UNLOCK_TOTP_SECRET"}},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        var got []string
+                        for _, e := range checkSealedSecrets(tt.cfg) {
+                                got = append(got, e.Field)
+                        }
+                        if !reflect.DeepEqual(got, tt.want) {
+                                t.Errorf("checkSealedSecrets() fields = %v, want %v", got, tt.want)
+                        }
+                })
+        }
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+        "crypto/hmac"
+        "crypto/rand"
+        "crypto/sha256"
+        "encoding/base64"
+        "encoding/json"
+        "fmt"
+        "log"
+        "net/http"
+        "os"
+        "strings"
+        "time"
+)
+
+// A successful unlock issues a session token, "<payload>.<mac>", where the
+// payload is base64url JSON and the mac is its HMAC-SHA256 under the key in
+// session.key. Sessions expire AUTO_LOCK_MINUTES after they were issued or
+// last renewed by a heartbeat; photo upload and proxy routes require one
+// while privacy mode is on.
+const (
+        unlockSessionCookie = "camroid_session"
+        unlockSessionHeader = "X-Unlock-Session"
+        sessionKeyFileName  = "session.key"
+        sessionKeyLen       = 32
+)
+
+type unlockSession struct {
+        Device  string `json:"dev,omitempty"`
+        Profile string `json:"prof,omitempty"`
+        Expires int64  `json:"exp"`
+}
+
+var sessionKey []byte
+
+// loadSessionKey reads the session signing key, creating it on first use.
+// Like the bundle signing key it is stored encrypted when a key is set.
+func loadSessionKey(path string) ([]byte, error) {
+        data, err := os.ReadFile(path)
+        if os.IsNotExist(err) {
+                key := make([]byte, sessionKeyLen)
+                if _, err := rand.Read(key); err != nil {
+                        return nil, err
+                }
+                sealed, err := sealConfigData([]byte(base64.StdEncoding.EncodeToString(key) + "\n"))
+                if err != nil {
+                        return nil, err
+                }
+                if err := writeFileAtomic(path, sealed, 0600); err != nil {
+                        return nil, err
+                }
+                log.Printf("Generated unlock session key %s", path)
+                return key, nil
+        }
+        if err != nil {
+                return nil, err
+        }
+
+        plain, _, err := openConfigData(data)
+        if err != nil {
+                return nil, err
+        }
+        key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(plain)))
+        if err != nil || len(key) != sessionKeyLen {
+                return nil, fmt.Errorf("%s does not hold a base64 %d-byte key", path, sessionKeyLen)
+        }
+        return key, nil
+}
+
+func sessionMAC(payload string) []byte {
+        mac := hmac.New(sha256.New, sessionKey)
+        mac.Write([]byte(payload))
+        return mac.Sum(nil)
+}
+
+func signSession(s unlockSession) string {
+        data, _ := json.Marshal(s)
+        payload := base64.RawURLEncoding.EncodeToString(data)
+        return payload + "." + base64.RawURLEncoding.EncodeToString(sessionMAC(payload))
+}
+
+// parseSession returns the session in token if its signature is valid and
+// it has not expired.
+func parseSession(token string) (unlockSession, bool) {
+        var s unlockSession
+        payload, sig, ok := strings.Cut(token, ".")
+        if !ok || sessionKey == nil {
+                return s, false
+        }
+
+        mac, err := base64.RawURLEncoding.DecodeString(sig)
+        if err != nil || !hmac.Equal(mac, sessionMAC(payload)) {
+                return s, false
+        }
+        data, err := base64.RawURLEncoding.DecodeString(payload)
+        if err != nil || json.Unmarshal(data, &s) != nil {
+                return s, false
+        }
+        return s, time.Now().Unix() < s.Expires
+}
+
+// sessionLifetime is AUTO_LOCK_MINUTES, or the longest allowed auto-lock
+// when auto-lock is off.
+func sessionLifetime(cfg AppConfig) time.Duration {
+        minutes := cfg.AutoLockMinutes
+        if minutes == 0 {
+                minutes = maxAutoLockMinutes
+        }
+        return time.Duration(minutes) * time.Minute
+}
+
+// issueUnlockSession sets a new session cookie for the requesting device and
+// returns the token and its expiry for clients that send it as a header.
+// The session is bound to the profile whose secrets unlocked it.
+func issueUnlockSession(w http.ResponseWriter, r *http.Request, cfg AppConfig, profile string) (string, time.Time) {
+        expires := time.Now().Add(sessionLifetime(cfg)).Truncate(time.Second)
+        token := signSession(unlockSession{
+                Device:  requestDeviceID(r),
+                Profile: profile,
+                Expires: expires.Unix(),
+        })
+
+        http.SetCookie(w, &http.Cookie{
+                Name:     unlockSessionCookie,
+                Value:    token,
+                Path:     "/api/",
+                Expires:  expires,
+                HttpOnly: true,
+                Secure:   requestIsHTTPS(r),
+                SameSite: http.SameSiteStrictMode,
+        })
+        return token, expires.UTC()
+}
+
+// hasUnlockSession reports whether r carries a valid session, in the
+// X-Unlock-Session header or the session cookie. A session issued to a
+// device is only accepted with the same X-Device-Id, and only while the
+// request resolves to the profile it was unlocked under.
+func hasUnlockSession(r *http.Request, profile string) bool {
+        token := r.Header.Get(unlockSessionHeader)
+        if token == "" {
+                if cookie, err := r.Cookie(unlockSessionCookie); err == nil {
+                        token = cookie.Value
+                }
+        }
+
+        s, ok := parseSession(token)
+        return ok && s.Device == requestDeviceID(r) && s.Profile == profile
+}
+
+// requireUnlockSession guards a route while privacy mode is on, in the base
+// config or in the profile the request resolves to; a profile picked by the
+// caller cannot switch it off.
+func requireUnlockSession(next http.HandlerFunc) http.HandlerFunc {
+        return func(w http.ResponseWriter, r *http.Request) {
+                base, _ := snapshotAppConfig()
+                cfg, _, _ := resolvedAppConfig(r)
+                _, profile := resolvedUnlockConfig(r)
+                if (base.PrivacyMode || cfg.PrivacyMode) && !hasUnlockSession(r, profile) {
+                        writeJSONError(w, http.StatusUnauthorized, "Unlocked session required")
+                        return
+                }
+                next(w, r)
+        }
+}
+
+// handleUnlockHeartbeat extends a valid session by another AUTO_LOCK_MINUTES.
+func handleUnlockHeartbeat(w http.ResponseWriter, r *http.Request) {
+        if r.Method != "POST" {
+                http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                return
+        }
+
+        cfg, profile := resolvedUnlockConfig(r)
+        if !hasUnlockSession(r, profile) {
+                writeJSONError(w, http.StatusUnauthorized, "Session expired or invalid")
+                return
+        }
+
+        token, expires := issueUnlockSession(w, r, cfg, profile)
+        writeJSON(w, http.StatusOK, map[string]interface{}{
+                "session":   token,
+                "expiresAt": expires,
+        })
+}
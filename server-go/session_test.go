@@ -0,0 +1,78 @@
+package main
+
+import (
+        "crypto/rand"
+        "net/http/httptest"
+        "testing"
+        "time"
+)
+
+func TestSelectUnlockProfileIgnoresHeader(t *testing.T) {
+        cfg := AppConfig{Profiles: map[string]ConfigProfile{
+                "field": {Hosts: []string{"field.example.com"}},
+                "weak":  {},
+        }}
+
+        tests := []struct {
+                name       string
+                host       string
+                header     string
+                wantConfig string
+                wantUnlock string
+        }{
+                {"no profile", "example.com", "", "", ""},
+                {"header only", "example.com", "weak", "weak", ""},
+                {"host", "field.example.com", "", "field", "field"},
+                {"header does not override host", "field.example.com", "weak", "weak", "field"},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        r := httptest.NewRequest("POST", "/api/unlock/verify", nil)
+                        r.Host = tt.host
+                        if tt.header != "" {
+                                r.Header.Set(configProfileHeader, tt.header)
+                        }
+                        if got := selectProfile(cfg, r); got != tt.wantConfig {
+                                t.Errorf("selectProfile() = %q, want %q", got, tt.wantConfig)
+                        }
+                        if got := selectUnlockProfile(cfg, r); got != tt.wantUnlock {
+                                t.Errorf("selectUnlockProfile() = %q, want %q", got, tt.wantUnlock)
+                        }
+                })
+        }
+}
+
+func TestUnlockSessionBinding(t *testing.T) {
+        previous := sessionKey
+        sessionKey = make([]byte, sessionKeyLen)
+        if _, err := rand.Read(sessionKey); err != nil {
+                t.Fatal(err)
+        }
+        t.Cleanup(func() { sessionKey = previous })
+
+        expires := time.Now().Add(time.Minute).Unix()
+        tests := []struct {
+                name    string
+                session unlockSession
+                device  string
+                profile string
+                want    bool
+        }{
+                {"same device and profile", unlockSession{Device: "d1", Profile: "field", Expires: expires}, "d1", "field", true},
+                {"base config", unlockSession{Device: "d1", Expires: expires}, "d1", "", true},
+                {"other device", unlockSession{Device: "d1", Expires: expires}, "d2", "", false},
+                {"profile session on base config", unlockSession{Device: "d1", Profile: "weak", Expires: expires}, "d1", "", false},
+                {"base session on a profile", unlockSession{Device: "d1", Expires: expires}, "d1", "field", false},
+                {"expired", unlockSession{Device: "d1", Expires: time.Now().Add(-time.Second).Unix()}, "d1", "", false},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        r := httptest.NewRequest("POST", "/api/proxy", nil)
+                        r.Header.Set(unlockSessionHeader, signSession(tt.session))
+                        r.Header.Set(deviceIDHeader, tt.device)
+                        if got := hasUnlockSession(r, tt.profile); got != tt.want {
+                                t.Errorf("hasUnlockSession() = %v, want %v", got, tt.want)
+                        }
+                })
+        }
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+        "crypto/hmac"
+        "crypto/rand"
+        "crypto/sha1"
+        "crypto/sha256"
+        "encoding/base32"
+        "encoding/binary"
+        "fmt"
+        "log"
+        "net/http"
+        "net/url"
+        "regexp"
+        "sync"
+        "time"
+
+        "github.com/skip2/go-qrcode"
+)
+
+// TOTP (RFC 6238) with the parameters authenticator apps assume: HMAC-SHA1,
+// six digits, 30-second steps. Codes one step either side of the current
+// one are accepted to allow for clock drift, and a code is only accepted
+// once, whichever device sends it.
+const (
+        totpUnlockTarget = "totp"
+        totpIssuer       = "Camroid"
+        totpSecretLen    = 20
+        totpDigits       = 6
+        totpPeriod       = 30
+        totpDriftSteps   = 1
+)
+
+var (
+        totpCodePattern       = regexp.MustCompile(`^[0-9]{6}$`)
+        calculatorTOTPPattern = regexp.MustCompile(`^([0-9]{6})=$`)
+        totpSecretEncoding    = base32.StdEncoding.WithPadding(base32.NoPadding)
+        totpMu                sync.Mutex
+        totpLastSteps         = map[[sha256.Size]byte]int64{}
+)
+
+// totpCode computes the HOTP value (RFC 4226) for one time step.
+func totpCode(secret []byte, step int64) string {
+        var counter [8]byte
+        binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+        mac := hmac.New(sha1.New, secret)
+        mac.Write(counter[:])
+        sum := mac.Sum(nil)
+
+        offset := sum[len(sum)-1] & 0x0f
+        value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+        return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// verifyTOTP checks code against the sealed secret within the drift window.
+// A step at or before the last one used with the secret is rejected.
+func verifyTOTP(sealed, code string) bool {
+        if sealed == "" || !totpCodePattern.MatchString(code) {
+                return false
+        }
+        secret, err := openSecret(sealed)
+        if err != nil {
+                log.Printf("Warning: Could not open TOTP secret: %v", err)
+                return false
+        }
+        return checkTOTP(secret, code, time.Now().Unix()/totpPeriod)
+}
+
+// checkTOTP matches code against the steps around now and records the step
+// it was accepted for. Used steps are tracked per secret, keyed by its
+// SHA-256 so the secret itself is not kept in memory.
+func checkTOTP(secret []byte, code string, now int64) bool {
+        totpMu.Lock()
+        defer totpMu.Unlock()
+
+        key := sha256.Sum256(secret)
+        for step := now - totpDriftSteps; step <= now+totpDriftSteps; step++ {
+                if step > totpLastSteps[key] && hmac.Equal([]byte(totpCode(secret, step)), []byte(code)) {
+                        totpLastSteps[key] = step
+                        return true
+                }
+        }
+        return false
+}
+
+// totpURI is the otpauth:// URI authenticator apps enroll from.
+func totpURI(secret []byte) string {
+        label := url.PathEscape(totpIssuer + ":unlock")
+        query := url.Values{
+                "secret":    {totpSecretEncoding.EncodeToString(secret)},
+                "issuer":    {totpIssuer},
+                "algorithm": {"SHA1"},
+                "digits":    {fmt.Sprint(totpDigits)},
+                "period":    {fmt.Sprint(totpPeriod)},
+        }
+        return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// handleTOTP enrolls a new TOTP secret (POST), replacing any previous one, or
+// removes it (DELETE). The secret is only ever returned by the enrollment
+// response.
+func handleTOTP(w http.ResponseWriter, r *http.Request) {
+        if r.Method != "POST" && r.Method != "DELETE" {
+                http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                return
+        }
+
+        current, release, ok := beginConfigUpdate(w, r)
+        if !ok {
+                return
+        }
+        defer release()
+
+        cfg, err := cloneAppConfig(current)
+        if err != nil {
+                writeJSONError(w, http.StatusInternalServerError, "Failed to read config")
+                return
+        }
+
+        var secret []byte
+        action := "totp.remove"
+        cfg.UnlockTOTPSecret = ""
+        if r.Method == "POST" {
+                action = "totp.enroll"
+                secret = make([]byte, totpSecretLen)
+                if _, err := rand.Read(secret); err != nil {
+                        writeJSONError(w, http.StatusInternalServerError, "Failed to generate secret")
+                        return
+                }
+                if cfg.UnlockTOTPSecret, err = sealSecret(secret); err != nil {
+                        log.Printf("Failed to seal TOTP secret: %v", err)
+                        writeJSONError(w, http.StatusInternalServerError, "Failed to store secret")
+                        return
+                }
+        }
+
+        if errs := validateAppConfig(&cfg); len(errs) > 0 {
+                writeValidationErrors(w, errs)
+                return
+        }
+
+        if err := commitAppConfig(r, action, cfg); err != nil {
+                log.Printf("Failed to save config: %v", err)
+                writeJSONError(w, http.StatusInternalServerError, "Failed to save config")
+                return
+        }
+
+        // Steps used with the old secret say nothing about the new one.
+        totpMu.Lock()
+        totpLastSteps = map[[sha256.Size]byte]int64{}
+        totpMu.Unlock()
+
+        if secret == nil {
+                w.WriteHeader(http.StatusNoContent)
+                return
+        }
+
+        uri := totpURI(secret)
+        qr, err := qrcode.New(uri, qrcode.Medium)
+        if err != nil {
+                writeJSONError(w, http.StatusInternalServerError, "Failed to render QR code")
+                return
+        }
+        writeJSON(w, http.StatusOK, map[string]interface{}{
+                "secret": totpSecretEncoding.EncodeToString(secret),
+                "uri":    uri,
+                "qr":     qr.ToSmallString(false),
+        })
+}
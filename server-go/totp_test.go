@@ -0,0 +1,95 @@
+package main
+
+import (
+        "crypto/sha256"
+        "testing"
+)
+
+func TestCheckTOTPReplay(t *testing.T) {
+        secret := []byte("12345678901234567890")
+        const now = 1000
+
+        type attempt struct {
+                step int64
+                at   int64
+                want bool
+        }
+        tests := []struct {
+                name     string
+                attempts []attempt
+        }{
+                {"code is accepted once", []attempt{
+                        {now, now, true},
+                        {now, now, false},
+                }},
+                {"later step after an earlier one", []attempt{
+                        {now - 1, now, true},
+                        {now, now, true},
+                        {now + 1, now, true},
+                }},
+                {"earlier step after a later one", []attempt{
+                        {now + 1, now, true},
+                        {now - 1, now, false},
+                        {now, now, false},
+                }},
+                {"code outside drift window", []attempt{
+                        {now - 2, now, false},
+                        {now + 2, now, false},
+                }},
+                {"used code stays used in the next step", []attempt{
+                        {now, now, true},
+                        {now, now + 1, false},
+                        {now + 1, now + 1, true},
+                }},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        totpLastSteps = map[[sha256.Size]byte]int64{}
+                        t.Cleanup(func() { totpLastSteps = map[[sha256.Size]byte]int64{} })
+
+                        for i, a := range tt.attempts {
+                                got := checkTOTP(secret, totpCode(secret, a.step), a.at)
+                                if got != a.want {
+                                        t.Errorf("attempt %d (step %d at %d): got %v, want %v", i, a.step, a.at, got, a.want)
+                                }
+                        }
+                })
+        }
+}
+
+func TestCheckTOTPPerSecret(t *testing.T) {
+        totpLastSteps = map[[sha256.Size]byte]int64{}
+        t.Cleanup(func() { totpLastSteps = map[[sha256.Size]byte]int64{} })
+
+        first := []byte("12345678901234567890")
+        second := []byte("abcdefghijabcdefghij")
+        const now = 1000
+        if !checkTOTP(first, totpCode(first, now), now) {
+                t.Fatal("first secret rejected")
+        }
+        if !checkTOTP(second, totpCode(second, now), now) {
+                t.Error("a used step for one secret blocked another secret")
+        }
+        if checkTOTP(first, totpCode(first, now), now) {
+                t.Error("replayed code accepted")
+        }
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+        // RFC 6238 appendix B, SHA-1, truncated to six digits.
+        secret := []byte("12345678901234567890")
+        tests := []struct {
+                unix int64
+                want string
+        }{
+                {59, "287082"},
+                {1111111109, "081804"},
+                {1234567890, "005924"},
+                {2000000000, "279037"},
+        }
+        for _, tt := range tests {
+                if got := totpCode(secret, tt.unix/totpPeriod); got != tt.want {
+                        t.Errorf("totpCode(%d) = %s, want %s", tt.unix, got, tt.want)
+                }
+        }
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+        "crypto/rand"
+        "crypto/sha256"
+        "crypto/subtle"
+        "encoding/base64"
+        "encoding/json"
+        "fmt"
+        "net/http"
+        "strconv"
+        "strings"
+        "sync"
+
+        "golang.org/x/crypto/scrypt"
+)
+
+// Unlock secrets (UNLOCK_PATTERN and MODULE_UNLOCK_VALUES) are persisted as
+// "scrypt$N$r$p$salt$hash" strings and are only ever checked server-side.
+const (
+        secretHashPrefix = "scrypt$"
+        scryptN          = 1 << 15
+        scryptR          = 8
+        scryptP          = 1
+        scryptSaltLen    = 16
+        scryptKeyLen     = 32
+
+        // Each derivation with these parameters takes 128*N*r bytes, 32 MiB,
+        // so only a few run at once; the rest wait for a slot.
+        maxConcurrentScrypt = 4
+)
+
+var scryptSlots = make(chan struct{}, maxConcurrentScrypt)
+
+// scryptKey is scrypt.Key, limited to maxConcurrentScrypt derivations at a
+// time.
+func scryptKey(password, salt []byte, n, r, p, keyLen int) ([]byte, error) {
+        scryptSlots <- struct{}{}
+        defer func() { <-scryptSlots }()
+        return scrypt.Key(password, salt, n, r, p, keyLen)
+}
+
+// patternUnlockTarget is the module id used with /api/unlock/verify to check
+// the universal UNLOCK_PATTERN instead of a per-module value.
+const patternUnlockTarget = "pattern"
+
+var b64 = base64.RawStdEncoding
+
+func isSecretHash(v string) bool {
+        return strings.HasPrefix(v, secretHashPrefix)
+}
+
+func hashSecret(plain string) (string, error) {
+        salt := make([]byte, scryptSaltLen)
+        if _, err := rand.Read(salt); err != nil {
+                return "", err
+        }
+
+        key, err := scryptKey([]byte(plain), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+        if err != nil {
+                return "", err
+        }
+
+        return fmt.Sprintf("%s%d$%d$%d$%s$%s", secretHashPrefix, scryptN, scryptR, scryptP, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func verifySecret(encoded, candidate string) bool {
+        parts := strings.Split(strings.TrimPrefix(encoded, secretHashPrefix), "$")
+        if !isSecretHash(encoded) || len(parts) != 5 {
+                return false
+        }
+
+        n, err1 := strconv.Atoi(parts[0])
+        r, err2 := strconv.Atoi(parts[1])
+        p, err3 := strconv.Atoi(parts[2])
+        salt, err4 := b64.DecodeString(parts[3])
+        want, err5 := b64.DecodeString(parts[4])
+        if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil {
+                return false
+        }
+
+        got, err := scryptKey([]byte(candidate), salt, n, r, p, len(want))
+        if err != nil {
+                return false
+        }
+
+        return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// hashUnlockSecret hashes a plaintext unlock value. Empty values mean "no
+// secret configured" and are stored as-is.
+func hashUnlockSecret(v string) (string, error) {
+        if v == "" || isSecretHash(v) {
+                return v, nil
+        }
+        return hashSecret(v)
+}
+
+// layerSecretHashes caches the hashes of plaintext secrets that come from the
+// defaults or the environment, keyed by a digest of the plaintext. Those
+// layers are rebuilt on every load and never written back, so without the
+// cache each load would hash them with new salts and change the ETag.
+var (
+        layerSecretHashesMu sync.Mutex
+        layerSecretHashes   = map[[sha256.Size]byte]string{}
+)
+
+// hashLayerSecret is hashSecret, returning the same hash for the same
+// plaintext for the life of the process.
+func hashLayerSecret(plain string) (string, error) {
+        key := sha256.Sum256([]byte(plain))
+
+        layerSecretHashesMu.Lock()
+        defer layerSecretHashesMu.Unlock()
+
+        if hashed, ok := layerSecretHashes[key]; ok {
+                return hashed, nil
+        }
+        hashed, err := hashSecret(plain)
+        if err != nil {
+                return "", err
+        }
+        layerSecretHashes[key] = hashed
+        return hashed, nil
+}
+
+// hashPlaintextSecrets upgrades any plaintext unlock values left in cfg from
+// older config files. It reports whether anything was rewritten.
+func hashPlaintextSecrets(cfg *AppConfig) (bool, error) {
+        return hashPlaintextSecretsWith(cfg, hashSecret)
+}
+
+func hashPlaintextSecretsWith(cfg *AppConfig, hash func(string) (string, error)) (bool, error) {
+        changed := false
+
+        if cfg.UnlockPattern != "" && !isSecretHash(cfg.UnlockPattern) {
+                hashed, err := hash(cfg.UnlockPattern)
+                if err != nil {
+                        return false, err
+                }
+                cfg.UnlockPattern = hashed
+                changed = true
+        }
+
+        for _, values := range []map[string]string{cfg.ModuleUnlockValues, cfg.ModuleDuressValues} {
+                for module, value := range values {
+                        if value == "" || isSecretHash(value) {
+                                continue
+                        }
+                        hashed, err := hash(value)
+                        if err != nil {
+                                return false, err
+                        }
+                        values[module] = hashed
+                        changed = true
+                }
+        }
+
+        for name, profile := range cfg.Profiles {
+                rewritten, err := hashProfileSecrets(&profile, hash)
+                if err != nil {
+                        return false, err
+                }
+                if rewritten {
+                        cfg.Profiles[name] = profile
+                        changed = true
+                }
+        }
+
+        return changed, nil
+}
+
+func handleUnlockVerify(w http.ResponseWriter, r *http.Request) {
+        if r.Method != "POST" {
+                http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+                return
+        }
+
+        var req struct {
+                Module string `json:"module"`
+                Value  string `json:"value"`
+        }
+
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+                writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+                return
+        }
+
+        if req.Module == "" {
+                writeJSONError(w, http.StatusBadRequest, "module is required")
+                return
+        }
+
+        // Devices on a profile unlock with that profile's secrets. The profile
+        // comes from the device token or host, never from X-Config-Profile.
+        cfg, profile := resolvedUnlockConfig(r)
+        var stored string
+        var known bool
+        switch req.Module {
+        case patternUnlockTarget:
+                stored, known = cfg.UnlockPattern, true
+        case totpUnlockTarget:
+                known = true
+        default:
+                stored, known = cfg.ModuleUnlockValues[req.Module]
+                known = known || (req.Module == "calculator" && cfg.CalculatorTOTP)
+        }
+
+        if !known {
+                writeJSONError(w, http.StatusNotFound, "Unknown module")
+                return
+        }
+
+        key := unlockAttemptKey(r)
+        if wait := reserveUnlockAttempt(key, cfg); wait > 0 {
+                writeTooManyAttempts(w, wait)
+                return
+        }
+
+        var valid bool
+        switch {
+        case req.Module == totpUnlockTarget:
+                valid = verifyTOTP(cfg.UnlockTOTPSecret, req.Value)
+        case req.Module == "calculator" && cfg.CalculatorTOTP:
+                if m := calculatorTOTPPattern.FindStringSubmatch(req.Value); m != nil {
+                        valid = verifyTOTP(cfg.UnlockTOTPSecret, m[1])
+                }
+        default:
+                valid = stored != "" && req.Value != "" && verifySecret(stored, req.Value)
+        }
+
+        // The duress value is always checked when set, so a duress unlock
+        // takes as long as a normal one.
+        if duress := cfg.ModuleDuressValues[req.Module]; duress != "" && req.Value != "" {
+                if verifySecret(duress, req.Value) && !valid {
+                        valid = true
+                        recordDuress(r, req.Module, cfg)
+                }
+        }
+        if !valid {
+                writeJSON(w, http.StatusOK, map[string]bool{"valid": false})
+                return
+        }
+
+        clearUnlockAttempts(key)
+        token, expires := issueUnlockSession(w, r, cfg, profile)
+        writeJSON(w, http.StatusOK, map[string]interface{}{
+                "valid":     true,
+                "session":   token,
+                "expiresAt": expires,
+        })
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+        "strings"
+        "testing"
+)
+
+func TestHashSecretVerify(t *testing.T) {
+        hashed, err := hashSecret("123456=")
+        if err != nil {
+                t.Fatal(err)
+        }
+        if !isSecretHash(hashed) || strings.Count(hashed, "$") != 5 {
+                t.Fatalf("hashSecret() = %q, want scrypt$N$r$p$salt$hash", hashed)
+        }
+        if strings.Contains(hashed, "123456") {
+                t.Fatalf("hashSecret() = %q contains the plaintext", hashed)
+        }
+
+        tests := []struct {
+                name      string
+                encoded   string
+                candidate string
+                want      bool
+        }{
+                {"match", hashed, "123456=", true},
+                {"wrong value", hashed, "123457=", false},
+                {"empty candidate", hashed, "", false},
+                {"plaintext stored", "123456=", "123456=", false},
+                {"missing part", strings.Join(strings.Split(hashed, "$")[:5], "$"), "123456=", false},
+                {"bad salt", strings.Replace(hashed, strings.Split(hashed, "$")[4], "!!", 1), "123456=", false},
+                {"bad cost", strings.Replace(hashed, "scrypt$32768$", "scrypt$x$", 1), "123456=", false},
+                {"empty", "", "", false},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        if got := verifySecret(tt.encoded, tt.candidate); got != tt.want {
+                                t.Errorf("verifySecret(%q) = %v, want %v", tt.candidate, got, tt.want)
+                        }
+                })
+        }
+}
+
+func TestHashSecretSalted(t *testing.T) {
+        a, err := hashSecret("secret")
+        if err != nil {
+                t.Fatal(err)
+        }
+        b, err := hashSecret("secret")
+        if err != nil {
+                t.Fatal(err)
+        }
+        if a == b {
+                t.Fatalf("hashSecret() returned the same hash twice: %q", a)
+        }
+}
+
+func TestHashUnlockSecret(t *testing.T) {
+        hashed, err := hashSecret("0-4-8-5")
+        if err != nil {
+                t.Fatal(err)
+        }
+
+        tests := []struct {
+                name string
+                in   string
+                keep bool
+        }{
+                {"empty stays empty", "", true},
+                {"hash kept as-is", hashed, true},
+                {"plaintext hashed", "0-4-8-5", false},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        got, err := hashUnlockSecret(tt.in)
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        if tt.keep {
+                                if got != tt.in {
+                                        t.Errorf("hashUnlockSecret(%q) = %q, want it unchanged", tt.in, got)
+                                }
+                                return
+                        }
+                        if !verifySecret(got, tt.in) {
+                                t.Errorf("hashUnlockSecret(%q) = %q, which does not verify", tt.in, got)
+                        }
+                })
+        }
+}
+
+func TestValidateModuleUnlockValue(t *testing.T) {
+        tests := []struct {
+                module  string
+                value   string
+                wantErr bool
+        }{
+                {"calculator", "", false},
+                {"calculator", "1234=", false},
+                {"calculator", "123=", true},
+                {"calculator", "1234", true},
+                {"calculator", "12a4=", true},
+                {"calculator", strings.Repeat("1", maxUnlockValueBytes) + "=", true},
+                {"notepad", "secret", false},
+                {"notepad", "abc", true},
+                {"notepad", "  ab  ", true},
+                {"notepad", " secret", true},
+                {"notepad", "secret\t", true},
+                {"notepad", "my secret", false},
+                {"notepad", "two\nlines", true},
+                {"game-2048", "", false},
+                {"game-2048", "1234", true},
+        }
+        for _, tt := range tests {
+                t.Run(tt.module+"/"+tt.value, func(t *testing.T) {
+                        err := validateModuleUnlockValue(tt.module, tt.value)
+                        if (err != nil) != tt.wantErr {
+                                t.Errorf("validateModuleUnlockValue(%q, %q) error = %v, wantErr %v", tt.module, tt.value, err, tt.wantErr)
+                        }
+                })
+        }
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+        "encoding/json"
+        "log"
+        "math"
+        "net/http"
+        "os"
+        "sort"
+        "strconv"
+        "sync"
+        "time"
+)
+
+// Failed unlock attempts are counted per client IP (see clientIP). The
+// X-Device-Id header is not used: any caller can send any device id, so it
+// would let one client lock out another's device. Each failure doubles the
+// wait before the next attempt, starting at UNLOCK_BACKOFF_SECONDS;
+// UNLOCK_MAX_ATTEMPTS failures lock the key out for UNLOCK_LOCKOUT_MINUTES.
+// An attempt is counted as a failure before the value is checked and cleared
+// if it turns out valid, so concurrent attempts cannot all pass the check
+// before any of them is recorded. Counters are persisted in the data
+// directory so a restart does not reset them.
+const (
+        deviceIDHeader     = "X-Device-Id"
+        unlockAttemptsFile = "unlock_attempts.json"
+        maxDeviceIDLength  = 128
+        maxUnlockAttempts  = 100
+        maxUnlockBackoff   = 60
+)
+
+type unlockAttempts struct {
+        Failures     int       `json:"failures"`
+        LastFailure  time.Time `json:"lastFailure"`
+        BlockedUntil time.Time `json:"blockedUntil,omitempty"`
+        Locked       bool      `json:"locked,omitempty"`
+}
+
+var (
+        unlockAttemptsMu      sync.Mutex
+        unlockAttemptsPath    string
+        unlockAttemptsByKey   = map[string]*unlockAttempts{}
+        unlockAttemptsVersion int64
+
+        // unlockAttemptsSaveMu orders writes of snapshots taken under
+        // unlockAttemptsMu, so the file is written without holding it.
+        unlockAttemptsSaveMu       sync.Mutex
+        unlockAttemptsSavedVersion int64
+)
+
+func loadUnlockAttempts(path string) error {
+        unlockAttemptsMu.Lock()
+        defer unlockAttemptsMu.Unlock()
+
+        unlockAttemptsPath = path
+        data, err := os.ReadFile(path)
+        if os.IsNotExist(err) {
+                return nil
+        }
+        if err != nil {
+                return err
+        }
+
+        plain, _, err := openConfigData(data)
+        if err != nil {
+                return err
+        }
+        if err := json.Unmarshal(plain, &unlockAttemptsByKey); err != nil {
+                return err
+        }
+        if unlockAttemptsByKey == nil {
+                unlockAttemptsByKey = map[string]*unlockAttempts{}
+        }
+        return nil
+}
+
+// unlockAttemptsSnapshot is the serialized state of the counters at one
+// version, written by save after unlockAttemptsMu is released.
+type unlockAttemptsSnapshot struct {
+        path    string
+        data    []byte
+        version int64
+}
+
+// snapshotUnlockAttempts captures the counters after a change. Callers hold
+// unlockAttemptsMu.
+func snapshotUnlockAttempts() unlockAttemptsSnapshot {
+        unlockAttemptsVersion++
+        snap := unlockAttemptsSnapshot{path: unlockAttemptsPath, version: unlockAttemptsVersion}
+        if snap.path == "" {
+                return snap
+        }
+        data, err := json.MarshalIndent(unlockAttemptsByKey, "", "  ")
+        if err != nil {
+                log.Printf("Warning: Could not save unlock attempt counters: %v", err)
+                return snap
+        }
+        snap.data = data
+        return snap
+}
+
+// save persists the snapshot unless a newer one has already been written.
+// Callers must not hold unlockAttemptsMu, so a slow disk does not stall
+// other unlock attempts.
+func (snap unlockAttemptsSnapshot) save() {
+        if snap.data == nil {
+                return
+        }
+        unlockAttemptsSaveMu.Lock()
+        defer unlockAttemptsSaveMu.Unlock()
+        if snap.version <= unlockAttemptsSavedVersion {
+                return
+        }
+
+        data, err := sealConfigData(snap.data)
+        if err == nil {
+                err = writeFileAtomic(snap.path, data, 0600)
+        }
+        if err != nil {
+                log.Printf("Warning: Could not save unlock attempt counters: %v", err)
+                return
+        }
+        unlockAttemptsSavedVersion = snap.version
+}
+
+// requestDeviceID returns the device id sent with r, or "" if there is none
+// or it is too long. The config stream takes it as ?device=, since
+// EventSource cannot set headers.
+func requestDeviceID(r *http.Request) string {
+        device := r.Header.Get(deviceIDHeader)
+        if device == "" {
+                device = r.URL.Query().Get("device")
+        }
+        if len(device) > maxDeviceIDLength {
+                return ""
+        }
+        return device
+}
+
+// unlockAttemptKey identifies the caller by client IP.
+func unlockAttemptKey(r *http.Request) string {
+        return "ip:" + clientIP(r)
+}
+
+// expireUnlockAttempts forgets failures once a lockout has passed or no
+// failure happened for a full lockout period. Callers hold unlockAttemptsMu.
+func expireUnlockAttempts(now time.Time, lockout time.Duration) bool {
+        changed := false
+        for key, a := range unlockAttemptsByKey {
+                if (a.Locked && now.After(a.BlockedUntil)) || now.Sub(a.LastFailure) > lockout {
+                        delete(unlockAttemptsByKey, key)
+                        changed = true
+                }
+        }
+        return changed
+}
+
+// reserveUnlockAttempt returns how long the caller must wait before another
+// attempt, or 0 if it may try now. In that case the attempt has already been
+// recorded as a failure, with its backoff or lockout, until
+// clearUnlockAttempts reports it valid.
+func reserveUnlockAttempt(key string, cfg AppConfig) time.Duration {
+        unlockAttemptsMu.Lock()
+        now := time.Now().UTC()
+        changed := expireUnlockAttempts(now, time.Duration(cfg.UnlockLockoutMinutes)*time.Minute)
+
+        var wait time.Duration
+        if a, ok := unlockAttemptsByKey[key]; ok && a.BlockedUntil.After(now) {
+                wait = a.BlockedUntil.Sub(now)
+        } else {
+                recordUnlockFailure(key, cfg, now)
+                changed = true
+        }
+        var snap unlockAttemptsSnapshot
+        if changed {
+                snap = snapshotUnlockAttempts()
+        }
+        unlockAttemptsMu.Unlock()
+
+        snap.save()
+        return wait
+}
+
+// clearUnlockAttempts resets the counter after a valid attempt.
+func clearUnlockAttempts(key string) {
+        unlockAttemptsMu.Lock()
+        delete(unlockAttemptsByKey, key)
+        snap := snapshotUnlockAttempts()
+        unlockAttemptsMu.Unlock()
+
+        snap.save()
+}
+
+// recordUnlockFailure applies backoff or lockout for one failed attempt.
+// Callers hold unlockAttemptsMu.
+func recordUnlockFailure(key string, cfg AppConfig, now time.Time) {
+        lockout := time.Duration(cfg.UnlockLockoutMinutes) * time.Minute
+        a, ok := unlockAttemptsByKey[key]
+        if !ok {
+                a = &unlockAttempts{}
+                unlockAttemptsByKey[key] = a
+        }
+        a.Failures++
+        a.LastFailure = now
+
+        if a.Failures >= cfg.UnlockMaxAttempts {
+                a.Locked = true
+                a.BlockedUntil = now.Add(lockout)
+                log.Printf("Warning: Unlock attempts locked out for %s after %d failures", key, a.Failures)
+                return
+        }
+
+        delay := float64(cfg.UnlockBackoffSeconds) * math.Pow(2, float64(a.Failures-1))
+        backoff := time.Duration(delay * float64(time.Second))
+        if backoff > lockout {
+                backoff = lockout
+        }
+        a.BlockedUntil = now.Add(backoff)
+}
+
+func writeTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+        seconds := int(math.Ceil(wait.Seconds()))
+        w.Header().Set("Retry-After", strconv.Itoa(seconds))
+        writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+                "error":      "Too many unlock attempts",
+                "retryAfter": seconds,
+        })
+}
+
+// handleUnlockAttempts lists the active counters; DELETE clears them all or
+// only the one named by ?key=.
+func handleUnlockAttempts(w http.ResponseWriter, r *http.Request) {
+        switch r.Method {
+        case "GET":
+                type entry struct {
+                        Key string `json:"key"`
+                        unlockAttempts
+                }
+                entries := []entry{}
+                unlockAttemptsMu.Lock()
+                for key, a := range unlockAttemptsByKey {
+                        entries = append(entries, entry{Key: key, unlockAttempts: *a})
+                }
+                unlockAttemptsMu.Unlock()
+                sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+                writeJSON(w, http.StatusOK, map[string]interface{}{"attempts": entries})
+
+        case "DELETE":
+                key := r.URL.Query().Get("key")
+                unlockAttemptsMu.Lock()
+                if _, ok := unlockAttemptsByKey[key]; key != "" && !ok {
+                        unlockAttemptsMu.Unlock()
+                        writeJSONError(w, http.StatusNotFound, "Unknown key")
+                        return
+                }
+                if key != "" {
+                        delete(unlockAttemptsByKey, key)
+                } else {
+                        unlockAttemptsByKey = map[string]*unlockAttempts{}
+                }
+                snap := snapshotUnlockAttempts()
+                unlockAttemptsMu.Unlock()
+
+                snap.save()
+                w.WriteHeader(http.StatusNoContent)
+
+        default:
+                http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        }
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+        "net/http"
+        "net/http/httptest"
+        "strings"
+        "sync"
+        "testing"
+        "time"
+)
+
+// withUnlockConfig makes cfg, with its secrets hashed, the live config and
+// clears the attempt counters for the duration of the test.
+func withUnlockConfig(t *testing.T, cfg AppConfig) {
+        t.Helper()
+        if _, err := hashPlaintextSecrets(&cfg); err != nil {
+                t.Fatal(err)
+        }
+        previous := currentAppConfig()
+        setAppConfig(cfg)
+
+        unlockAttemptsMu.Lock()
+        unlockAttemptsByKey = map[string]*unlockAttempts{}
+        unlockAttemptsMu.Unlock()
+
+        t.Cleanup(func() {
+                setAppConfig(previous)
+                unlockAttemptsMu.Lock()
+                unlockAttemptsByKey = map[string]*unlockAttempts{}
+                unlockAttemptsMu.Unlock()
+        })
+}
+
+func unlockRequest(value, device string) *http.Request {
+        r := httptest.NewRequest("POST", "/api/unlock/verify", strings.NewReader(`{"module":"calculator","value":"`+value+`"}`))
+        r.RemoteAddr = "192.0.2.1:4000"
+        if device != "" {
+                r.Header.Set(deviceIDHeader, device)
+        }
+        return r
+}
+
+func TestUnlockConcurrentLockout(t *testing.T) {
+        tests := []struct {
+                name        string
+                maxAttempts int
+                backoff     int
+                concurrent  int
+                wantChecked int // attempts that got past the limiter
+        }{
+                {"lockout without backoff", 3, 0, 20, 3},
+                {"single attempt", 1, 0, 10, 1},
+                {"backoff admits one", 5, 1, 10, 1},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        cfg := defaultAppConfig()
+                        cfg.UnlockMaxAttempts = tt.maxAttempts
+                        cfg.UnlockBackoffSeconds = tt.backoff
+                        withUnlockConfig(t, cfg)
+
+                        var wg sync.WaitGroup
+                        codes := make(chan int, tt.concurrent)
+                        for i := 0; i < tt.concurrent; i++ {
+                                wg.Add(1)
+                                go func() {
+                                        defer wg.Done()
+                                        w := httptest.NewRecorder()
+                                        handleUnlockVerify(w, unlockRequest("0000=", "device-1"))
+                                        codes <- w.Code
+                                }()
+                        }
+                        wg.Wait()
+                        close(codes)
+
+                        checked := 0
+                        for code := range codes {
+                                switch code {
+                                case http.StatusOK:
+                                        checked++
+                                case http.StatusTooManyRequests:
+                                default:
+                                        t.Fatalf("unexpected status %d", code)
+                                }
+                        }
+                        if checked != tt.wantChecked {
+                                t.Errorf("%d attempts were checked, want %d", checked, tt.wantChecked)
+                        }
+
+                        unlockAttemptsMu.Lock()
+                        a := unlockAttemptsByKey["ip:192.0.2.1"]
+                        unlockAttemptsMu.Unlock()
+                        if a == nil || a.Failures != tt.wantChecked {
+                                t.Errorf("counters = %+v, want %d failures", a, tt.wantChecked)
+                        }
+                })
+        }
+}
+
+func TestUnlockValidAttemptClearsReservation(t *testing.T) {
+        cfg := defaultAppConfig()
+        cfg.UnlockMaxAttempts = 2
+        cfg.UnlockBackoffSeconds = 0
+        withUnlockConfig(t, cfg)
+        sessionKey = make([]byte, sessionKeyLen)
+
+        tests := []struct {
+                value    string
+                wantCode int
+        }{
+                {"0000=", http.StatusOK},
+                {"123456=", http.StatusOK}, // the last attempt still unlocks
+                {"0000=", http.StatusOK},
+                {"0000=", http.StatusOK},
+                {"123456=", http.StatusTooManyRequests},
+        }
+        for i, tt := range tests {
+                w := httptest.NewRecorder()
+                handleUnlockVerify(w, unlockRequest(tt.value, "device-2"))
+                if w.Code != tt.wantCode {
+                        t.Fatalf("attempt %d (%s): status %d, want %d", i+1, tt.value, w.Code, tt.wantCode)
+                }
+        }
+}
+
+func TestUnlockLockoutIgnoresDeviceID(t *testing.T) {
+        cfg := defaultAppConfig()
+        cfg.UnlockMaxAttempts = 1
+        cfg.UnlockBackoffSeconds = 0
+        withUnlockConfig(t, cfg)
+
+        // A failure sent with another client's device id must not lock out
+        // that client.
+        attacker := unlockRequest("0000=", "victim")
+        attacker.RemoteAddr = "198.51.100.7:4000"
+        handleUnlockVerify(httptest.NewRecorder(), attacker)
+
+        tests := []struct {
+                name     string
+                addr     string
+                device   string
+                wantCode int
+        }{
+                {"victim from its own address", "192.0.2.1:4000", "victim", http.StatusOK},
+                {"attacker with a fresh device id", "198.51.100.7:4000", "other", http.StatusTooManyRequests},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        r := unlockRequest("0000=", tt.device)
+                        r.RemoteAddr = tt.addr
+                        w := httptest.NewRecorder()
+                        handleUnlockVerify(w, r)
+                        if w.Code != tt.wantCode {
+                                t.Errorf("status %d, want %d", w.Code, tt.wantCode)
+                        }
+                })
+        }
+}
+
+func TestScryptConcurrencyLimit(t *testing.T) {
+        for i := 0; i < maxConcurrentScrypt; i++ {
+                scryptSlots <- struct{}{}
+        }
+
+        done := make(chan struct{})
+        go func() {
+                hashSecret("1234=")
+                close(done)
+        }()
+
+        select {
+        case <-done:
+                t.Fatal("scrypt ran with every slot taken")
+        case <-time.After(100 * time.Millisecond):
+        }
+
+        for i := 0; i < maxConcurrentScrypt; i++ {
+                <-scryptSlots
+        }
+        select {
+        case <-done:
+        case <-time.After(5 * time.Second):
+                t.Fatal("scrypt did not run once slots were free")
+        }
+}
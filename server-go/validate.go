@@ -0,0 +1,676 @@
+package main
+
+import (
+        "bytes"
+        "encoding/json"
+        "errors"
+        "fmt"
+        "net"
+        "net/http"
+        "net/url"
+        "regexp"
+        "sort"
+        "strconv"
+        "strings"
+)
+
+const (
+        minUnlockFingers    = 3
+        maxUnlockFingers    = 9
+        maxAutoLockMinutes  = 1440
+        minPatternLength    = 4
+        patternGridSize     = 3
+        maxUnlockValueBytes = 64
+        // Shorter values are not sent to /api/unlock/verify by the client,
+        // so they could never unlock.
+        minUnlockValueLength = 4
+)
+
+var (
+        knownModules   = []string{"game-2048", "calculator", "notepad"}
+        unlockGestures = []string{"patternUnlock", "severalFingers", "totp"}
+
+        originValidationModes = []string{"disabled", "same-host", "host-whitelist", "pattern-whitelist"}
+        originSchemes         = []string{"http", "https"}
+
+        calculatorUnlockPattern = regexp.MustCompile(`^[0-9]{4,}=$`)
+        hostLabelPattern        = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+)
+
+type fieldError struct {
+        Field   string `json:"field"`
+        Message string `json:"message"`
+}
+
+type validationErrors []fieldError
+
+func (e *validationErrors) add(field, format string, args ...interface{}) {
+        *e = append(*e, fieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func writeValidationErrors(w http.ResponseWriter, errs validationErrors) {
+        writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+                "error":  "Validation failed",
+                "fields": errs,
+        })
+}
+
+func contains(list []string, v string) bool {
+        for _, item := range list {
+                if item == v {
+                        return true
+                }
+        }
+        return false
+}
+
+// decodeField strictly decodes one update value. JSON null and values of the
+// wrong type are reported as field errors instead of being ignored.
+func decodeField(field string, raw json.RawMessage, dst interface{}, errs *validationErrors) bool {
+        if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+                errs.add(field, "must not be null")
+                return false
+        }
+
+        dec := json.NewDecoder(bytes.NewReader(raw))
+        dec.DisallowUnknownFields()
+        if err := dec.Decode(dst); err != nil {
+                var typeErr *json.UnmarshalTypeError
+                if errors.As(err, &typeErr) {
+                        if typeErr.Field != "" {
+                                field += "." + typeErr.Field
+                        }
+                        errs.add(field, "must be of type %s", jsonTypeName(typeErr.Type.Kind().String()))
+                } else {
+                        errs.add(field, "invalid value: %s", strings.TrimPrefix(err.Error(), "json: "))
+                }
+                return false
+        }
+
+        return true
+}
+
+func jsonTypeName(kind string) string {
+        switch kind {
+        case "bool":
+                return "boolean"
+        case "int", "int64":
+                return "integer"
+        case "map", "struct":
+                return "object"
+        case "slice":
+                return "array"
+        }
+        return kind
+}
+
+// validatePattern checks the unlock pattern grammar: dash-separated cells of
+// the 3x3 grid (0-8), no repeats, at least minPatternLength cells, and no
+// jump over a cell that has not been visited yet.
+func validatePattern(pattern string) error {
+        parts := strings.Split(pattern, "-")
+        if len(parts) < minPatternLength {
+                return fmt.Errorf("must connect at least %d points", minPatternLength)
+        }
+
+        cells := patternGridSize * patternGridSize
+        visited := make([]bool, cells)
+        prev := -1
+        for _, part := range parts {
+                cell, err := strconv.Atoi(part)
+                if err != nil || len(part) != 1 || cell < 0 || cell >= cells {
+                        return fmt.Errorf("points must be digits 0-%d separated by '-'", cells-1)
+                }
+                if visited[cell] {
+                        return fmt.Errorf("point %d is used more than once", cell)
+                }
+
+                if prev >= 0 {
+                        pr, pc := prev/patternGridSize, prev%patternGridSize
+                        cr, cc := cell/patternGridSize, cell%patternGridSize
+                        if (pr+cr)%2 == 0 && (pc+cc)%2 == 0 {
+                                mid := (pr+cr)/2*patternGridSize + (pc+cc)/2
+                                if mid != prev && mid != cell && !visited[mid] {
+                                        return fmt.Errorf("path from %d to %d skips unvisited point %d", prev, cell, mid)
+                                }
+                        }
+                }
+
+                visited[cell] = true
+                prev = cell
+        }
+
+        return nil
+}
+
+// validateHost accepts a lowercase DNS name or IP literal with an optional
+// port, as it appears in URL.Host and the Origin header.
+func validateHost(host string) error {
+        name := host
+        if h, port, err := net.SplitHostPort(host); err == nil {
+                n, err := strconv.Atoi(port)
+                if err != nil || n < 1 || n > 65535 {
+                        return fmt.Errorf("invalid port in %q", host)
+                }
+                name = h
+        }
+
+        if net.ParseIP(name) != nil {
+                return nil
+        }
+
+        if name == "" || len(name) > 253 || name != strings.ToLower(name) {
+                return fmt.Errorf("%q is not a valid lowercase host name", host)
+        }
+        for _, label := range strings.Split(name, ".") {
+                if !hostLabelPattern.MatchString(label) {
+                        return fmt.Errorf("%q is not a valid host name", host)
+                }
+        }
+
+        return nil
+}
+
+// validateHostPattern accepts an exact host or a "*.example.com" wildcard,
+// matching what matchHostPattern understands.
+func validateHostPattern(pattern string) error {
+        host := strings.TrimPrefix(pattern, "*.")
+        if strings.Contains(host, "*") {
+                return fmt.Errorf("%q: wildcards are only allowed as a leading \"*.\"", pattern)
+        }
+        return validateHost(host)
+}
+
+func validateHostList(field string, hosts []string, check func(string) error, errs *validationErrors) {
+        for i, host := range hosts {
+                if err := check(host); err != nil {
+                        errs.add(fmt.Sprintf("%s[%d]", field, i), "%v", err)
+                }
+        }
+}
+
+func validateOriginValidation(ov OriginValidationConfig, errs *validationErrors) {
+        if !contains(originValidationModes, ov.Mode) {
+                errs.add("ORIGIN_VALIDATION.mode", "must be one of %s", strings.Join(originValidationModes, ", "))
+        }
+        validateHostList("ORIGIN_VALIDATION.allowedHosts", ov.AllowedHosts, validateHost, errs)
+        validateHostList("ORIGIN_VALIDATION.allowedPatterns", ov.AllowedPatterns, validateHostPattern, errs)
+        for i, scheme := range ov.AllowedSchemes {
+                if !contains(originSchemes, scheme) {
+                        errs.add(fmt.Sprintf("ORIGIN_VALIDATION.allowedSchemes[%d]", i), "must be one of %s", strings.Join(originSchemes, ", "))
+                }
+        }
+        if ov.Mode == "host-whitelist" && len(ov.AllowedHosts) == 0 {
+                errs.add("ORIGIN_VALIDATION.allowedHosts", "must not be empty in host-whitelist mode")
+        }
+        if ov.Mode == "pattern-whitelist" && len(ov.AllowedPatterns) == 0 {
+                errs.add("ORIGIN_VALIDATION.allowedPatterns", "must not be empty in pattern-whitelist mode")
+        }
+}
+
+// mergeModuleValues merges per-module unlock values into dst; a null entry
+// deletes that module's value.
+func mergeModuleValues(key string, raw json.RawMessage, dst *map[string]string, errs *validationErrors) {
+        var values map[string]*string
+        if !decodeField(key, raw, &values, errs) {
+                return
+        }
+        if *dst == nil {
+                *dst = map[string]string{}
+        }
+        for module, v := range values {
+                field := key + "." + module
+                if v == nil {
+                        delete(*dst, module)
+                        continue
+                }
+                value := *v
+                if !contains(knownModules, module) {
+                        errs.add(field, "unknown module; expected one of %s", strings.Join(knownModules, ", "))
+                        continue
+                }
+                if !isSecretHash(value) {
+                        if err := validateModuleUnlockValue(module, value); err != nil {
+                                errs.add(field, "%v", err)
+                                continue
+                        }
+                }
+                (*dst)[module] = value
+        }
+}
+
+// validateDuressValues checks duress values like unlock values and rejects
+// one that matches the module's unlock value. Once both are hashed they can
+// no longer be compared.
+func validateDuressValues(cfg *AppConfig, errs *validationErrors) {
+        for module, value := range cfg.ModuleDuressValues {
+                field := "MODULE_DURESS_VALUES." + module
+                if !contains(knownModules, module) {
+                        errs.add(field, "unknown module")
+                        continue
+                }
+                if isSecretHash(value) {
+                        validateSecretHash(field, value, errs)
+                } else if err := validateModuleUnlockValue(module, value); err != nil {
+                        errs.add(field, "%v", err)
+                        continue
+                }
+                if duressMatchesUnlock(value, cfg.ModuleUnlockValues[module]) {
+                        errs.add(field, "must differ from the module's unlock value")
+                }
+        }
+
+        webhook := cfg.DuressResponse.WebhookURL
+        if webhook != "" {
+                u, err := url.Parse(webhook)
+                if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+                        errs.add("DURESS_RESPONSE.webhookUrl", "must be an absolute http or https URL")
+                }
+        }
+}
+
+// duressMatchesUnlock reports whether a duress value equals the unlock value.
+// Whichever side is plaintext is checked against the other side's hash; two
+// hashes have their own salts and cannot be compared.
+func duressMatchesUnlock(duress, unlock string) bool {
+        if duress == "" || unlock == "" {
+                return false
+        }
+        switch {
+        case !isSecretHash(duress) && !isSecretHash(unlock):
+                return duress == unlock
+        case !isSecretHash(duress):
+                return verifySecret(unlock, duress)
+        case !isSecretHash(unlock):
+                return verifySecret(duress, unlock)
+        }
+        return false
+}
+
+func normalizeHosts(hosts []string) []string {
+        out := make([]string, 0, len(hosts))
+        for _, host := range hosts {
+                out = append(out, strings.ToLower(strings.TrimSpace(host)))
+        }
+        return out
+}
+
+// validateModuleUnlockValue applies the module-specific rules to a plaintext
+// unlock value. Empty values disable the module-specific unlock.
+func validateModuleUnlockValue(module, value string) error {
+        if value == "" {
+                return nil
+        }
+        if len(value) > maxUnlockValueBytes {
+                return fmt.Errorf("must be at most %d bytes", maxUnlockValueBytes)
+        }
+
+        switch module {
+        case "calculator":
+                if !calculatorUnlockPattern.MatchString(value) {
+                        return fmt.Errorf("calculator unlock value must be at least %d digits ending in '='", minUnlockValueLength)
+                }
+        case "notepad":
+                if strings.ContainsAny(value, "\r\n") {
+                        return fmt.Errorf("notepad unlock phrase must be a single line")
+                }
+                // The client trims the line it sends, so such a phrase could never match.
+                if strings.TrimSpace(value) != value {
+                        return fmt.Errorf("notepad unlock phrase must not start or end with whitespace")
+                }
+                if len(value) < minUnlockValueLength {
+                        return fmt.Errorf("notepad unlock phrase must be at least %d characters", minUnlockValueLength)
+                }
+        case "game-2048":
+                return fmt.Errorf("game-2048 uses the universal unlock methods and takes no unlock value")
+        }
+
+        return nil
+}
+
+func validateSecretHash(field, value string, errs *validationErrors) {
+        if value != "" && (!isSecretHash(value) || strings.Count(value, "$") != 5) {
+                errs.add(field, "must be a plaintext value or a valid scrypt hash")
+        }
+}
+
+// applyConfigUpdates decodes and applies a partial config document to cfg.
+// Unlock secrets are validated in plaintext and left unhashed; callers hash
+// them with hashPlaintextSecrets once the whole document validates.
+func applyConfigUpdates(cfg *AppConfig, updates map[string]json.RawMessage) validationErrors {
+        var errs validationErrors
+
+        keys := make([]string, 0, len(updates))
+        for key := range updates {
+                keys = append(keys, key)
+        }
+        sort.Strings(keys)
+
+        for _, key := range keys {
+                raw := updates[key]
+
+                switch key {
+                case "PRIVACY_MODE":
+                        decodeField(key, raw, &cfg.PrivacyMode, &errs)
+                case "DEBUG_MODE":
+                        decodeField(key, raw, &cfg.DebugMode, &errs)
+                case "SELECTED_MODULE":
+                        decodeField(key, raw, &cfg.SelectedModule, &errs)
+                case "CALCULATOR_TOTP":
+                        decodeField(key, raw, &cfg.CalculatorTOTP, &errs)
+                case "UNLOCK_TOTP_SECRET":
+                        errs.add(key, "is managed through /api/admin/unlock/totp")
+                case "UNLOCK_GESTURE":
+                        decodeField(key, raw, &cfg.UnlockGesture, &errs)
+                case "UNLOCK_FINGERS":
+                        decodeField(key, raw, &cfg.UnlockFingers, &errs)
+                case "AUTO_LOCK_MINUTES":
+                        decodeField(key, raw, &cfg.AutoLockMinutes, &errs)
+                case "UNLOCK_MAX_ATTEMPTS":
+                        decodeField(key, raw, &cfg.UnlockMaxAttempts, &errs)
+                case "UNLOCK_BACKOFF_SECONDS":
+                        decodeField(key, raw, &cfg.UnlockBackoffSeconds, &errs)
+                case "UNLOCK_LOCKOUT_MINUTES":
+                        decodeField(key, raw, &cfg.UnlockLockoutMinutes, &errs)
+
+                case "UNLOCK_PATTERN":
+                        var pattern string
+                        if !decodeField(key, raw, &pattern, &errs) {
+                                continue
+                        }
+                        if pattern != "" && !isSecretHash(pattern) {
+                                if err := validatePattern(pattern); err != nil {
+                                        errs.add(key, "%v", err)
+                                        continue
+                                }
+                        }
+                        cfg.UnlockPattern = pattern
+
+                case "MODULE_UNLOCK_VALUES":
+                        mergeModuleValues(key, raw, &cfg.ModuleUnlockValues, &errs)
+                case "MODULE_DURESS_VALUES":
+                        mergeModuleValues(key, raw, &cfg.ModuleDuressValues, &errs)
+                case "DURESS_RESPONSE":
+                        decodeField(key, raw, &cfg.DuressResponse, &errs)
+
+                case "PROXY_CREDENTIALS":
+                        errs.add(key, "is managed through /api/admin/credentials")
+                case "PROXY_CREDENTIAL_RULES":
+                        decodeField(key, raw, &cfg.ProxyCredentialRules, &errs)
+                case "PROXY_ALLOWED_NETWORKS":
+                        decodeField(key, raw, &cfg.ProxyAllowedNetworks, &errs)
+                case "ALLOWED_PROXY_HOSTS":
+                        var policies []ProxyHostPolicy
+                        if decodeField(key, raw, &policies, &errs) {
+                                cfg.AllowedProxyHosts = normalizeProxyHostPolicies(policies)
+                        }
+
+                case "PROFILES":
+                        errs.add(key, "profiles are managed through /api/admin/profiles")
+
+                case "CONFIG_VERSION":
+                        errs.add(key, "is managed by the server")
+
+                case "ORIGIN_VALIDATION":
+                        var ov OriginValidationConfig
+                        if !decodeField(key, raw, &ov, &errs) {
+                                continue
+                        }
+                        ov.AllowedHosts = normalizeHosts(ov.AllowedHosts)
+                        ov.AllowedPatterns = normalizeHosts(ov.AllowedPatterns)
+                        if ov.AllowedSchemes == nil {
+                                ov.AllowedSchemes = []string{}
+                        }
+                        for i, scheme := range ov.AllowedSchemes {
+                                ov.AllowedSchemes[i] = strings.ToLower(scheme)
+                        }
+                        cfg.OriginValidation = ov
+
+                default:
+                        errs.add(key, "unknown field")
+                }
+        }
+
+        return errs
+}
+
+// validateAppConfig checks ranges, enums and stored secret formats of a
+// complete config document.
+func validateAppConfig(cfg *AppConfig) validationErrors {
+        var errs validationErrors
+
+        if !contains(knownModules, cfg.SelectedModule) {
+                errs.add("SELECTED_MODULE", "must be one of %s", strings.Join(knownModules, ", "))
+        }
+        if !contains(unlockGestures, cfg.UnlockGesture) {
+                errs.add("UNLOCK_GESTURE", "must be one of %s", strings.Join(unlockGestures, ", "))
+        }
+        if cfg.UnlockFingers < minUnlockFingers || cfg.UnlockFingers > maxUnlockFingers {
+                errs.add("UNLOCK_FINGERS", "must be between %d and %d", minUnlockFingers, maxUnlockFingers)
+        }
+        if cfg.AutoLockMinutes < 0 || cfg.AutoLockMinutes > maxAutoLockMinutes {
+                errs.add("AUTO_LOCK_MINUTES", "must be between 0 and %d", maxAutoLockMinutes)
+        }
+        if cfg.UnlockMaxAttempts < 1 || cfg.UnlockMaxAttempts > maxUnlockAttempts {
+                errs.add("UNLOCK_MAX_ATTEMPTS", "must be between 1 and %d", maxUnlockAttempts)
+        }
+        if cfg.UnlockBackoffSeconds < 0 || cfg.UnlockBackoffSeconds > maxUnlockBackoff {
+                errs.add("UNLOCK_BACKOFF_SECONDS", "must be between 0 and %d", maxUnlockBackoff)
+        }
+        if cfg.UnlockLockoutMinutes < 1 || cfg.UnlockLockoutMinutes > maxAutoLockMinutes {
+                errs.add("UNLOCK_LOCKOUT_MINUTES", "must be between 1 and %d", maxAutoLockMinutes)
+        }
+        cfg.AllowedProxyHosts = validateProxyHostPolicies(cfg.AllowedProxyHosts, &errs)
+        for i, network := range cfg.ProxyAllowedNetworks {
+                if _, err := parseNetwork(network); err != nil {
+                        errs.add(fmt.Sprintf("PROXY_ALLOWED_NETWORKS[%d]", i), "must be an IP address or CIDR range")
+                }
+        }
+        validateOriginValidation(cfg.OriginValidation, &errs)
+
+        if cfg.UnlockGesture == "patternUnlock" && cfg.UnlockPattern == "" {
+                errs.add("UNLOCK_PATTERN", "is required when UNLOCK_GESTURE is patternUnlock")
+        }
+        if cfg.UnlockTOTPSecret != "" && !isSealedSecret(cfg.UnlockTOTPSecret) {
+                errs.add("UNLOCK_TOTP_SECRET", "must be a sealed secret; enroll through /api/admin/unlock/totp")
+        }
+        if cfg.UnlockTOTPSecret == "" {
+                if cfg.UnlockGesture == "totp" {
+                        errs.add("UNLOCK_GESTURE", "totp requires an enrolled TOTP secret")
+                }
+                if cfg.CalculatorTOTP {
+                        errs.add("CALCULATOR_TOTP", "requires an enrolled TOTP secret")
+                }
+        }
+
+        if cfg.UnlockPattern != "" && !isSecretHash(cfg.UnlockPattern) {
+                if err := validatePattern(cfg.UnlockPattern); err != nil {
+                        errs.add("UNLOCK_PATTERN", "%v", err)
+                }
+        } else {
+                validateSecretHash("UNLOCK_PATTERN", cfg.UnlockPattern, &errs)
+        }
+
+        for module, value := range cfg.ModuleUnlockValues {
+                field := "MODULE_UNLOCK_VALUES." + module
+                if !contains(knownModules, module) {
+                        errs.add(field, "unknown module")
+                        continue
+                }
+                if isSecretHash(value) {
+                        validateSecretHash(field, value, &errs)
+                } else if err := validateModuleUnlockValue(module, value); err != nil {
+                        errs.add(field, "%v", err)
+                }
+        }
+
+        validateDuressValues(cfg, &errs)
+        validateCredentialRules(cfg, &errs)
+        validateProfiles(cfg, &errs)
+
+        return errs
+}
+
+// stageConfigUpdates applies updates to a copy of base and validates the
+// result. Errors for fields that failed to decode are not repeated by the
+// whole-document checks.
+func stageConfigUpdates(base AppConfig, updates map[string]json.RawMessage) (AppConfig, validationErrors, error) {
+        cfg, err := cloneAppConfig(base)
+        if err != nil {
+                return cfg, nil, err
+        }
+
+        errs := applyConfigUpdates(&cfg, updates)
+
+        reported := map[string]bool{}
+        for _, e := range errs {
+                reported[strings.SplitN(e.Field, ".", 2)[0]] = true
+        }
+        for _, e := range validateAppConfig(&cfg) {
+                if !reported[strings.SplitN(e.Field, ".", 2)[0]] {
+                        errs = append(errs, e)
+                }
+        }
+
+        return cfg, errs, nil
+}
+
+// cloneAppConfig deep-copies cfg so updates can be staged and validated
+// without touching the live config.
+func cloneAppConfig(cfg AppConfig) (AppConfig, error) {
+        var out AppConfig
+        data, err := json.Marshal(cfg)
+        if err != nil {
+                return out, err
+        }
+        err = json.Unmarshal(data, &out)
+        return out, err
+}
+
+// configSchema describes the writable config document as JSON Schema. It
+// mirrors the rules in applyConfigUpdates and validateAppConfig.
+func configSchema() map[string]interface{} {
+        moduleValues := map[string]interface{}{}
+        for _, module := range knownModules {
+                prop := map[string]interface{}{"type": "string", "maxLength": maxUnlockValueBytes}
+                switch module {
+                case "calculator":
+                        prop["pattern"] = `^([0-9]{4,}=)?$`
+                        prop["description"] = "At least 4 digits ending in '='"
+                case "notepad":
+                        prop["pattern"] = `^[^\r\n]*$`
+                        prop["description"] = "A single line of at least 4 characters, typed on its own line"
+                case "game-2048":
+                        prop["const"] = ""
+                }
+                moduleValues[module] = prop
+        }
+
+        cells := patternGridSize*patternGridSize - 1
+        return map[string]interface{}{
+                "$schema":              "https://json-schema.org/draft/2020-12/schema",
+                "$id":                  "/api/config/schema",
+                "title":                "Camroid M server config update",
+                "type":                 "object",
+                "additionalProperties": false,
+                "properties": map[string]interface{}{
+                        "PRIVACY_MODE":           map[string]interface{}{"type": "boolean"},
+                        "DEBUG_MODE":             map[string]interface{}{"type": "boolean"},
+                        "SELECTED_MODULE":        map[string]interface{}{"type": "string", "enum": knownModules},
+                        "UNLOCK_GESTURE":         map[string]interface{}{"type": "string", "enum": unlockGestures},
+                        "UNLOCK_FINGERS":         map[string]interface{}{"type": "integer", "minimum": minUnlockFingers, "maximum": maxUnlockFingers},
+                        "CALCULATOR_TOTP":        map[string]interface{}{"type": "boolean", "description": "Calculator unlocks with the current TOTP code followed by '='"},
+                        "AUTO_LOCK_MINUTES":      map[string]interface{}{"type": "integer", "minimum": 0, "maximum": maxAutoLockMinutes},
+                        "UNLOCK_MAX_ATTEMPTS":    map[string]interface{}{"type": "integer", "minimum": 1, "maximum": maxUnlockAttempts},
+                        "UNLOCK_BACKOFF_SECONDS": map[string]interface{}{"type": "integer", "minimum": 0, "maximum": maxUnlockBackoff},
+                        "UNLOCK_LOCKOUT_MINUTES": map[string]interface{}{"type": "integer", "minimum": 1, "maximum": maxAutoLockMinutes},
+                        "UNLOCK_PATTERN": map[string]interface{}{
+                                "type":        "string",
+                                "pattern":     fmt.Sprintf(`^([0-%d](-[0-%d]){%d,%d})?$`, cells, cells, minPatternLength-1, cells),
+                                "description": "Dash-separated 3x3 grid cells (0-8), no repeats, no jumps over unvisited cells",
+                        },
+                        "MODULE_UNLOCK_VALUES": map[string]interface{}{
+                                "type":                 "object",
+                                "properties":           moduleValues,
+                                "additionalProperties": false,
+                        },
+                        "MODULE_DURESS_VALUES": map[string]interface{}{
+                                "type":                 "object",
+                                "properties":           moduleValues,
+                                "additionalProperties": false,
+                                "description":          "Per-module values that unlock normally but trigger DURESS_RESPONSE",
+                        },
+                        "DURESS_RESPONSE": map[string]interface{}{
+                                "type":                 "object",
+                                "additionalProperties": false,
+                                "properties": map[string]interface{}{
+                                        "decoyGallery": map[string]interface{}{"type": "boolean"},
+                                        "wipe":         map[string]interface{}{"type": "boolean"},
+                                        "webhookUrl":   map[string]interface{}{"type": "string", "format": "uri"},
+                                },
+                        },
+                        "ALLOWED_PROXY_HOSTS": map[string]interface{}{
+                                "type": "array",
+                                "items": map[string]interface{}{
+                                        "oneOf": []interface{}{
+                                                map[string]interface{}{"type": "string", "format": "hostname"},
+                                                map[string]interface{}{
+                                                        "type":                 "object",
+                                                        "additionalProperties": false,
+                                                        "required":             []string{"host"},
+                                                        "properties": map[string]interface{}{
+                                                                "host":             map[string]interface{}{"type": "string", "format": "hostname"},
+                                                                "methods":          map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "enum": proxyMethods}},
+                                                                "pathPrefixes":     map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+                                                                "pathPatterns":     map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "format": "regex"}},
+                                                                "allowHeaders":     map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+                                                                "denyHeaders":      map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+                                                                "maxRequestBytes":  map[string]interface{}{"type": "integer", "minimum": 0},
+                                                                "maxResponseBytes": map[string]interface{}{"type": "integer", "minimum": 0},
+                                                                "httpsOnly":        map[string]interface{}{"type": "boolean"},
+                                                        },
+                                                },
+                                        },
+                                },
+                                "description": "Hosts /api/proxy may reach, optionally with :port, each either a host name or a policy restricting methods, paths, headers and body sizes",
+                        },
+                        "PROXY_ALLOWED_NETWORKS": map[string]interface{}{
+                                "type":        "array",
+                                "items":       map[string]interface{}{"type": "string"},
+                                "description": "IP addresses or CIDR ranges the proxy may reach despite being loopback, private, link-local or metadata addresses",
+                        },
+                        "PROXY_CREDENTIAL_RULES": map[string]interface{}{
+                                "type": "array",
+                                "items": map[string]interface{}{
+                                        "type":                 "object",
+                                        "additionalProperties": false,
+                                        "required":             []string{"host", "credential", "in"},
+                                        "properties": map[string]interface{}{
+                                                "host":       map[string]interface{}{"type": "string", "format": "hostname"},
+                                                "credential": map[string]interface{}{"type": "string", "pattern": credentialNamePattern.String()},
+                                                "in":         map[string]interface{}{"type": "string", "enum": credentialLocations},
+                                                "name":       map[string]interface{}{"type": "string"},
+                                                "template":   map[string]interface{}{"type": "string"},
+                                        },
+                                },
+                                "description": "Where /api/proxy and /api/imgbb inject each stored credential",
+                        },
+                        "ORIGIN_VALIDATION": map[string]interface{}{
+                                "type":                 "object",
+                                "additionalProperties": false,
+                                "required":             []string{"mode"},
+                                "properties": map[string]interface{}{
+                                        "mode":            map[string]interface{}{"type": "string", "enum": originValidationModes},
+                                        "allowedHosts":    map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+                                        "allowedPatterns": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "pattern": `^(\*\.)?[a-z0-9.-]+(:[0-9]+)?$`}},
+                                        "allowedSchemes":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string", "enum": originSchemes}},
+                                },
+                        },
+                },
+        }
+}
+
+func handleConfigSchema(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/schema+json")
+        json.NewEncoder(w).Encode(configSchema())
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+        "encoding/json"
+        "reflect"
+        "sort"
+        "testing"
+)
+
+func TestValidatePattern(t *testing.T) {
+        tests := []struct {
+                pattern string
+                wantErr bool
+        }{
+                {"0-4-8-5", false},
+                {"0-1-2-5-8", false},
+                {"1-0-2-3", false}, // 0 to 2 crosses 1, already visited
+                {"0-4-8", true},    // too short
+                {"0-4-8-4", true},  // repeated point
+                {"0-4-8-9", true},  // out of the grid
+                {"0-4-8-a", true},
+                {"00-4-8-5", true},
+                {"0--4-8", true},
+                {"", true},
+                {"0-2-5-8", true}, // 0 to 2 skips unvisited 1
+                {"6-2-1-0", true}, // 6 to 2 skips unvisited 4
+        }
+        for _, tt := range tests {
+                t.Run(tt.pattern, func(t *testing.T) {
+                        err := validatePattern(tt.pattern)
+                        if (err != nil) != tt.wantErr {
+                                t.Errorf("validatePattern(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+                        }
+                })
+        }
+}
+
+func TestValidateHost(t *testing.T) {
+        tests := []struct {
+                host    string
+                wantErr bool
+        }{
+                {"api.imgbb.com", false},
+                {"localhost:5055", false},
+                {"127.0.0.1", false},
+                {"[::1]:443", false},
+                {"API.imgbb.com", true},
+                {"api..com", true},
+                {"-api.com", true},
+                {"api.com:0", true},
+                {"api.com:70000", true},
+                {"api.com:http", true},
+                {"", true},
+                {"*.imgbb.com", true},
+        }
+        for _, tt := range tests {
+                t.Run(tt.host, func(t *testing.T) {
+                        err := validateHost(tt.host)
+                        if (err != nil) != tt.wantErr {
+                                t.Errorf("validateHost(%q) error = %v, wantErr %v", tt.host, err, tt.wantErr)
+                        }
+                })
+        }
+}
+
+func TestValidateHostPattern(t *testing.T) {
+        tests := []struct {
+                pattern string
+                wantErr bool
+        }{
+                {"*.example.com", false},
+                {"example.com", false},
+                {"a.*.example.com", true},
+                {"*example.com", true},
+                {"*.*.example.com", true},
+        }
+        for _, tt := range tests {
+                t.Run(tt.pattern, func(t *testing.T) {
+                        err := validateHostPattern(tt.pattern)
+                        if (err != nil) != tt.wantErr {
+                                t.Errorf("validateHostPattern(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+                        }
+                })
+        }
+}
+
+func TestStageConfigUpdates(t *testing.T) {
+        tests := []struct {
+                name   string
+                update string
+                fields []string // fields reported, in order
+        }{
+                {"valid", `{"AUTO_LOCK_MINUTES": 10, "PRIVACY_MODE": true}`, nil},
+                {"unknown field", `{"NOPE": 1}`, []string{"NOPE"}},
+                {"null", `{"DEBUG_MODE": null}`, []string{"DEBUG_MODE"}},
+                {"wrong type", `{"UNLOCK_FINGERS": "four"}`, []string{"UNLOCK_FINGERS"}},
+                {"out of range", `{"UNLOCK_FINGERS": 2}`, []string{"UNLOCK_FINGERS"}},
+                {"unknown gesture", `{"UNLOCK_GESTURE": "wave"}`, []string{"UNLOCK_GESTURE"}},
+                {"totp without secret", `{"UNLOCK_GESTURE": "totp"}`, []string{"UNLOCK_GESTURE"}},
+                {"pattern gesture needs pattern", `{"UNLOCK_GESTURE": "patternUnlock", "UNLOCK_PATTERN": ""}`, []string{"UNLOCK_PATTERN"}},
+                {"bad pattern", `{"UNLOCK_PATTERN": "0-1"}`, []string{"UNLOCK_PATTERN"}},
+                {"bad calculator value", `{"MODULE_UNLOCK_VALUES": {"calculator": "12="}}`, []string{"MODULE_UNLOCK_VALUES.calculator"}},
+                {"unknown module", `{"MODULE_UNLOCK_VALUES": {"camera": "1234="}}`, []string{"MODULE_UNLOCK_VALUES.camera"}},
+                {"null module value deletes", `{"MODULE_UNLOCK_VALUES": {"notepad": null}}`, nil},
+                {"duress equals unlock", `{"MODULE_DURESS_VALUES": {"calculator": "123456="}}`, []string{"MODULE_DURESS_VALUES.calculator"}},
+                {"server managed", `{"CONFIG_VERSION": 9, "PROXY_CREDENTIALS": {}}`, []string{"CONFIG_VERSION", "PROXY_CREDENTIALS"}},
+                {"bad host", `{"ALLOWED_PROXY_HOSTS": ["bad host"]}`, []string{"ALLOWED_PROXY_HOSTS[0]"}},
+                {"bad network", `{"PROXY_ALLOWED_NETWORKS": ["10.0.0.0/33"]}`, []string{"PROXY_ALLOWED_NETWORKS[0]"}},
+                {"whitelist needs hosts", `{"ORIGIN_VALIDATION": {"mode": "host-whitelist"}}`, []string{"ORIGIN_VALIDATION.allowedHosts"}},
+                {"bad origin scheme", `{"ORIGIN_VALIDATION": {"mode": "disabled", "allowedSchemes": ["ftp"]}}`, []string{"ORIGIN_VALIDATION.allowedSchemes[0]"}},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        var updates map[string]json.RawMessage
+                        if err := json.Unmarshal([]byte(tt.update), &updates); err != nil {
+                                t.Fatal(err)
+                        }
+
+                        _, errs, err := stageConfigUpdates(defaultAppConfig(), updates)
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        var fields []string
+                        for _, e := range errs {
+                                fields = append(fields, e.Field)
+                        }
+                        sort.Strings(fields)
+                        if !reflect.DeepEqual(fields, tt.fields) {
+                                t.Errorf("errors = %+v, want fields %v", errs, tt.fields)
+                        }
+                })
+        }
+}
+
+func TestValidateDuressValues(t *testing.T) {
+        hash := func(plain string) string {
+                h, err := hashSecret(plain)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                return h
+        }
+
+        tests := []struct {
+                name    string
+                unlock  string
+                duress  string
+                wantErr bool
+        }{
+                {"plaintext pair equal", "123456=", "123456=", true},
+                {"plaintext pair differ", "123456=", "654321=", false},
+                {"hashed unlock, plaintext duress", hash("123456="), "123456=", true},
+                {"hashed duress, plaintext unlock", "123456=", hash("123456="), true},
+                {"hashed duress differs", "123456=", hash("654321="), false},
+                {"both hashed", hash("123456="), hash("123456="), false},
+                {"no unlock value", "", hash("123456="), false},
+        }
+        for _, tt := range tests {
+                t.Run(tt.name, func(t *testing.T) {
+                        cfg := AppConfig{
+                                ModuleUnlockValues: map[string]string{"calculator": tt.unlock},
+                                ModuleDuressValues: map[string]string{"calculator": tt.duress},
+                        }
+                        var errs validationErrors
+                        validateDuressValues(&cfg, &errs)
+                        if (len(errs) > 0) != tt.wantErr {
+                                t.Errorf("errors = %+v, wantErr %v", errs, tt.wantErr)
+                        }
+                })
+        }
+}
+
+func TestStageConfigUpdatesLeavesBase(t *testing.T) {
+        base := defaultAppConfig()
+        updates := map[string]json.RawMessage{
+                "MODULE_UNLOCK_VALUES": json.RawMessage(`{"notepad": "changed"}`),
+        }
+        cfg, errs, err := stageConfigUpdates(base, updates)
+        if err != nil || len(errs) > 0 {
+                t.Fatalf("stageConfigUpdates() = %v, %v", errs, err)
+        }
+        if base.ModuleUnlockValues["notepad"] != "secret" {
+                t.Errorf("base notepad value = %q, want it unchanged", base.ModuleUnlockValues["notepad"])
+        }
+        if cfg.ModuleUnlockValues["notepad"] != "changed" {
+                t.Errorf("staged notepad value = %q, want %q", cfg.ModuleUnlockValues["notepad"], "changed")
+        }
+}